@@ -0,0 +1,163 @@
+// Package archive stores published mutation events durably, for replay and forensics, and replays them into
+// handlers or topics.
+//
+// Events are stored as gzip compressed JSONL objects partitioned by the date of the event and its resource type:
+//
+//	dt=2025-01-02/resource_type=notebook/20250102T130405.123456789Z-1a2b3c4d5e6f7a8b.jsonl.gz
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Iknite-Space/psss/models"
+)
+
+const (
+	datePrefix         = "dt="
+	resourceTypePrefix = "resource_type="
+	objectSuffix       = ".jsonl.gz"
+	dateLayout         = "2006-01-02"
+	objectTimeLayout   = "20060102T150405.000000000Z"
+)
+
+// Record is an archived mutation event.
+type Record struct {
+	// ContentType is the content type Event must be handled or published with to be decoded again, see
+	// sub.DecodePublishedMutationEvent.
+	ContentType string `json:"content_type"`
+
+	// Event is the envelope of the event as it was published. Encrypted payloads stay encrypted.
+	Event models.PublishedProtoMutationEvent `json:"event"`
+
+	// ArchivedAt is the time at which the event was archived.
+	ArchivedAt time.Time `json:"archived_at"`
+}
+
+// Store is the storage of archived objects. Keys are slash separated paths.
+type Store interface {
+	// Put stores data under key.
+	Put(ctx context.Context, key string, data []byte) error
+
+	// Get returns the data stored under key.
+	Get(ctx context.Context, key string) ([]byte, error)
+
+	// List returns the keys starting with prefix, sorted.
+	List(ctx context.Context, prefix string) ([]string, error)
+}
+
+// partition identifies the objects holding the events of a resource type on a given day.
+type partition struct {
+	date         string
+	resourceType string
+}
+
+// partitionOf returns the partition of a record, by the date of its recordTime.
+func partitionOf(record Record) partition {
+	return partition{date: recordTime(record).UTC().Format(dateLayout), resourceType: record.Event.ResourceType}
+}
+
+// recordTime returns the time of the event of a record, or the time it was archived at if the event has no time.
+func recordTime(record Record) time.Time {
+	if record.Event.EventTime.IsZero() {
+		return record.ArchivedAt
+	}
+	return record.Event.EventTime
+}
+
+// prefix returns the key prefix of the objects of the partition.
+func (p partition) prefix() string {
+	return datePrefix + p.date + "/" + resourceTypePrefix + url.PathEscape(p.resourceType) + "/"
+}
+
+// objectKey returns the key of a new object of the partition, written at now.
+func (p partition) objectKey(now time.Time) (string, error) {
+	suffix := make([]byte, 8)
+	_, err := rand.Read(suffix)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate object key: %w", err)
+	}
+	return p.prefix() + now.UTC().Format(objectTimeLayout) + "-" + hex.EncodeToString(suffix) + objectSuffix, nil
+}
+
+// parseKey returns the partition of an object key. It reports false if key is not the key of an archive object.
+func parseKey(key string) (partition, bool) {
+	parts := strings.Split(key, "/")
+	if len(parts) != 3 || !strings.HasSuffix(parts[2], objectSuffix) {
+		return partition{}, false
+	}
+
+	date, ok := strings.CutPrefix(parts[0], datePrefix)
+	if !ok {
+		return partition{}, false
+	}
+	escaped, ok := strings.CutPrefix(parts[1], resourceTypePrefix)
+	if !ok {
+		return partition{}, false
+	}
+	resourceType, err := url.PathUnescape(escaped)
+	if err != nil {
+		return partition{}, false
+	}
+
+	return partition{date: date, resourceType: resourceType}, true
+}
+
+// encodeObject encodes records as gzip compressed JSONL.
+func encodeObject(records []Record) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+
+	enc := json.NewEncoder(zw)
+	for _, record := range records {
+		err := enc.Encode(record)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode record: %w", err)
+		}
+	}
+
+	err := zw.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to compress records: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeObject decodes the records of a gzip compressed JSONL object.
+func decodeObject(data []byte) ([]Record, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress object: %w", err)
+	}
+	defer zr.Close()
+
+	var records []Record
+	scanner := bufio.NewScanner(zr)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var record Record
+		err := json.Unmarshal(scanner.Bytes(), &record)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode record: %w", err)
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read object: %w", err)
+	}
+
+	return records, nil
+}
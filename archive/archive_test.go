@@ -0,0 +1,355 @@
+package archive
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Iknite-Space/psss/compression"
+	"github.com/Iknite-Space/psss/models"
+	"github.com/Iknite-Space/psss/pub"
+	"github.com/Iknite-Space/psss/signing"
+	"github.com/Iknite-Space/psss/sub"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	awstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/smithy-go/middleware"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+var day = time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)
+
+// publishedMessage is a message published to SNS.
+type publishedMessage struct {
+	body       string
+	attributes map[string]string
+}
+
+// newRecordingSnsClient returns an SNS client recording the messages it publishes instead of sending them.
+func newRecordingSnsClient(published *[]publishedMessage) *sns.Client {
+	record := middleware.InitializeMiddlewareFunc("record", func(ctx context.Context, in middleware.InitializeInput, _ middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+		input := in.Parameters.(*sns.PublishInput)
+		message := publishedMessage{body: aws.ToString(input.Message), attributes: make(map[string]string)}
+		for name, value := range input.MessageAttributes {
+			message.attributes[name] = aws.ToString(value.StringValue)
+		}
+		*published = append(*published, message)
+		return middleware.InitializeOutput{Result: &sns.PublishOutput{MessageId: aws.String("1")}}, middleware.Metadata{}, nil
+	})
+
+	return sns.New(sns.Options{
+		Region: "us-east-1",
+		APIOptions: []func(*middleware.Stack) error{
+			func(stack *middleware.Stack) error {
+				return stack.Initialize.Add(record, middleware.Before)
+			},
+		},
+	})
+}
+
+// newRecord returns the record of an event of a note.
+func newRecord(eventID string, eventType models.EventType, eventTime time.Time, resourceType string) Record {
+	return Record{
+		ContentType: models.ContentTypeJSON,
+		Event: models.PublishedProtoMutationEvent{
+			EventID:      eventID,
+			EventType:    eventType,
+			EventTime:    eventTime,
+			ResourceType: resourceType,
+			ResourceID:   "1",
+		},
+	}
+}
+
+// newArchiver returns an Archiver writing to a LocalStore in a temporary directory, at the given time.
+func newArchiver(t *testing.T, now time.Time) (*Archiver, *LocalStore) {
+	store := NewLocalStore(t.TempDir())
+	archiver := NewArchiver(store)
+	archiver.now = func() time.Time { return now }
+	return archiver, store
+}
+
+// replayedIDs replays the events selected by filter and returns their IDs.
+func replayedIDs(t *testing.T, replayer *Replayer) []string {
+	t.Helper()
+	var ids []string
+	n, err := replayer.Replay(context.Background(), func(_ context.Context, record Record) error {
+		ids = append(ids, record.Event.EventID)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Replay() = %v", err)
+	}
+	if n != len(ids) {
+		t.Errorf("Replay() = %d, replayed %d events", n, len(ids))
+	}
+	return ids
+}
+
+func TestArchiverWrite(t *testing.T) {
+	now := day.Add(48 * time.Hour)
+	archiver, store := newArchiver(t, now)
+
+	records := []Record{
+		newRecord("1", models.EventTypeCreated, day.Add(time.Hour), "note"),
+		newRecord("2", models.EventTypeCreated, day.Add(2*time.Hour), "notebook"),
+		newRecord("3", models.EventTypeUpdated, day.Add(3*time.Hour), "note"),
+		newRecord("4", models.EventTypeUpdated, day.Add(25*time.Hour), "note"),
+		// without a time, the event is partitioned by the time it is archived at.
+		newRecord("5", models.EventTypeDeleted, time.Time{}, "note folder"),
+	}
+	err := archiver.Write(context.Background(), records)
+	if err != nil {
+		t.Fatalf("Write() = %v", err)
+	}
+
+	keys, err := store.List(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var prefixes []string
+	for _, key := range keys {
+		prefixes = append(prefixes, key[:strings.LastIndex(key, "/")+1])
+	}
+	wantPrefixes := []string{
+		"dt=2025-01-02/resource_type=note/",
+		"dt=2025-01-02/resource_type=notebook/",
+		"dt=2025-01-03/resource_type=note/",
+		"dt=2025-01-04/resource_type=note%20folder/",
+	}
+	if !slices.Equal(prefixes, wantPrefixes) {
+		t.Errorf("objects written to %v, want %v", prefixes, wantPrefixes)
+	}
+
+	data, err := store.Get(context.Background(), keys[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	object, err := decodeObject(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(object) != 2 || object[0].Event.EventID != "1" || object[1].Event.EventID != "3" {
+		t.Errorf("object %s holds %v, want events 1 and 3", keys[0], object)
+	}
+	for _, record := range object {
+		if !record.ArchivedAt.Equal(now) {
+			t.Errorf("event %s archived at %v, want %v", record.Event.EventID, record.ArchivedAt, now)
+		}
+	}
+}
+
+func TestFilterMatch(t *testing.T) {
+	record := newRecord("1", models.EventTypeUpdated, day.Add(time.Hour), "note")
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   bool
+	}{
+		{name: "empty", filter: Filter{}, want: true},
+		{name: "from before", filter: Filter{From: day}, want: true},
+		{name: "from at", filter: Filter{From: day.Add(time.Hour)}, want: true},
+		{name: "from after", filter: Filter{From: day.Add(2 * time.Hour)}, want: false},
+		{name: "to after", filter: Filter{To: day.Add(2 * time.Hour)}, want: true},
+		{name: "to at", filter: Filter{To: day.Add(time.Hour)}, want: false},
+		{name: "resource type", filter: Filter{ResourceTypes: []string{"notebook", "note"}}, want: true},
+		{name: "other resource type", filter: Filter{ResourceTypes: []string{"notebook"}}, want: false},
+		{name: "resource id", filter: Filter{ResourceIDs: []string{"1"}}, want: true},
+		{name: "other resource id", filter: Filter{ResourceIDs: []string{"2"}}, want: false},
+		{name: "event type", filter: Filter{EventTypes: []models.EventType{models.EventTypeUpdated}}, want: true},
+		{name: "other event type", filter: Filter{EventTypes: []models.EventType{models.EventTypeCreated, models.EventTypeDeleted}}, want: false},
+		{
+			name:   "all fields",
+			filter: Filter{From: day, To: day.Add(24 * time.Hour), ResourceTypes: []string{"note"}, ResourceIDs: []string{"1"}, EventTypes: []models.EventType{models.EventTypeUpdated}},
+			want:   true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.filter.Match(record); got != test.want {
+				t.Errorf("Match() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestReplay(t *testing.T) {
+	archiver, store := newArchiver(t, day)
+
+	// the events are archived out of order, in several objects.
+	writes := [][]Record{
+		{
+			newRecord("note-3", models.EventTypeUpdated, day.Add(3*time.Hour), "note"),
+			newRecord("notebook-2", models.EventTypeCreated, day.Add(2*time.Hour), "notebook"),
+			newRecord("note-5", models.EventTypeUpdated, day.Add(25*time.Hour), "note"),
+		},
+		{
+			newRecord("note-1", models.EventTypeCreated, day.Add(time.Hour), "note"),
+			newRecord("note-4", models.EventTypeDeleted, day.Add(4*time.Hour), "note"),
+			newRecord("notebook-0", models.EventTypeCreated, day.Add(-time.Hour), "notebook"),
+		},
+	}
+	for _, records := range writes {
+		err := archiver.Write(context.Background(), records)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []string
+	}{
+		{name: "all", want: []string{"notebook-0", "note-1", "notebook-2", "note-3", "note-4", "note-5"}},
+		{name: "time range", filter: Filter{From: day.Add(time.Hour), To: day.Add(4 * time.Hour)}, want: []string{"note-1", "notebook-2", "note-3"}},
+		{name: "from", filter: Filter{From: day.Add(4 * time.Hour)}, want: []string{"note-4", "note-5"}},
+		{name: "resource type", filter: Filter{ResourceTypes: []string{"note"}}, want: []string{"note-1", "note-3", "note-4", "note-5"}},
+		{name: "event type", filter: Filter{EventTypes: []models.EventType{models.EventTypeCreated}}, want: []string{"notebook-0", "note-1", "notebook-2"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ids := replayedIDs(t, NewReplayer(store).WithFilter(test.filter))
+			if !slices.Equal(ids, test.want) {
+				t.Errorf("replayed %v, want %v", ids, test.want)
+			}
+		})
+	}
+}
+
+func TestReplayRate(t *testing.T) {
+	archiver, store := newArchiver(t, day)
+	err := archiver.Write(context.Background(), []Record{
+		newRecord("1", models.EventTypeCreated, day.Add(time.Hour), "note"),
+		newRecord("2", models.EventTypeUpdated, day.Add(2*time.Hour), "note"),
+		newRecord("3", models.EventTypeUpdated, day.Add(3*time.Hour), "note"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// at 50 events per second, the third event is replayed 40ms after the first one.
+	start := time.Now()
+	ids := replayedIDs(t, NewReplayer(store).WithRate(50))
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("replayed %d events in %v, want at least 40ms", len(ids), elapsed)
+	}
+
+	// the replay stops when the context is cancelled while waiting.
+	ctx, cancel := context.WithCancel(context.Background())
+	n, err := NewReplayer(store).WithRate(1).Replay(ctx, func(context.Context, Record) error {
+		cancel()
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) || n != 1 {
+		t.Errorf("Replay() with a cancelled context = %d, %v, want 1, %v", n, err, context.Canceled)
+	}
+}
+
+func TestArchiveAndReplayToTopic(t *testing.T) {
+	ctx := context.Background()
+	archiver, store := newArchiver(t, day)
+	newStringValue := func() *wrapperspb.StringValue { return &wrapperspb.StringValue{} }
+
+	// an event is published, received by the archiver from its queue, then replayed signed and compressed.
+	var published []publishedMessage
+	publisher := pub.NewPubService[*wrapperspb.StringValue](newRecordingSnsClient(&published), "arn:aws:sns:us-east-1:123456789012:notes").
+		WithEncoding(pub.EncodingProtobufPayload)
+	err := publisher.Publish(ctx, models.ProtoMutationEvent[*wrapperspb.StringValue]{
+		EventType:    models.EventTypeCreated,
+		EventTime:    day.Add(time.Hour),
+		ResourceType: "note",
+		ResourceID:   "1",
+		After:        wrapperspb.String(strings.Repeat("a long note ", 100)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message := awstypes.Message{
+		MessageId:         aws.String("1"),
+		Body:              aws.String(published[0].body),
+		MessageAttributes: make(map[string]awstypes.MessageAttributeValue),
+	}
+	for name, value := range published[0].attributes {
+		message.MessageAttributes[name] = awstypes.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(value)}
+	}
+	result := archiver.BatchHandler()(ctx, []awstypes.Message{message})
+	if len(result.Failures) != 0 {
+		t.Fatalf("BatchHandler() failed: %v", result.Failures)
+	}
+
+	var replayed []publishedMessage
+	signer := signing.NewSigner("key-1", []byte("0123456789abcdef0123456789abcdef"))
+	replayPublisher := pub.NewPubService[proto.Message](newRecordingSnsClient(&replayed), "arn:aws:sns:us-east-1:123456789012:notes").
+		WithSigner(signer).
+		WithCompression(compression.Gzip, 0)
+	n, err := NewReplayer(store).ReplayToTopic(ctx, replayPublisher)
+	if err != nil || n != 1 {
+		t.Fatalf("ReplayToTopic() = %d, %v, want 1, nil", n, err)
+	}
+
+	body, attributes := replayed[0].body, replayed[0].attributes
+	if attributes[models.AttributeContentEncoding] != string(compression.Gzip) {
+		t.Fatalf("replayed event is not compressed, attributes %v", attributes)
+	}
+	compressed, err := base64.StdEncoding.DecodeString(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decompressed, err := compression.Decompress(compression.Gzip, compressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body = string(decompressed)
+
+	verifier := signing.NewVerifier(map[string][]byte{"key-1": []byte("0123456789abcdef0123456789abcdef")})
+	err = verifier.Verify(body, attributes)
+	if err != nil {
+		t.Errorf("Verify() of the replayed event = %v", err)
+	}
+
+	var got models.ProtoMutationEvent[*wrapperspb.StringValue]
+	handler := sub.MutationEventHandlerToStringHandler(func(_ context.Context, e models.ProtoMutationEvent[*wrapperspb.StringValue]) error {
+		got = e
+		return nil
+	}, newStringValue)
+	err = handler(sub.WithMessageInfo(ctx, sub.MessageInfo{MessageAttributes: attributes}), body)
+	if err != nil {
+		t.Fatalf("handler() of the replayed event = %v", err)
+	}
+	if !strings.HasPrefix(got.After.GetValue(), "a long note") || !got.EventTime.Equal(day.Add(time.Hour)) {
+		t.Errorf("replayed event = %+v", got)
+	}
+}
+
+func TestReplayToHandler(t *testing.T) {
+	archiver, store := newArchiver(t, day)
+	record := newRecord("1", models.EventTypeDeleted, day.Add(time.Hour), "note")
+	record.Event.Before = []byte(`"deleted note"`)
+	err := archiver.Write(context.Background(), []Record{record})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got models.ProtoMutationEvent[*wrapperspb.StringValue]
+	handler := sub.MutationEventHandlerToStringHandler(func(_ context.Context, e models.ProtoMutationEvent[*wrapperspb.StringValue]) error {
+		got = e
+		return nil
+	}, func() *wrapperspb.StringValue { return &wrapperspb.StringValue{} })
+	n, err := NewReplayer(store).ReplayToHandler(context.Background(), handler)
+	if err != nil || n != 1 {
+		t.Fatalf("ReplayToHandler() = %d, %v, want 1, nil", n, err)
+	}
+	if got.EventID != "1" || got.EventType != models.EventTypeDeleted || got.Before.GetValue() != "deleted note" {
+		t.Errorf("replayed event = %+v", got)
+	}
+}
@@ -0,0 +1,162 @@
+package archive
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Iknite-Space/psss/sub"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	awstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/rs/zerolog"
+)
+
+const (
+	defaultArchiveBatchSize = 100
+	defaultArchiveBatchWait = 10 * time.Second
+)
+
+// Archiver writes records to a Store, one object per partition and write.
+type Archiver struct {
+	store  Store
+	logger zerolog.Logger
+	now    func() time.Time
+}
+
+// NewArchiver creates an Archiver writing to store.
+func NewArchiver(store Store) *Archiver {
+	return &Archiver{
+		store:  store,
+		logger: zerolog.Nop(),
+		now:    time.Now,
+	}
+}
+
+// WithLogger sets the logger for the Archiver.
+func (a *Archiver) WithLogger(logger zerolog.Logger) *Archiver {
+	a.logger = logger
+	return a
+}
+
+// Write archives records. Records without an ArchivedAt time are stamped with the current time.
+func (a *Archiver) Write(ctx context.Context, records []Record) error {
+	for _, err := range a.write(ctx, records) {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// write archives records and returns the error of each of them, nil for those that were archived.
+func (a *Archiver) write(ctx context.Context, records []Record) []error {
+	now := a.now()
+	errs := make([]error, len(records))
+
+	var partitions []partition
+	indexes := make(map[partition][]int)
+	for i := range records {
+		if records[i].ArchivedAt.IsZero() {
+			records[i].ArchivedAt = now
+		}
+		p := partitionOf(records[i])
+		if _, ok := indexes[p]; !ok {
+			partitions = append(partitions, p)
+		}
+		indexes[p] = append(indexes[p], i)
+	}
+
+	for _, p := range partitions {
+		batch := make([]Record, 0, len(indexes[p]))
+		for _, i := range indexes[p] {
+			batch = append(batch, records[i])
+		}
+
+		err := a.writePartition(ctx, p, now, batch)
+		if err != nil {
+			a.logger.Error().Err(err).Str("partition", p.prefix()).Int("count", len(batch)).Msg("Failed to archive events")
+			for _, i := range indexes[p] {
+				errs[i] = err
+			}
+			continue
+		}
+		a.logger.Debug().Str("partition", p.prefix()).Int("count", len(batch)).Msg("Archived events")
+	}
+
+	return errs
+}
+
+func (a *Archiver) writePartition(ctx context.Context, p partition, now time.Time, records []Record) error {
+	key, err := p.objectKey(now)
+	if err != nil {
+		return err
+	}
+
+	data, err := encodeObject(records)
+	if err != nil {
+		return err
+	}
+
+	err = a.store.Put(ctx, key, data)
+	if err != nil {
+		return fmt.Errorf("failed to store %s: %w", key, err)
+	}
+	return nil
+}
+
+// BatchHandler returns a batch handler archiving the mutation events of the messages it receives, in any of the
+// supported encodings, delivered raw or wrapped in SNS notifications. Messages that can't be decoded or archived
+// are reported as failures, so they are retried or moved to the dead letter queue.
+func (a *Archiver) BatchHandler() sub.SqsBatchHandlerFn {
+	return func(ctx context.Context, messages []awstypes.Message) sub.BatchResult {
+		var result sub.BatchResult
+
+		records := make([]Record, 0, len(messages))
+		indexes := make([]int, 0, len(messages))
+		for i, message := range messages {
+			record, err := a.decodeMessage(ctx, message)
+			if err != nil {
+				a.logger.Error().Err(err).Str("message_id", aws.ToString(message.MessageId)).Msg("Failed to decode event to archive")
+				result.Fail(i, err)
+				continue
+			}
+			records = append(records, record)
+			indexes = append(indexes, i)
+		}
+
+		for j, err := range a.write(ctx, records) {
+			if err != nil {
+				result.Fail(indexes[j], err)
+			}
+		}
+
+		return result
+	}
+}
+
+// decodeMessage decodes the mutation event of an SQS message into a Record.
+func (a *Archiver) decodeMessage(ctx context.Context, message awstypes.Message) (Record, error) {
+	var record Record
+	handler := sub.StringHandlerToAutoDetectSqsHandler(func(ctx context.Context, body string) error {
+		info, _ := sub.MessageInfoFromContext(ctx)
+
+		var err error
+		record.Event, record.ContentType, err = sub.DecodePublishedMutationEvent(body, info.MessageAttributes)
+		if err != nil {
+			return fmt.Errorf("failed to decode mutation event: %w", err)
+		}
+		return nil
+	})
+
+	err := handler(ctx, message)
+	return record, err
+}
+
+// NewSqsProcessor creates a batch processor archiving the mutation events received from the queue, see
+// Archiver.BatchHandler. Events are written in batches of up to 100 messages, or every 10 seconds.
+func NewSqsProcessor(svc *sqs.Client, queueURL string, archiver *Archiver) *sub.SqsEventProcessor {
+	return sub.NewSqsBatchEventProcessor(svc, queueURL, archiver.BatchHandler()).
+		WithLogger(archiver.logger).
+		WithBatchWindow(defaultArchiveBatchSize, defaultArchiveBatchWait)
+}
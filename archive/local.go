@@ -0,0 +1,98 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// LocalStore is a Store keeping objects in a directory of the local file system, e.g. for tests and local
+// development.
+type LocalStore struct {
+	dir string
+}
+
+var _ Store = (*LocalStore)(nil)
+
+// NewLocalStore creates a LocalStore keeping objects under dir, which is created if needed.
+func NewLocalStore(dir string) *LocalStore {
+	return &LocalStore{dir: dir}
+}
+
+// Put implements Store. The object is written to a temporary file first, so that readers never see it partially
+// written.
+func (s *LocalStore) Put(_ context.Context, key string, data []byte) error {
+	path := filepath.Join(s.dir, filepath.FromSlash(key))
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write object: %w", err)
+	}
+
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return fmt.Errorf("failed to rename object: %w", err)
+	}
+	return nil
+}
+
+// Get implements Store.
+func (s *LocalStore) Get(_ context.Context, key string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, filepath.FromSlash(key)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read object: %w", err)
+	}
+	return data, nil
+}
+
+// List implements Store.
+func (s *LocalStore) List(_ context.Context, prefix string) ([]string, error) {
+	var keys []string
+	err := filepath.WalkDir(s.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".tmp-") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.dir, path)
+		if err != nil {
+			return err
+		}
+		if key := filepath.ToSlash(rel); strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects: %w", err)
+	}
+
+	sort.Strings(keys)
+	return keys, nil
+}
@@ -0,0 +1,245 @@
+package archive
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/Iknite-Space/psss/models"
+	"github.com/Iknite-Space/psss/sub"
+	"github.com/rs/zerolog"
+)
+
+// Filter selects the archived events to replay. Zero fields match every event.
+type Filter struct {
+	// From and To bound the EventTime of the events, From inclusive and To exclusive.
+	From time.Time
+	To   time.Time
+
+	ResourceTypes []string
+	ResourceIDs   []string
+	EventTypes    []models.EventType
+}
+
+// Match reports whether record is selected by the filter.
+func (f Filter) Match(record Record) bool {
+	event := record.Event
+	if !f.From.IsZero() && event.EventTime.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !event.EventTime.Before(f.To) {
+		return false
+	}
+	if len(f.ResourceTypes) > 0 && !slices.Contains(f.ResourceTypes, event.ResourceType) {
+		return false
+	}
+	if len(f.ResourceIDs) > 0 && !slices.Contains(f.ResourceIDs, event.ResourceID) {
+		return false
+	}
+	if len(f.EventTypes) > 0 && !slices.Contains(f.EventTypes, event.EventType) {
+		return false
+	}
+	return true
+}
+
+// matchPartition reports whether the partition may hold events selected by the filter.
+func (f Filter) matchPartition(p partition) bool {
+	if len(f.ResourceTypes) > 0 && !slices.Contains(f.ResourceTypes, p.resourceType) {
+		return false
+	}
+	// partitions of events without a time are dated by their archive time, so they can't be pruned by date.
+	date, err := time.Parse(dateLayout, p.date)
+	if err != nil {
+		return true
+	}
+	if !f.From.IsZero() && date.Before(f.From.UTC().Truncate(24*time.Hour)) {
+		return false
+	}
+	if !f.To.IsZero() && !date.Before(f.To.UTC()) {
+		return false
+	}
+	return true
+}
+
+// ReplayFn is called with each replayed record.
+type ReplayFn func(ctx context.Context, record Record) error
+
+// EnvelopePublisher publishes serialized envelopes, such as pub.SNSPublisher.
+type EnvelopePublisher interface {
+	PublishEnvelope(ctx context.Context, body string, attributes map[string]string) error
+}
+
+// Replayer reads archived events back from a Store and feeds the ones selected by its filter to a function, a
+// handler or a topic.
+//
+// Events are replayed one day at a time, in the order of their EventTime, or of the time they were archived at for
+// events without one. The selected events of a day are held in memory while they are replayed.
+type Replayer struct {
+	store  Store
+	filter Filter
+	rate   float64
+	logger zerolog.Logger
+}
+
+// NewReplayer creates a Replayer reading from store.
+func NewReplayer(store Store) *Replayer {
+	return &Replayer{
+		store:  store,
+		logger: zerolog.Nop(),
+	}
+}
+
+// WithFilter sets the filter selecting the events to replay. Defaults to every event.
+func (r *Replayer) WithFilter(filter Filter) *Replayer {
+	r.filter = filter
+	return r
+}
+
+// WithRate limits the replay to perSecond events per second. Defaults to no limit.
+func (r *Replayer) WithRate(perSecond float64) *Replayer {
+	r.rate = perSecond
+	return r
+}
+
+// WithLogger sets the logger for the Replayer.
+func (r *Replayer) WithLogger(logger zerolog.Logger) *Replayer {
+	r.logger = logger
+	return r
+}
+
+// Replay calls fn with each selected record. It stops at the first error fn returns, and returns the number of
+// records fn was called with successfully.
+func (r *Replayer) Replay(ctx context.Context, fn ReplayFn) (int, error) {
+	days, err := r.keys(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var tick <-chan time.Time
+	if r.rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / r.rate))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	replayed := 0
+	for _, keys := range days {
+		records, err := r.read(ctx, keys)
+		if err != nil {
+			return replayed, err
+		}
+
+		for _, record := range records {
+			if tick != nil && replayed > 0 {
+				select {
+				case <-tick:
+				case <-ctx.Done():
+					return replayed, ctx.Err()
+				}
+			}
+
+			err := fn(ctx, record)
+			if err != nil {
+				return replayed, fmt.Errorf("failed to replay event %s: %w", record.Event.EventID, err)
+			}
+			replayed++
+		}
+		r.logger.Debug().Int("objects", len(keys)).Int("replayed", replayed).Msg("Replayed archived day")
+	}
+
+	return replayed, nil
+}
+
+// read returns the selected records of the objects at keys, sorted by time.
+func (r *Replayer) read(ctx context.Context, keys []string) ([]Record, error) {
+	var records []Record
+	for _, key := range keys {
+		data, err := r.store.Get(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		decoded, err := decodeObject(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", key, err)
+		}
+
+		for _, record := range decoded {
+			if r.filter.Match(record) {
+				records = append(records, record)
+			}
+		}
+	}
+
+	slices.SortStableFunc(records, func(a, b Record) int {
+		return recordTime(a).Compare(recordTime(b))
+	})
+	return records, nil
+}
+
+// keys returns the keys of the objects that may hold selected events, grouped by day. When the filter has a
+// complete time range only the partitions of the days in that range are listed.
+func (r *Replayer) keys(ctx context.Context) ([][]string, error) {
+	prefixes := []string{""}
+	if !r.filter.From.IsZero() && !r.filter.To.IsZero() {
+		prefixes = prefixes[:0]
+		for day := r.filter.From.UTC().Truncate(24 * time.Hour); day.Before(r.filter.To); day = day.AddDate(0, 0, 1) {
+			prefixes = append(prefixes, datePrefix+day.Format(dateLayout)+"/")
+		}
+	}
+
+	var days [][]string
+	date := ""
+	for _, prefix := range prefixes {
+		listed, err := r.store.List(ctx, prefix)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range listed {
+			p, ok := parseKey(key)
+			if !ok || !r.filter.matchPartition(p) {
+				continue
+			}
+			// keys are sorted, so the keys of a day are listed together.
+			if len(days) == 0 || p.date != date {
+				days = append(days, nil)
+				date = p.date
+			}
+			days[len(days)-1] = append(days[len(days)-1], key)
+		}
+	}
+	return days, nil
+}
+
+// ReplayToHandler replays the selected events into a string handler, such as the one returned by
+// sub.MutationEventHandlerToStringHandler. The handler receives each event as a JSON envelope, with a MessageInfo
+// carrying its content type attribute.
+func (r *Replayer) ReplayToHandler(ctx context.Context, handler sub.StringHandlerFn) (int, error) {
+	return r.Replay(ctx, func(ctx context.Context, record Record) error {
+		body, err := json.Marshal(record.Event)
+		if err != nil {
+			return fmt.Errorf("failed to marshal event: %w", err)
+		}
+
+		ctx = sub.WithMessageInfo(ctx, sub.MessageInfo{
+			MessageAttributes: map[string]string{models.AttributeContentType: record.ContentType},
+		})
+		return handler(ctx, string(body))
+	})
+}
+
+// ReplayToTopic publishes the selected events with publisher, with their content type attribute. The events are
+// signed and compressed as configured on publisher, e.g. with pub.SNSPublisher.WithSigner and WithCompression, so
+// that subscribers verifying signatures accept them.
+func (r *Replayer) ReplayToTopic(ctx context.Context, publisher EnvelopePublisher) (int, error) {
+	return r.Replay(ctx, func(ctx context.Context, record Record) error {
+		body, err := json.Marshal(record.Event)
+		if err != nil {
+			return fmt.Errorf("failed to marshal event: %w", err)
+		}
+
+		attributes := map[string]string{models.AttributeContentType: record.ContentType}
+		return publisher.PublishEnvelope(ctx, string(body), attributes)
+	})
+}
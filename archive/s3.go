@@ -0,0 +1,81 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// S3Store is a Store keeping objects in an S3 bucket.
+type S3Store struct {
+	client *s3.Client
+	bucket string
+	prefix string
+}
+
+var _ Store = (*S3Store)(nil)
+
+// NewS3Store creates an S3Store keeping objects in bucket, under prefix. A non empty prefix should end with "/".
+func NewS3Store(client *s3.Client, bucket, prefix string) *S3Store {
+	return &S3Store{client: client, bucket: bucket, prefix: prefix}
+}
+
+// Put implements Store.
+func (s *S3Store) Put(ctx context.Context, key string, data []byte) error {
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(s.prefix + key),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/gzip"),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to put object in S3: %w", err)
+	}
+	return nil
+}
+
+// Get implements Store.
+func (s *S3Store) Get(ctx context.Context, key string) ([]byte, error) {
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.prefix + key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get object from S3: %w", err)
+	}
+	defer out.Body.Close()
+
+	data, err := io.ReadAll(out.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read object from S3: %w", err)
+	}
+	return data, nil
+}
+
+// List implements Store.
+func (s *S3Store) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(s.prefix + prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects in S3: %w", err)
+		}
+		for _, object := range page.Contents {
+			keys = append(keys, strings.TrimPrefix(aws.ToString(object.Key), s.prefix))
+		}
+	}
+
+	sort.Strings(keys)
+	return keys, nil
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/Iknite-Space/psss/psssoptions"
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+const (
+	contextPackage = protogen.GoImportPath("context")
+	modelsPackage  = protogen.GoImportPath("github.com/Iknite-Space/psss/models")
+	pubPackage     = protogen.GoImportPath("github.com/Iknite-Space/psss/pub")
+	subPackage     = protogen.GoImportPath("github.com/Iknite-Space/psss/sub")
+	sqsPackage     = protogen.GoImportPath("github.com/aws/aws-sdk-go-v2/service/sqs")
+)
+
+// eventTypes are the event types a resource can declare, in the order their methods are generated.
+var eventTypes = []eventType{
+	{name: "created", goName: "Created", constant: "EventTypeCreated"},
+	{name: "updated", goName: "Updated", constant: "EventTypeUpdated"},
+	{name: "deleted", goName: "Deleted", constant: "EventTypeDeleted"},
+}
+
+type eventType struct {
+	name     string
+	goName   string
+	constant string
+}
+
+// resource is a message declared as a resource.
+type resource struct {
+	message    *protogen.Message
+	options    *psssoptions.Resource
+	idField    *protogen.Field
+	eventTypes []eventType
+}
+
+// generateFile generates the publishers and subscribers of the resources of file, if it has any.
+func generateFile(gen *protogen.Plugin, file *protogen.File) error {
+	var resources []resource
+	for _, message := range allMessages(file.Messages) {
+		r, ok, err := newResource(message)
+		if err != nil {
+			return err
+		}
+		if ok {
+			resources = append(resources, r)
+		}
+	}
+	if len(resources) == 0 {
+		return nil
+	}
+
+	g := gen.NewGeneratedFile(file.GeneratedFilenamePrefix+".psss.go", file.GoImportPath)
+	g.P("// Code generated by protoc-gen-psss. DO NOT EDIT.")
+	g.P("// source: ", file.Desc.Path())
+	g.P()
+	g.P("package ", file.GoPackageName)
+
+	for _, r := range resources {
+		generateResource(g, r)
+	}
+	return nil
+}
+
+// allMessages returns messages and the messages nested in them.
+func allMessages(messages []*protogen.Message) []*protogen.Message {
+	var all []*protogen.Message
+	for _, message := range messages {
+		all = append(all, message)
+		all = append(all, allMessages(message.Messages)...)
+	}
+	return all
+}
+
+// newResource returns the resource declared by the options of message. It reports false if message isn't a
+// resource.
+func newResource(message *protogen.Message) (resource, bool, error) {
+	messageOptions, ok := message.Desc.Options().(*descriptorpb.MessageOptions)
+	if !ok || !proto.HasExtension(messageOptions, psssoptions.E_Resource) {
+		return resource{}, false, nil
+	}
+	options := proto.GetExtension(messageOptions, psssoptions.E_Resource).(*psssoptions.Resource)
+
+	r := resource{message: message, options: options}
+	name := message.Desc.FullName()
+	if options.GetType() == "" {
+		return r, false, fmt.Errorf("%s: the resource type is required", name)
+	}
+
+	if len(options.GetEventTypes()) == 0 {
+		r.eventTypes = eventTypes
+	}
+	for _, declared := range options.GetEventTypes() {
+		i := slices.IndexFunc(eventTypes, func(t eventType) bool { return t.name == declared })
+		if i < 0 {
+			return r, false, fmt.Errorf("%s: unknown event type %q", name, declared)
+		}
+	}
+	for _, t := range eventTypes {
+		if slices.Contains(options.GetEventTypes(), t.name) {
+			r.eventTypes = append(r.eventTypes, t)
+		}
+	}
+
+	if options.GetIdField() != "" {
+		i := slices.IndexFunc(message.Fields, func(f *protogen.Field) bool {
+			return string(f.Desc.Name()) == options.GetIdField()
+		})
+		if i < 0 {
+			return r, false, fmt.Errorf("%s: no field %q", name, options.GetIdField())
+		}
+		r.idField = message.Fields[i]
+		if r.idField.Desc.Kind() != protoreflect.StringKind || r.idField.Desc.IsList() || r.idField.Desc.IsMap() {
+			return r, false, fmt.Errorf("%s: id field %q is not a string", name, options.GetIdField())
+		}
+	}
+
+	return r, true, nil
+}
+
+func generateResource(g *protogen.GeneratedFile, r resource) {
+	name := r.message.GoIdent.GoName
+
+	g.P()
+	g.P("// ", name, "ResourceType is the resource type of the mutation events of ", name, ".")
+	g.P("const ", name, "ResourceType = ", strconv.Quote(r.options.GetType()))
+	if r.options.GetTopic() != "" {
+		g.P()
+		g.P("// ", name, "Topic is the name of the SNS topic the mutation events of ", name, " are published to.")
+		g.P("const ", name, "Topic = ", strconv.Quote(r.options.GetTopic()))
+	}
+
+	generatePublisher(g, r)
+	generateSubscriber(g, r)
+}
+
+func generatePublisher(g *protogen.GeneratedFile, r resource) {
+	name := r.message.GoIdent.GoName
+	publisher := name + "Publisher"
+	messageType := "*" + g.QualifiedGoIdent(r.message.GoIdent)
+	eventType := g.QualifiedGoIdent(modelsPackage.Ident("ProtoMutationEvent")) + "[" + messageType + "]"
+	ctx := g.QualifiedGoIdent(contextPackage.Ident("Context"))
+
+	g.P()
+	g.P("// ", publisher, " publishes the mutation events of ", name, ".")
+	g.P("type ", publisher, " struct {")
+	g.P("publisher ", pubPackage.Ident("Publisher"), "[", messageType, "]")
+	g.P("}")
+	g.P()
+	g.P("// New", publisher, " creates a ", publisher, " publishing with publisher, e.g. one created with")
+	g.P("// pub.NewPubService.")
+	g.P("func New", publisher, "(publisher ", pubPackage.Ident("Publisher"), "[", messageType, "]) *", publisher, " {")
+	g.P("return &", publisher, "{publisher: publisher}")
+	g.P("}")
+	g.P()
+	g.P("// Publish publishes event with the resource type of ", name, ".")
+	g.P("func (p *", publisher, ") Publish(ctx ", ctx, ", event ", eventType, ") error {")
+	g.P("event.ResourceType = ", name, "ResourceType")
+	g.P("return p.publisher.Publish(ctx, event)")
+	g.P("}")
+
+	resourceIDParam := ""
+	if r.idField == nil {
+		resourceIDParam = "resourceID string, "
+	}
+	resourceID := func(state string) string {
+		if r.idField == nil {
+			return "resourceID"
+		}
+		return state + ".Get" + r.idField.GoName + "()"
+	}
+
+	for _, t := range r.eventTypes {
+		g.P()
+		switch t.name {
+		case "created":
+			g.P("// PublishCreated publishes the creation of after.")
+			g.P("func (p *", publisher, ") PublishCreated(ctx ", ctx, ", ", resourceIDParam, "after ", messageType, ") error {")
+			g.P("return p.Publish(ctx, ", eventType, "{")
+			g.P("EventType: ", modelsPackage.Ident(t.constant), ",")
+			g.P("ResourceID: ", resourceID("after"), ",")
+			g.P("After: after,")
+			g.P("})")
+		case "updated":
+			g.P("// PublishUpdated publishes the update of before into after. before may be nil.")
+			g.P("func (p *", publisher, ") PublishUpdated(ctx ", ctx, ", ", resourceIDParam, "before, after ", messageType, ") error {")
+			g.P("return p.Publish(ctx, ", eventType, "{")
+			g.P("EventType: ", modelsPackage.Ident(t.constant), ",")
+			g.P("ResourceID: ", resourceID("after"), ",")
+			g.P("Before: before,")
+			g.P("After: after,")
+			g.P("})")
+		case "deleted":
+			g.P("// PublishDeleted publishes the deletion of before.")
+			g.P("func (p *", publisher, ") PublishDeleted(ctx ", ctx, ", ", resourceIDParam, "before ", messageType, ") error {")
+			g.P("return p.Publish(ctx, ", eventType, "{")
+			g.P("EventType: ", modelsPackage.Ident(t.constant), ",")
+			g.P("ResourceID: ", resourceID("before"), ",")
+			g.P("Before: before,")
+			g.P("})")
+		}
+		g.P("}")
+	}
+}
+
+func generateSubscriber(g *protogen.GeneratedFile, r resource) {
+	name := r.message.GoIdent.GoName
+	subscriber := name + "Subscriber"
+	messageType := "*" + g.QualifiedGoIdent(r.message.GoIdent)
+	eventType := g.QualifiedGoIdent(modelsPackage.Ident("ProtoMutationEvent")) + "[" + messageType + "]"
+	handlerType := g.QualifiedGoIdent(subPackage.Ident("ProtoMutationEventHandlerFn")) + "[" + messageType + "]"
+
+	g.P()
+	g.P("// ", subscriber, " handles the mutation events of ", name, " with a handler per event type. Events without a")
+	g.P("// handler, and events of other resource types, are acknowledged without being handled.")
+	g.P("type ", subscriber, " struct {")
+	for _, t := range r.eventTypes {
+		g.P(t.goName, " ", handlerType)
+	}
+	g.P("}")
+	g.P()
+	g.P("// Handle passes event to the handler of its event type.")
+	g.P("func (s *", subscriber, ") Handle(ctx ", contextPackage.Ident("Context"), ", event ", eventType, ") error {")
+	g.P("if event.ResourceType != ", name, "ResourceType {")
+	g.P("return nil")
+	g.P("}")
+	g.P()
+	g.P("switch event.EventType {")
+	for _, t := range r.eventTypes {
+		g.P("case ", modelsPackage.Ident(t.constant), ":")
+		g.P("if s.", t.goName, " != nil {")
+		g.P("return s.", t.goName, "(ctx, event)")
+		g.P("}")
+	}
+	g.P("}")
+	g.P("return nil")
+	g.P("}")
+	g.P()
+	g.P("// NewProcessor creates a processor handling the mutation events of ", name, " received from the queue at")
+	g.P("// queueURL with s.")
+	g.P("func (s *", subscriber, ") NewProcessor(svc *", sqsPackage.Ident("Client"), ", queueURL string, opts ...", subPackage.Ident("MutationEventOption"), ") *", subPackage.Ident("SqsEventProcessor"), " {")
+	g.P("return ", subPackage.Ident("NewMutationEventSqsProcessor"), "(svc, queueURL, func() ", messageType, " { return &", r.message.GoIdent, "{} }, s.Handle, false, opts...)")
+	g.P("}")
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/Iknite-Space/psss/psssoptions"
+	"github.com/bufbuild/protocompile"
+	"google.golang.org/protobuf/cmd/protoc-gen-go/internal_gengo"
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+// notesFiles compiles testdata/notes/v1/notes.proto, with the root of the module on the import path, and returns
+// the descriptors of the files protoc would send to the plugins: its dependencies first, then the file itself.
+func notesFiles(t *testing.T) []*descriptorpb.FileDescriptorProto {
+	t.Helper()
+
+	compiler := protocompile.Compiler{
+		Resolver: protocompile.WithStandardImports(&protocompile.SourceResolver{
+			ImportPaths: []string{"testdata", filepath.Join("..", "..")},
+		}),
+	}
+	compiled, err := compiler.Compile(context.Background(), "notes/v1/notes.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var files []*descriptorpb.FileDescriptorProto
+	seen := make(map[string]bool)
+	var add func(file protoreflect.FileDescriptor)
+	add = func(file protoreflect.FileDescriptor) {
+		if seen[file.Path()] {
+			return
+		}
+		seen[file.Path()] = true
+		imports := file.Imports()
+		for i := range imports.Len() {
+			add(imports.Get(i).FileDescriptor)
+		}
+		files = append(files, protodesc.ToFileDescriptorProto(file))
+	}
+	add(compiled[0])
+	return files
+}
+
+// generate runs fn on the files to generate of request and returns the generated files.
+func generate(t *testing.T, request *pluginpb.CodeGeneratorRequest, fn func(gen *protogen.Plugin, file *protogen.File) error) []*pluginpb.CodeGeneratorResponse_File {
+	t.Helper()
+
+	// protoc sends the options as serialized extensions, so marshal the request to resolve them the same way.
+	b, err := proto.Marshal(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request = &pluginpb.CodeGeneratorRequest{}
+	err = proto.Unmarshal(b, request)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gen, err := protogen.Options{}.New(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range gen.Files {
+		if file.Generate {
+			err = fn(gen, file)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	response := gen.Response()
+	if response.Error != nil {
+		t.Fatal(response.GetError())
+	}
+	return response.File
+}
+
+// TestGenerate generates the code of testdata/notes/v1/notes.proto and compares it to the golden file.
+func TestGenerate(t *testing.T) {
+	files := generate(t, &pluginpb.CodeGeneratorRequest{
+		FileToGenerate: []string{"notes/v1/notes.proto"},
+		Parameter:      proto.String("paths=source_relative"),
+		ProtoFile:      notesFiles(t),
+	}, generateFile)
+	if len(files) != 1 || files[0].GetName() != "notes/v1/notes.psss.go" {
+		t.Fatalf("generated %v, want notes/v1/notes.psss.go", files)
+	}
+
+	golden := filepath.Join("testdata", "notes.psss.go.golden")
+	got := files[0].GetContent()
+	if *update {
+		err := os.WriteFile(golden, []byte(got), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Errorf("generated code differs from %s, run go test -update to update it:\n%s", golden, got)
+	}
+}
+
+// TestGeneratedCodeBuilds builds the code generated for testdata/notes/v1/notes.proto along with the code
+// protoc-gen-go generates for it, as a package of this module overlaid on testdata/notesv1.
+func TestGeneratedCodeBuilds(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping the build of the generated code in short mode")
+	}
+
+	files := generate(t, &pluginpb.CodeGeneratorRequest{
+		FileToGenerate: []string{"notes/v1/notes.proto"},
+		Parameter:      proto.String("paths=source_relative"),
+		ProtoFile:      notesFiles(t),
+	}, func(gen *protogen.Plugin, file *protogen.File) error {
+		internal_gengo.GenerateFile(gen, file)
+		return generateFile(gen, file)
+	})
+
+	packageDir, err := filepath.Abs(filepath.Join("testdata", "notesv1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	overlay := make(map[string]string)
+	for _, file := range files {
+		path := filepath.Join(dir, filepath.Base(file.GetName()))
+		err = os.WriteFile(path, []byte(file.GetContent()), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		overlay[filepath.Join(packageDir, filepath.Base(file.GetName()))] = path
+	}
+	b, err := json.Marshal(map[string]any{"Replace": overlay})
+	if err != nil {
+		t.Fatal(err)
+	}
+	overlayPath := filepath.Join(dir, "overlay.json")
+	err = os.WriteFile(overlayPath, b, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command("go", "build", "-overlay", overlayPath, "-o", os.DevNull, "./testdata/notesv1")
+	cmd.Env = append(os.Environ(), "GOFLAGS=-mod=readonly")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Errorf("go build of the generated code failed: %v\n%s", err, output)
+	}
+}
+
+func TestGenerateInvalidOptions(t *testing.T) {
+	tests := map[string]*psssoptions.Resource{
+		"missing type":       {},
+		"unknown event type": {Type: "note", EventTypes: []string{"archived"}},
+		"unknown id field":   {Type: "note", IdField: "uuid"},
+		"id field of type":   {Type: "note", IdField: "revision"},
+	}
+
+	for name, options := range tests {
+		t.Run(name, func(t *testing.T) {
+			files := notesFiles(t)
+			file := files[len(files)-1]
+			file.MessageType = file.MessageType[:1]
+			file.MessageType[0].Options = &descriptorpb.MessageOptions{}
+			proto.SetExtension(file.MessageType[0].Options, psssoptions.E_Resource, options)
+
+			gen, err := protogen.Options{}.New(&pluginpb.CodeGeneratorRequest{
+				FileToGenerate: []string{"notes/v1/notes.proto"},
+				ProtoFile:      files,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = generateFile(gen, gen.Files[len(gen.Files)-1])
+			if err == nil {
+				t.Error("generateFile succeeded, want an error")
+			}
+		})
+	}
+}
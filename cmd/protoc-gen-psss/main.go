@@ -0,0 +1,51 @@
+// Command protoc-gen-psss is a protoc plugin generating typed publishers and subscribers for the messages declared
+// as resources with the (psss.options.resource) option:
+//
+//	import "psssoptions/options.proto";
+//
+//	message Note {
+//	  option (psss.options.resource) = {
+//	    type: "note"
+//	    topic: "notes"
+//	    id_field: "id"
+//	  };
+//
+//	  string id = 1;
+//	  string title = 2;
+//	}
+//
+// For each resource it generates, next to the code generated by protoc-gen-go:
+//   - NoteResourceType, and NoteTopic when a topic is set;
+//   - NotePublisher, wrapping a pub.Publisher with PublishCreated, PublishUpdated and PublishDeleted methods for
+//     the event types of the resource. The resource ID is read from id_field, or is a parameter of the methods
+//     when it isn't set;
+//   - NoteSubscriber, dispatching events to a handler per event type, and creating processors with NewProcessor.
+//
+// Run protoc with the root of this module on the import path, e.g.
+//
+//	protoc -I . -I $(go list -m -f '{{.Dir}}' github.com/Iknite-Space/psss) \
+//		--go_out=. --go_opt=paths=source_relative \
+//		--psss_out=. --psss_opt=paths=source_relative \
+//		notes/v1/note.proto
+package main
+
+import (
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func main() {
+	protogen.Options{}.Run(func(gen *protogen.Plugin) error {
+		gen.SupportedFeatures = uint64(pluginpb.CodeGeneratorResponse_FEATURE_PROTO3_OPTIONAL)
+		for _, file := range gen.Files {
+			if !file.Generate {
+				continue
+			}
+			err := generateFile(gen, file)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
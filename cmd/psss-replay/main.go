@@ -0,0 +1,168 @@
+// Command psss-replay replays archived mutation events, see package archive.
+//
+// Events are read from a local directory (-dir) or an S3 bucket (-bucket, -prefix), selected by time range, resource
+// and event type, and published to an SNS topic (-topic-arn) or, without a topic, written to stdout as JSONL records.
+// Events are replayed in the order of their time, one day at a time.
+//
+// Published events are signed with the secret held by the environment variable named by -signing-secret-env under
+// the key ID -signing-key-id, and compressed with -compression, as the original publisher would.
+//
+//	psss-replay -bucket events-archive -from 2025-01-02T00:00:00Z -to 2025-01-03T00:00:00Z \
+//		-resource-type notebook -event-type updated,deleted -rate 50 -topic-arn arn:aws:sns:...
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/Iknite-Space/psss/archive"
+	"github.com/Iknite-Space/psss/compression"
+	"github.com/Iknite-Space/psss/models"
+	"github.com/Iknite-Space/psss/pub"
+	"github.com/Iknite-Space/psss/signing"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/rs/zerolog"
+	"google.golang.org/protobuf/proto"
+)
+
+func main() {
+	err := run()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "psss-replay:", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	dir := flag.String("dir", "", "local archive directory")
+	bucket := flag.String("bucket", "", "S3 archive bucket")
+	prefix := flag.String("prefix", "", "key prefix of the archive in the S3 bucket")
+	from := flag.String("from", "", "replay events at or after this RFC 3339 time")
+	to := flag.String("to", "", "replay events before this RFC 3339 time")
+	resourceTypes := flag.String("resource-type", "", "comma separated resource types to replay")
+	resourceIDs := flag.String("resource-id", "", "comma separated resource IDs to replay")
+	eventTypes := flag.String("event-type", "", "comma separated event types to replay: created, updated, deleted")
+	rate := flag.Float64("rate", 0, "maximum number of events replayed per second, 0 for no limit")
+	topicArn := flag.String("topic-arn", "", "SNS topic to publish the events to, instead of writing them to stdout")
+	signingKeyID := flag.String("signing-key-id", "", "ID of the key to sign published events with")
+	signingSecretEnv := flag.String("signing-secret-env", "", "environment variable holding the secret of the signing key")
+	compressionAlgorithm := flag.String("compression", "", "algorithm to compress published events with: gzip, zstd")
+	compressionThreshold := flag.Int("compression-threshold", 1024, "minimum size in bytes of the events to compress")
+	verbose := flag.Bool("v", false, "log progress to stderr")
+	flag.Parse()
+
+	if (*dir == "") == (*bucket == "") {
+		return errors.New("exactly one of -dir and -bucket is required")
+	}
+	if (*signingKeyID == "") != (*signingSecretEnv == "") {
+		return errors.New("-signing-key-id and -signing-secret-env must be set together")
+	}
+	algorithm := compression.Algorithm(*compressionAlgorithm)
+	if algorithm != compression.None && algorithm != compression.Gzip && algorithm != compression.Zstd {
+		return fmt.Errorf("invalid -compression: unknown algorithm %q", *compressionAlgorithm)
+	}
+
+	filter := archive.Filter{
+		ResourceTypes: splitList(*resourceTypes),
+		ResourceIDs:   splitList(*resourceIDs),
+	}
+	var err error
+	filter.From, err = parseTime(*from)
+	if err != nil {
+		return fmt.Errorf("invalid -from: %w", err)
+	}
+	filter.To, err = parseTime(*to)
+	if err != nil {
+		return fmt.Errorf("invalid -to: %w", err)
+	}
+	filter.EventTypes, err = parseEventTypes(*eventTypes)
+	if err != nil {
+		return fmt.Errorf("invalid -event-type: %w", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	logger := zerolog.Nop()
+	if *verbose {
+		logger = zerolog.New(zerolog.ConsoleWriter{Out: os.Stderr}).With().Timestamp().Logger()
+	}
+
+	var store archive.Store = archive.NewLocalStore(*dir)
+	if *bucket != "" || *topicArn != "" {
+		cfg, err := config.LoadDefaultConfig(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to load AWS config: %w", err)
+		}
+		if *bucket != "" {
+			store = archive.NewS3Store(s3.NewFromConfig(cfg), *bucket, *prefix)
+		}
+		if *topicArn != "" {
+			replayer := archive.NewReplayer(store).WithFilter(filter).WithRate(*rate).WithLogger(logger)
+			publisher := pub.NewPubService[proto.Message](sns.NewFromConfig(cfg), *topicArn).
+				WithLogger(logger).
+				WithCompression(algorithm, *compressionThreshold)
+			if *signingKeyID != "" {
+				secret := os.Getenv(*signingSecretEnv)
+				if secret == "" {
+					return fmt.Errorf("environment variable %s is not set", *signingSecretEnv)
+				}
+				publisher.WithSigner(signing.NewSigner(*signingKeyID, []byte(secret)))
+			}
+			n, err := replayer.ReplayToTopic(ctx, publisher)
+			logger.Info().Int("count", n).Msg("Replayed events")
+			return err
+		}
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	replayer := archive.NewReplayer(store).WithFilter(filter).WithRate(*rate).WithLogger(logger)
+	n, err := replayer.Replay(ctx, func(_ context.Context, record archive.Record) error {
+		return enc.Encode(record)
+	})
+	logger.Info().Int("count", n).Msg("Replayed events")
+	return err
+}
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+func parseEventTypes(s string) ([]models.EventType, error) {
+	var eventTypes []models.EventType
+	for _, name := range splitList(s) {
+		found := false
+		for _, t := range []models.EventType{models.EventTypeCreated, models.EventTypeUpdated, models.EventTypeDeleted} {
+			if strings.EqualFold(name, t.String()) {
+				eventTypes = append(eventTypes, t)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown event type %q", name)
+		}
+	}
+	return eventTypes, nil
+}
@@ -0,0 +1,118 @@
+// Package compression implements the payload compression shared by the pub and sub packages.
+package compression
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Algorithm is a compression algorithm, as named in the content_encoding message attribute.
+type Algorithm string
+
+const (
+	// None disables compression.
+	None Algorithm = ""
+
+	// Gzip compresses payloads with gzip at the default compression level.
+	Gzip Algorithm = "gzip"
+
+	// Zstd compresses payloads with zstd at the default compression level. It's faster than Gzip and usually
+	// compresses better.
+	Zstd Algorithm = "zstd"
+)
+
+// MaxDecompressedSize is the maximum size of a decompressed payload. SNS and SQS messages are limited to 256KB, so
+// it leaves room for payloads compressing about 64 times, while bounding the memory a small malicious payload, a
+// so-called decompression bomb, can make consumers allocate.
+const MaxDecompressedSize = 16 << 20
+
+// ErrTooLarge is returned by Decompress when the decompressed payload would exceed MaxDecompressedSize.
+var ErrTooLarge = errors.New("decompressed payload is too large")
+
+var (
+	// zstd encoders and decoders are expensive to create and safe for concurrent use with EncodeAll/DecodeAll.
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+	zstdErr     error
+)
+
+func zstdCodec() (*zstd.Encoder, *zstd.Decoder, error) {
+	zstdOnce.Do(func() {
+		zstdEncoder, zstdErr = zstd.NewWriter(nil)
+		if zstdErr != nil {
+			return
+		}
+		zstdDecoder, zstdErr = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(MaxDecompressedSize))
+	})
+	return zstdEncoder, zstdDecoder, zstdErr
+}
+
+// Compress compresses b with the given algorithm.
+func Compress(algorithm Algorithm, b []byte) ([]byte, error) {
+	switch algorithm {
+	case Gzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		_, err := w.Write(b)
+		if err != nil {
+			return nil, fmt.Errorf("gzip: %w", err)
+		}
+		err = w.Close()
+		if err != nil {
+			return nil, fmt.Errorf("gzip: %w", err)
+		}
+		return buf.Bytes(), nil
+	case Zstd:
+		encoder, _, err := zstdCodec()
+		if err != nil {
+			return nil, fmt.Errorf("zstd: %w", err)
+		}
+		return encoder.EncodeAll(b, nil), nil
+	default:
+		return nil, fmt.Errorf("unsupported compression algorithm %q", algorithm)
+	}
+}
+
+// Decompress decompresses b, which was compressed with the given algorithm. It fails with ErrTooLarge, without
+// decompressing the rest of the payload, once the output exceeds MaxDecompressedSize.
+func Decompress(algorithm Algorithm, b []byte) ([]byte, error) {
+	switch algorithm {
+	case Gzip:
+		r, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, fmt.Errorf("gzip: %w", err)
+		}
+		defer r.Close()
+
+		out, err := io.ReadAll(io.LimitReader(r, MaxDecompressedSize+1))
+		if err != nil {
+			return nil, fmt.Errorf("gzip: %w", err)
+		}
+		if len(out) > MaxDecompressedSize {
+			return nil, fmt.Errorf("gzip: %w", ErrTooLarge)
+		}
+		return out, nil
+	case Zstd:
+		_, decoder, err := zstdCodec()
+		if err != nil {
+			return nil, fmt.Errorf("zstd: %w", err)
+		}
+		out, err := decoder.DecodeAll(b, nil)
+		if errors.Is(err, zstd.ErrDecoderSizeExceeded) || errors.Is(err, zstd.ErrWindowSizeExceeded) {
+			return nil, fmt.Errorf("zstd: %w", ErrTooLarge)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("zstd: %w", err)
+		}
+		return out, nil
+	default:
+		return nil, fmt.Errorf("unsupported compression algorithm %q", algorithm)
+	}
+}
@@ -0,0 +1,172 @@
+package compression
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// samplePayload returns a JSON mutation event envelope whose Before and After hold a resource with n notes, which
+// is roughly what the publisher sends for list-like resources.
+func samplePayload(tb testing.TB, n int) []byte {
+	tb.Helper()
+
+	notes := make([]map[string]any, n)
+	for i := range notes {
+		notes[i] = map[string]any{
+			"id":         fmt.Sprintf("018f3c2e-7b1a-7c3d-9e4f-%012d", i),
+			"title":      fmt.Sprintf("Meeting notes %d", i),
+			"body":       "Discussed the roadmap for the next quarter, agreed on owners and follow ups.",
+			"created_at": time.Date(2025, 1, 1, 0, 0, i, 0, time.UTC),
+			"tags":       []string{"meeting", "roadmap", "q3"},
+		}
+	}
+
+	b, err := json.Marshal(map[string]any{
+		"event_id":      "018f3c2e-7b1a-7c3d-9e4f-1a2b3c4d5e6f",
+		"event_type":    2,
+		"timestamp":     time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		"source":        "notes-service",
+		"resource_type": "notebook",
+		"resource_id":   "notebook-1",
+		"performed_by":  "432f8b1e-4f1c-4d2a-9c3e-1a2b3c4d5e6f",
+		"before":        map[string]any{"notes": notes[:n/2]},
+		"after":         map[string]any{"notes": notes},
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return b
+}
+
+func TestRoundTrip(t *testing.T) {
+	payload := samplePayload(t, 10)
+
+	for _, algorithm := range []Algorithm{Gzip, Zstd} {
+		compressed, err := Compress(algorithm, payload)
+		if err != nil {
+			t.Fatalf("%s: compress: %v", algorithm, err)
+		}
+
+		decompressed, err := Decompress(algorithm, compressed)
+		if err != nil {
+			t.Fatalf("%s: decompress: %v", algorithm, err)
+		}
+
+		if !bytes.Equal(decompressed, payload) {
+			t.Errorf("%s: round trip changed the payload", algorithm)
+		}
+	}
+}
+
+func TestDecompressTooLarge(t *testing.T) {
+	// a few KB of compressed zeros expanding past the limit.
+	bomb := make([]byte, 2*MaxDecompressedSize)
+
+	var streamed bytes.Buffer
+	w, err := zstd.NewWriter(&streamed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = w.Write(bomb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	compress := func(algorithm Algorithm, b []byte) []byte {
+		t.Helper()
+		compressed, err := Compress(algorithm, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return compressed
+	}
+
+	payloads := map[string]struct {
+		algorithm  Algorithm
+		compressed []byte
+	}{
+		"gzip": {algorithm: Gzip, compressed: compress(Gzip, bomb)},
+		"zstd": {algorithm: Zstd, compressed: compress(Zstd, bomb)},
+		// streamed zstd frames don't declare their size, so they are only stopped while being decoded.
+		"zstd streamed": {algorithm: Zstd, compressed: streamed.Bytes()},
+	}
+
+	for name, p := range payloads {
+		t.Run(name, func(t *testing.T) {
+			_, err := Decompress(p.algorithm, p.compressed)
+			if !errors.Is(err, ErrTooLarge) {
+				t.Errorf("Decompress(%d bytes) = %v, want ErrTooLarge", len(p.compressed), err)
+			}
+		})
+	}
+
+	// payloads of exactly the maximum size are accepted.
+	for _, algorithm := range []Algorithm{Gzip, Zstd} {
+		compressed := compress(algorithm, bomb[:MaxDecompressedSize])
+		out, err := Decompress(algorithm, compressed)
+		if err != nil || len(out) != MaxDecompressedSize {
+			t.Errorf("%s: Decompress(%d bytes) = %d bytes, %v, want %d bytes", algorithm, len(compressed), len(out), err, MaxDecompressedSize)
+		}
+	}
+}
+
+// BenchmarkCompress compares the CPU cost and the compressed size, reported as the "ratio" metric, of the
+// supported algorithms for small and large events.
+func BenchmarkCompress(b *testing.B) {
+	for _, notes := range []int{5, 50, 500} {
+		payload := samplePayload(b, notes)
+
+		for _, algorithm := range []Algorithm{Gzip, Zstd} {
+			b.Run(fmt.Sprintf("%s/%dB", algorithm, len(payload)), func(b *testing.B) {
+				var compressed []byte
+				var err error
+
+				b.SetBytes(int64(len(payload)))
+				b.ReportAllocs()
+				for b.Loop() {
+					compressed, err = Compress(algorithm, payload)
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+
+				b.ReportMetric(float64(len(compressed))/float64(len(payload)), "ratio")
+			})
+		}
+	}
+}
+
+func BenchmarkDecompress(b *testing.B) {
+	for _, notes := range []int{5, 50, 500} {
+		payload := samplePayload(b, notes)
+
+		for _, algorithm := range []Algorithm{Gzip, Zstd} {
+			compressed, err := Compress(algorithm, payload)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			b.Run(fmt.Sprintf("%s/%dB", algorithm, len(payload)), func(b *testing.B) {
+				b.SetBytes(int64(len(payload)))
+				b.ReportAllocs()
+				for b.Loop() {
+					_, err := Decompress(algorithm, compressed)
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,102 @@
+// Package encryption implements the envelope encryption of event payloads shared by the pub and sub packages.
+// Each event is encrypted with a fresh data key, which is sent along with the event wrapped by a master key held by
+// a KeyProvider. Wrapped keys carry the ID of their master key, so master keys can be rotated while events
+// encrypted with the previous key are still in flight.
+package encryption
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// AlgorithmAES256GCM is the algorithm used to encrypt payloads with their data key.
+const AlgorithmAES256GCM = "AES-256-GCM"
+
+// dataKeySize is the size of AES-256 data keys in bytes.
+const dataKeySize = 32
+
+// DataKey is a key used to encrypt the payloads of a single event.
+type DataKey struct {
+	// KeyID identifies the master key that wrapped the data key.
+	KeyID string
+
+	// Plaintext is the data key itself. It must never leave the process.
+	Plaintext []byte
+
+	// Wrapped is the data key encrypted with the master key, which is sent along with the event.
+	Wrapped []byte
+}
+
+// KeyProvider generates data keys wrapped by a master key, and unwraps them.
+type KeyProvider interface {
+	// GenerateDataKey returns a new data key wrapped by the current master key.
+	GenerateDataKey(ctx context.Context) (DataKey, error)
+
+	// DecryptDataKey unwraps a data key that was wrapped by the master key with the given ID.
+	DecryptDataKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error)
+}
+
+// Encrypt encrypts plaintext with the data key using AES-256-GCM. additionalData is authenticated but not
+// encrypted, and must be passed to Decrypt unchanged. The nonce is prepended to the returned ciphertext.
+func Encrypt(dataKey, plaintext, additionalData []byte) ([]byte, error) {
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// Decrypt decrypts a ciphertext produced by Encrypt.
+func Decrypt(dataKey, ciphertext, additionalData []byte) ([]byte, error) {
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("ciphertext is too short")
+	}
+
+	nonce, sealed := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, sealed, additionalData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt: %w", err)
+	}
+
+	return plaintext, nil
+}
+
+// PayloadAdditionalData returns the additional data binding an encrypted payload to its event and field, so that
+// ciphertexts can't be swapped between events or between Before and After.
+func PayloadAdditionalData(eventID string, field string) []byte {
+	return []byte(eventID + "/" + field)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != dataKeySize {
+		return nil, fmt.Errorf("invalid key size %d, expected %d", len(key), dataKeySize)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gcm: %w", err)
+	}
+
+	return aead, nil
+}
@@ -0,0 +1,311 @@
+package encryption_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/Iknite-Space/psss/encryption"
+	"github.com/Iknite-Space/psss/models"
+	"github.com/Iknite-Space/psss/pub"
+	"github.com/Iknite-Space/psss/sub"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/smithy-go/middleware"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func newKey(t *testing.T) []byte {
+	t.Helper()
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	key := newKey(t)
+	plaintext := []byte(`{"title":"secret notes"}`)
+	additionalData := encryption.PayloadAdditionalData("event-1", "after")
+
+	ciphertext, err := encryption.Encrypt(key, plaintext, additionalData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(ciphertext, plaintext) {
+		t.Error("ciphertext contains the plaintext")
+	}
+
+	decrypted, err := encryption.Decrypt(key, ciphertext, additionalData)
+	if err != nil {
+		t.Fatalf("Decrypt() = %v", err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("Decrypt() = %q, want %q", decrypted, plaintext)
+	}
+
+	// every encryption uses a new nonce.
+	again, err := encryption.Encrypt(key, plaintext, additionalData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(again, ciphertext) {
+		t.Error("encrypting twice returned the same ciphertext")
+	}
+}
+
+func TestDecryptRejectsTampering(t *testing.T) {
+	key := newKey(t)
+	additionalData := encryption.PayloadAdditionalData("event-1", "before")
+	ciphertext, err := encryption.Encrypt(key, []byte(`{"title":"secret notes"}`), additionalData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// flip a bit of the nonce, of the encrypted payload and of the tag.
+	for _, i := range []int{0, len(ciphertext) / 2, len(ciphertext) - 1} {
+		tampered := bytes.Clone(ciphertext)
+		tampered[i] ^= 1
+		_, err = encryption.Decrypt(key, tampered, additionalData)
+		if err == nil {
+			t.Errorf("Decrypt() of a ciphertext tampered at byte %d succeeded", i)
+		}
+	}
+
+	_, err = encryption.Decrypt(key, ciphertext[:8], additionalData)
+	if err == nil {
+		t.Error("Decrypt() of a truncated ciphertext succeeded")
+	}
+
+	_, err = encryption.Decrypt(newKey(t), ciphertext, additionalData)
+	if err == nil {
+		t.Error("Decrypt() with another key succeeded")
+	}
+}
+
+func TestDecryptChecksAdditionalData(t *testing.T) {
+	key := newKey(t)
+	ciphertext, err := encryption.Encrypt(key, []byte(`{"title":"before"}`), encryption.PayloadAdditionalData("event-1", "before"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the Before payload can't be passed off as the After payload, nor as the Before payload of another event.
+	for _, additionalData := range [][]byte{
+		encryption.PayloadAdditionalData("event-1", "after"),
+		encryption.PayloadAdditionalData("event-2", "before"),
+	} {
+		_, err = encryption.Decrypt(key, ciphertext, additionalData)
+		if err == nil {
+			t.Errorf("Decrypt() with additional data %q succeeded", additionalData)
+		}
+	}
+}
+
+func TestLocalKeyProviderRotation(t *testing.T) {
+	ctx := context.Background()
+	oldKey, newKeyBytes := newKey(t), newKey(t)
+
+	before, err := encryption.NewLocalKeyProvider("key-1", map[string][]byte{"key-1": oldKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataKey, err := before.GenerateDataKey(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dataKey.KeyID != "key-1" {
+		t.Errorf("KeyID = %q, want key-1", dataKey.KeyID)
+	}
+
+	// after the rotation new data keys are wrapped by key-2, and those wrapped by key-1 can still be unwrapped.
+	after, err := encryption.NewLocalKeyProvider("key-2", map[string][]byte{"key-1": oldKey, "key-2": newKeyBytes})
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := after.DecryptDataKey(ctx, dataKey.KeyID, dataKey.Wrapped)
+	if err != nil {
+		t.Fatalf("DecryptDataKey(key-1) = %v", err)
+	}
+	if !bytes.Equal(plaintext, dataKey.Plaintext) {
+		t.Error("DecryptDataKey(key-1) returned another key")
+	}
+
+	rotated, err := after.GenerateDataKey(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated.KeyID != "key-2" {
+		t.Errorf("KeyID = %q, want key-2", rotated.KeyID)
+	}
+
+	// the key ID is authenticated, so a data key can't be unwrapped under another ID.
+	_, err = after.DecryptDataKey(ctx, "key-2", dataKey.Wrapped)
+	if err == nil {
+		t.Error("DecryptDataKey(key-2) of a data key wrapped by key-1 succeeded")
+	}
+	_, err = before.DecryptDataKey(ctx, "key-2", rotated.Wrapped)
+	if err == nil {
+		t.Error("DecryptDataKey() with an unknown key ID succeeded")
+	}
+}
+
+func TestNewLocalKeyProvider(t *testing.T) {
+	_, err := encryption.NewLocalKeyProvider("key-1", map[string][]byte{"key-2": newKey(t)})
+	if err == nil {
+		t.Error("NewLocalKeyProvider() with an unknown current key succeeded")
+	}
+
+	_, err = encryption.NewLocalKeyProvider("key-1", map[string][]byte{"key-1": []byte("short")})
+	if err == nil {
+		t.Error("NewLocalKeyProvider() with a short key succeeded")
+	}
+}
+
+// publishedMessage is a message published to SNS.
+type publishedMessage struct {
+	body       string
+	attributes map[string]string
+}
+
+// newRecordingSnsClient returns an SNS client recording the messages it publishes instead of sending them.
+func newRecordingSnsClient(published *[]publishedMessage) *sns.Client {
+	record := middleware.InitializeMiddlewareFunc("record", func(ctx context.Context, in middleware.InitializeInput, _ middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+		input := in.Parameters.(*sns.PublishInput)
+		message := publishedMessage{body: aws.ToString(input.Message), attributes: make(map[string]string)}
+		for name, value := range input.MessageAttributes {
+			message.attributes[name] = aws.ToString(value.StringValue)
+		}
+		*published = append(*published, message)
+		return middleware.InitializeOutput{Result: &sns.PublishOutput{MessageId: aws.String("1")}}, middleware.Metadata{}, nil
+	})
+
+	return sns.New(sns.Options{
+		Region: "us-east-1",
+		APIOptions: []func(*middleware.Stack) error{
+			func(stack *middleware.Stack) error {
+				return stack.Initialize.Add(record, middleware.Before)
+			},
+		},
+	})
+}
+
+func TestEncryptedRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	oldKey := newKey(t)
+
+	publisherKeys, err := encryption.NewLocalKeyProvider("key-1", map[string][]byte{"key-1": oldKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the subscriber was already rotated to key-2 when the event is received.
+	subscriberKeys, err := encryption.NewLocalKeyProvider("key-2", map[string][]byte{"key-1": oldKey, "key-2": newKey(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event := models.ProtoMutationEvent[*wrapperspb.StringValue]{
+		EventType:    models.EventTypeUpdated,
+		ResourceType: "note",
+		ResourceID:   "1",
+		Before:       wrapperspb.String("secret before"),
+		After:        wrapperspb.String("secret after"),
+	}
+
+	encodings := map[string]pub.Encoding{
+		"json":             pub.EncodingJSON,
+		"protobuf payload": pub.EncodingProtobufPayload,
+		"protobuf":         pub.EncodingProtobuf,
+	}
+
+	for name, encoding := range encodings {
+		t.Run(name, func(t *testing.T) {
+			var published []publishedMessage
+			publisher := pub.NewPubService[*wrapperspb.StringValue](newRecordingSnsClient(&published), "arn:aws:sns:us-east-1:123456789012:notes").
+				WithEncoding(encoding).
+				WithEncryption(publisherKeys)
+
+			err := publisher.Publish(ctx, event)
+			if err != nil {
+				t.Fatalf("Publish() = %v", err)
+			}
+			message := published[0]
+			if strings.Contains(message.body, "secret") {
+				t.Errorf("published message contains the plaintext payloads: %s", message.body)
+			}
+
+			var got models.ProtoMutationEvent[*wrapperspb.StringValue]
+			handler := sub.MutationEventHandlerToStringHandler(func(_ context.Context, e models.ProtoMutationEvent[*wrapperspb.StringValue]) error {
+				got = e
+				return nil
+			}, func() *wrapperspb.StringValue { return &wrapperspb.StringValue{} }, sub.WithKeyProvider(subscriberKeys))
+
+			ctx := sub.WithMessageInfo(ctx, sub.MessageInfo{MessageAttributes: message.attributes})
+			err = handler(ctx, message.body)
+			if err != nil {
+				t.Fatalf("handler() = %v", err)
+			}
+			if !proto.Equal(got.Before, event.Before) || !proto.Equal(got.After, event.After) {
+				t.Errorf("decoded Before = %v, After = %v, want %v, %v", got.Before, got.After, event.Before, event.After)
+			}
+
+			// without a key provider the payloads can't be decoded.
+			noKeys := sub.MutationEventHandlerToStringHandler(func(context.Context, models.ProtoMutationEvent[*wrapperspb.StringValue]) error {
+				return nil
+			}, func() *wrapperspb.StringValue { return &wrapperspb.StringValue{} })
+			err = noKeys(ctx, message.body)
+			if err == nil {
+				t.Error("handler without a key provider succeeded")
+			}
+		})
+	}
+}
+
+func TestEncryptedPayloadsCantBeSwapped(t *testing.T) {
+	ctx := context.Background()
+	keys, err := encryption.NewLocalKeyProvider("key-1", map[string][]byte{"key-1": newKey(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var published []publishedMessage
+	publisher := pub.NewPubService[*wrapperspb.StringValue](newRecordingSnsClient(&published), "arn:aws:sns:us-east-1:123456789012:notes").
+		WithEncryption(keys)
+	err = publisher.Publish(ctx, models.ProtoMutationEvent[*wrapperspb.StringValue]{
+		EventType:    models.EventTypeUpdated,
+		ResourceType: "note",
+		ResourceID:   "1",
+		Before:       wrapperspb.String("before"),
+		After:        wrapperspb.String("after"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var envelope models.PublishedProtoMutationEvent
+	err = json.Unmarshal([]byte(published[0].body), &envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	envelope.Before, envelope.After = envelope.After, envelope.Before
+	swapped, err := json.Marshal(envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := sub.MutationEventHandlerToStringHandler(func(context.Context, models.ProtoMutationEvent[*wrapperspb.StringValue]) error {
+		t.Error("handler called with swapped payloads")
+		return nil
+	}, func() *wrapperspb.StringValue { return &wrapperspb.StringValue{} }, sub.WithKeyProvider(keys))
+
+	err = handler(sub.WithMessageInfo(ctx, sub.MessageInfo{MessageAttributes: published[0].attributes}), string(swapped))
+	if err == nil {
+		t.Error("handler() of an event with swapped payloads succeeded")
+	}
+}
@@ -0,0 +1,65 @@
+package encryption
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+)
+
+// KMSKeyProvider is a KeyProvider backed by AWS KMS. Data keys are generated and unwrapped by KMS, so master keys
+// never leave it. To rotate keys, point the publishers at a new key ID; subscribers unwrap data keys with the key
+// ID recorded in each event, so they need decrypt permission on both the old and the new key during the rotation.
+type KMSKeyProvider struct {
+	client *kms.Client
+	keyID  string
+}
+
+var _ KeyProvider = (*KMSKeyProvider)(nil)
+
+// NewKMSKeyProvider creates a KMSKeyProvider generating data keys under the given KMS key ID, alias or ARN.
+// Subscribers that only decrypt can pass an empty keyID.
+func NewKMSKeyProvider(client *kms.Client, keyID string) *KMSKeyProvider {
+	return &KMSKeyProvider{
+		client: client,
+		keyID:  keyID,
+	}
+}
+
+// GenerateDataKey implements KeyProvider.
+func (p *KMSKeyProvider) GenerateDataKey(ctx context.Context) (DataKey, error) {
+	out, err := p.client.GenerateDataKey(ctx, &kms.GenerateDataKeyInput{
+		KeyId:   aws.String(p.keyID),
+		KeySpec: kmstypes.DataKeySpecAes256,
+	})
+	if err != nil {
+		return DataKey{}, fmt.Errorf("failed to generate data key with KMS: %w", err)
+	}
+
+	// KMS reports the ARN of the key, which stays valid when an alias is moved to a new key.
+	keyID := p.keyID
+	if out.KeyId != nil {
+		keyID = *out.KeyId
+	}
+
+	return DataKey{
+		KeyID:     keyID,
+		Plaintext: out.Plaintext,
+		Wrapped:   out.CiphertextBlob,
+	}, nil
+}
+
+// DecryptDataKey implements KeyProvider.
+func (p *KMSKeyProvider) DecryptDataKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	out, err := p.client.Decrypt(ctx, &kms.DecryptInput{
+		KeyId:          aws.String(keyID),
+		CiphertextBlob: wrapped,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt data key with KMS: %w", err)
+	}
+
+	return out.Plaintext, nil
+}
@@ -0,0 +1,71 @@
+package encryption
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"maps"
+)
+
+// LocalKeyProvider is a KeyProvider holding AES-256 master keys in memory. It is meant for tests and local
+// development; production deployments should use KMSKeyProvider.
+type LocalKeyProvider struct {
+	currentKeyID string
+	masterKeys   map[string][]byte
+}
+
+var _ KeyProvider = (*LocalKeyProvider)(nil)
+
+// NewLocalKeyProvider creates a LocalKeyProvider that wraps new data keys with masterKeys[currentKeyID] and can
+// unwrap data keys wrapped by any of the master keys. Master keys must be 32 bytes long.
+func NewLocalKeyProvider(currentKeyID string, masterKeys map[string][]byte) (*LocalKeyProvider, error) {
+	if _, ok := masterKeys[currentKeyID]; !ok {
+		return nil, fmt.Errorf("unknown current key id %q", currentKeyID)
+	}
+
+	for keyID, key := range masterKeys {
+		if len(key) != dataKeySize {
+			return nil, fmt.Errorf("master key %q must be %d bytes, got %d", keyID, dataKeySize, len(key))
+		}
+	}
+
+	return &LocalKeyProvider{
+		currentKeyID: currentKeyID,
+		masterKeys:   maps.Clone(masterKeys),
+	}, nil
+}
+
+// GenerateDataKey implements KeyProvider.
+func (p *LocalKeyProvider) GenerateDataKey(_ context.Context) (DataKey, error) {
+	plaintext := make([]byte, dataKeySize)
+	_, err := rand.Read(plaintext)
+	if err != nil {
+		return DataKey{}, fmt.Errorf("failed to generate data key: %w", err)
+	}
+
+	wrapped, err := Encrypt(p.masterKeys[p.currentKeyID], plaintext, []byte(p.currentKeyID))
+	if err != nil {
+		return DataKey{}, fmt.Errorf("failed to wrap data key: %w", err)
+	}
+
+	return DataKey{
+		KeyID:     p.currentKeyID,
+		Plaintext: plaintext,
+		Wrapped:   wrapped,
+	}, nil
+}
+
+// DecryptDataKey implements KeyProvider.
+func (p *LocalKeyProvider) DecryptDataKey(_ context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	masterKey, ok := p.masterKeys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown master key %q", keyID)
+	}
+
+	plaintext, err := Decrypt(masterKey, wrapped, []byte(keyID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+
+	return plaintext, nil
+}
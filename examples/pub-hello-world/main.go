@@ -20,6 +20,7 @@ import (
 	awsconfig "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/sns"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
 )
 
 func run() error {
@@ -52,6 +53,7 @@ func run() error {
 		UserID:        "432f8b1e-4f1c-4d2a-9c3e-1a2b3c4d5e6f",
 		CorrelationID: "432f8b1e-4f1c-4d2a-9c3e-1a2b3c4d5e6f",
 		Reason:        "testing pub-hello-world example",
+		After:         structpb.NewStringValue("hello world"),
 	}
 
 	err = publisher.Publish(ctx, event)
@@ -0,0 +1,223 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// CloudEventsSpecVersion is the version of the CloudEvents specification implemented by CloudEvent.
+	CloudEventsSpecVersion = "1.0"
+
+	// ContentTypeCloudEventsJSON is a CloudEvent in structured content mode.
+	ContentTypeCloudEventsJSON = "application/cloudevents+json"
+
+	// CloudEventsAttributePrefix prefixes the message attributes holding the CloudEvents attributes of an event
+	// in binary content mode, e.g. "ce_id".
+	CloudEventsAttributePrefix = "ce_"
+
+	// defaultCloudEventsSource is used for events published without a Source, since it is required by CloudEvents.
+	defaultCloudEventsSource = "psss"
+)
+
+// CloudEvent is a mutation event represented as a CloudEvents 1.0 event. The event type is derived from the
+// resource type and event type, e.g. "notes.created", the subject is the resource ID and the correlation and user
+// IDs are carried by the "correlationid" and "userid" extensions. The sequence of versioned events is carried by the
+// "sequence" extension, as a decimal string. Everything else is part of the data.
+type CloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Time            time.Time       `json:"time"`
+	Subject         string          `json:"subject,omitempty"`
+	DataContentType string          `json:"datacontenttype,omitempty"`
+	CorrelationID   string          `json:"correlationid,omitempty"`
+	UserID          string          `json:"userid,omitempty"`
+	Sequence        string          `json:"sequence,omitempty"`
+	Data            json.RawMessage `json:"data,omitempty"`
+}
+
+// CloudEventData is the data of a mutation CloudEvent.
+type CloudEventData struct {
+	ResourceType string          `json:"resource_type"`
+	Reason       string          `json:"reason,omitempty"`
+	Before       json.RawMessage `json:"before,omitempty"`
+	After        json.RawMessage `json:"after,omitempty"`
+	MetaData     map[string]any  `json:"metadata,omitempty"`
+	Encryption   *EncryptionInfo `json:"encryption,omitempty"`
+	ProtoType    string          `json:"proto_type,omitempty"`
+}
+
+// NewCloudEvent converts a mutation event envelope into a CloudEvent. Before and After must be nested protojson
+// as written by the ContentTypeJSON encoding, or encrypted.
+func NewCloudEvent(e PublishedProtoMutationEvent) (CloudEvent, error) {
+	data, err := json.Marshal(CloudEventData{
+		ResourceType: e.ResourceType,
+		Reason:       e.Reason,
+		Before:       e.Before,
+		After:        e.After,
+		MetaData:     e.MetaData,
+		Encryption:   e.Encryption,
+		ProtoType:    e.ProtoType,
+	})
+	if err != nil {
+		return CloudEvent{}, fmt.Errorf("marshaling cloudevent data: %w", err)
+	}
+
+	source := e.Source
+	if source == "" {
+		source = defaultCloudEventsSource
+	}
+
+	var sequence string
+	if e.Sequence != 0 {
+		sequence = strconv.FormatUint(e.Sequence, 10)
+	}
+
+	return CloudEvent{
+		SpecVersion:     CloudEventsSpecVersion,
+		ID:              e.EventID,
+		Source:          source,
+		Type:            e.ResourceType + "." + e.EventType.String(),
+		Time:            e.EventTime,
+		Subject:         e.ResourceID,
+		DataContentType: ContentTypeJSON,
+		CorrelationID:   e.CorrelationID,
+		UserID:          e.UserID,
+		Sequence:        sequence,
+		Data:            data,
+	}, nil
+}
+
+// ToPublishedProtoMutationEvent converts the CloudEvent back into a mutation event envelope.
+func (ce CloudEvent) ToPublishedProtoMutationEvent() (PublishedProtoMutationEvent, error) {
+	if ce.SpecVersion != CloudEventsSpecVersion {
+		return PublishedProtoMutationEvent{}, fmt.Errorf("unsupported cloudevents specversion %q", ce.SpecVersion)
+	}
+
+	var data CloudEventData
+	if len(ce.Data) > 0 {
+		err := json.Unmarshal(ce.Data, &data)
+		if err != nil {
+			return PublishedProtoMutationEvent{}, fmt.Errorf("unmarshaling cloudevent data: %w", err)
+		}
+	}
+
+	eventType, err := parseCloudEventType(ce.Type)
+	if err != nil {
+		return PublishedProtoMutationEvent{}, err
+	}
+
+	var sequence uint64
+	if ce.Sequence != "" {
+		sequence, err = strconv.ParseUint(ce.Sequence, 10, 64)
+		if err != nil {
+			return PublishedProtoMutationEvent{}, fmt.Errorf("invalid cloudevent sequence: %w", err)
+		}
+	}
+
+	return PublishedProtoMutationEvent{
+		EventID:       ce.ID,
+		EventType:     eventType,
+		EventTime:     ce.Time,
+		Source:        ce.Source,
+		CorrelationID: ce.CorrelationID,
+		ResourceType:  data.ResourceType,
+		ResourceID:    ce.Subject,
+		Sequence:      sequence,
+		UserID:        ce.UserID,
+		Reason:        data.Reason,
+		Before:        data.Before,
+		After:         data.After,
+		MetaData:      data.MetaData,
+		Encryption:    data.Encryption,
+		ProtoType:     data.ProtoType,
+	}, nil
+}
+
+// BinaryAttributes returns the CloudEvents attributes as message attributes for binary content mode, in which the
+// message body is the event data. The data content type is carried by the AttributeContentType attribute.
+func (ce CloudEvent) BinaryAttributes() map[string]string {
+	attributes := map[string]string{
+		CloudEventsAttributePrefix + "specversion": ce.SpecVersion,
+		CloudEventsAttributePrefix + "id":          ce.ID,
+		CloudEventsAttributePrefix + "source":      ce.Source,
+		CloudEventsAttributePrefix + "type":        ce.Type,
+		CloudEventsAttributePrefix + "time":        ce.Time.Format(time.RFC3339Nano),
+		AttributeContentType:                       ce.DataContentType,
+	}
+
+	optional := map[string]string{
+		"subject":       ce.Subject,
+		"correlationid": ce.CorrelationID,
+		"userid":        ce.UserID,
+		"sequence":      ce.Sequence,
+	}
+	for name, value := range optional {
+		if value != "" {
+			attributes[CloudEventsAttributePrefix+name] = value
+		}
+	}
+
+	return attributes
+}
+
+// IsBinaryCloudEvent reports whether the message attributes describe a CloudEvent in binary content mode.
+func IsBinaryCloudEvent(attributes map[string]string) bool {
+	_, ok := attributes[CloudEventsAttributePrefix+"specversion"]
+	return ok
+}
+
+// CloudEventFromBinary builds a CloudEvent from the message attributes and body of a message in binary content
+// mode. It fails if one of the attributes required by CloudEvents is missing.
+func CloudEventFromBinary(attributes map[string]string, data []byte) (CloudEvent, error) {
+	for _, name := range []string{"specversion", "id", "source", "type"} {
+		if attributes[CloudEventsAttributePrefix+name] == "" {
+			return CloudEvent{}, fmt.Errorf("missing required cloudevent attribute %q", CloudEventsAttributePrefix+name)
+		}
+	}
+
+	ce := CloudEvent{
+		SpecVersion:     attributes[CloudEventsAttributePrefix+"specversion"],
+		ID:              attributes[CloudEventsAttributePrefix+"id"],
+		Source:          attributes[CloudEventsAttributePrefix+"source"],
+		Type:            attributes[CloudEventsAttributePrefix+"type"],
+		Subject:         attributes[CloudEventsAttributePrefix+"subject"],
+		DataContentType: attributes[AttributeContentType],
+		CorrelationID:   attributes[CloudEventsAttributePrefix+"correlationid"],
+		UserID:          attributes[CloudEventsAttributePrefix+"userid"],
+		Sequence:        attributes[CloudEventsAttributePrefix+"sequence"],
+		Data:            data,
+	}
+
+	if t := attributes[CloudEventsAttributePrefix+"time"]; t != "" {
+		eventTime, err := time.Parse(time.RFC3339Nano, t)
+		if err != nil {
+			return CloudEvent{}, fmt.Errorf("invalid cloudevent time: %w", err)
+		}
+		ce.Time = eventTime
+	}
+
+	return ce, nil
+}
+
+// parseCloudEventType returns the EventType encoded in the suffix of a CloudEvent type.
+func parseCloudEventType(ceType string) (EventType, error) {
+	i := strings.LastIndexByte(ceType, '.')
+	if i < 0 {
+		return 0, errors.New("cloudevent type has no event type suffix")
+	}
+
+	for _, t := range []EventType{EventTypeCreated, EventTypeUpdated, EventTypeDeleted} {
+		if ceType[i+1:] == t.String() {
+			return t, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown event type in cloudevent type %q", ceType)
+}
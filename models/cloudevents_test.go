@@ -0,0 +1,129 @@
+package models
+
+import (
+	"encoding/json"
+	"maps"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testPublishedEvent is an event with all the fields carried by CloudEvents attributes set.
+var testPublishedEvent = PublishedProtoMutationEvent{
+	EventID:       "0192d4e0-0000-7000-8000-000000000001",
+	EventType:     EventTypeUpdated,
+	EventTime:     time.Date(2025, 1, 2, 3, 4, 5, 6, time.UTC),
+	Source:        "notes-service",
+	CorrelationID: "request-1",
+	ResourceType:  "note",
+	ResourceID:    "1",
+	Sequence:      7,
+	UserID:        "user-1",
+	Reason:        "edited",
+	Before:        json.RawMessage(`{"value":"before"}`),
+	After:         json.RawMessage(`{"value":"after"}`),
+	MetaData:      map[string]any{"tenant": "acme"},
+}
+
+func TestCloudEventRoundTrip(t *testing.T) {
+	ce, err := NewCloudEvent(testPublishedEvent)
+	if err != nil {
+		t.Fatalf("NewCloudEvent() = %v", err)
+	}
+	if ce.Type != "note.updated" || ce.Subject != "1" || ce.Sequence != "7" {
+		t.Errorf("NewCloudEvent() type = %q, subject = %q, sequence = %q, want note.updated, 1, 7", ce.Type, ce.Subject, ce.Sequence)
+	}
+
+	structured := func(ce CloudEvent) (CloudEvent, error) {
+		b, err := json.Marshal(ce)
+		if err != nil {
+			return CloudEvent{}, err
+		}
+		var decoded CloudEvent
+		err = json.Unmarshal(b, &decoded)
+		return decoded, err
+	}
+	binary := func(ce CloudEvent) (CloudEvent, error) {
+		return CloudEventFromBinary(ce.BinaryAttributes(), ce.Data)
+	}
+
+	for name, transport := range map[string]func(CloudEvent) (CloudEvent, error){"structured": structured, "binary": binary} {
+		t.Run(name, func(t *testing.T) {
+			decoded, err := transport(ce)
+			if err != nil {
+				t.Fatalf("decoding the cloudevent = %v", err)
+			}
+			got, err := decoded.ToPublishedProtoMutationEvent()
+			if err != nil {
+				t.Fatalf("ToPublishedProtoMutationEvent() = %v", err)
+			}
+			if !reflect.DeepEqual(got, testPublishedEvent) {
+				t.Errorf("ToPublishedProtoMutationEvent() = %+v, want %+v", got, testPublishedEvent)
+			}
+		})
+	}
+}
+
+func TestBinaryAttributes(t *testing.T) {
+	ce, err := NewCloudEvent(testPublishedEvent)
+	if err != nil {
+		t.Fatalf("NewCloudEvent() = %v", err)
+	}
+
+	// SNS delivers at most 10 message attributes with raw message delivery, which an event with all the optional
+	// attributes uses up.
+	attributes := ce.BinaryAttributes()
+	if len(attributes) != 10 {
+		t.Errorf("BinaryAttributes() = %v, want 10 attributes", attributes)
+	}
+	if !IsBinaryCloudEvent(attributes) {
+		t.Error("IsBinaryCloudEvent() of the binary attributes = false")
+	}
+	if IsBinaryCloudEvent(map[string]string{AttributeContentType: ContentTypeCloudEventsJSON}) {
+		t.Error("IsBinaryCloudEvent() of a structured cloudevent = true")
+	}
+
+	// the optional attributes are left out when empty.
+	ce.Subject, ce.CorrelationID, ce.UserID, ce.Sequence = "", "", "", ""
+	if attributes := ce.BinaryAttributes(); len(attributes) != 6 {
+		t.Errorf("BinaryAttributes() without the optional attributes = %v, want 6 attributes", attributes)
+	}
+}
+
+func TestCloudEventFromBinary(t *testing.T) {
+	ce, err := NewCloudEvent(testPublishedEvent)
+	if err != nil {
+		t.Fatalf("NewCloudEvent() = %v", err)
+	}
+	complete := ce.BinaryAttributes()
+
+	tests := []struct {
+		name       string
+		attributes func(attributes map[string]string)
+		wantErr    bool
+	}{
+		{name: "complete"},
+		{name: "missing specversion", attributes: func(a map[string]string) { delete(a, "ce_specversion") }, wantErr: true},
+		{name: "missing id", attributes: func(a map[string]string) { delete(a, "ce_id") }, wantErr: true},
+		{name: "missing source", attributes: func(a map[string]string) { delete(a, "ce_source") }, wantErr: true},
+		{name: "missing type", attributes: func(a map[string]string) { delete(a, "ce_type") }, wantErr: true},
+		{name: "empty id", attributes: func(a map[string]string) { a["ce_id"] = "" }, wantErr: true},
+		{name: "invalid time", attributes: func(a map[string]string) { a["ce_time"] = "yesterday" }, wantErr: true},
+		{name: "missing time", attributes: func(a map[string]string) { delete(a, "ce_time") }},
+		{name: "missing subject", attributes: func(a map[string]string) { delete(a, "ce_subject") }},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			attributes := maps.Clone(complete)
+			if test.attributes != nil {
+				test.attributes(attributes)
+			}
+
+			_, err := CloudEventFromBinary(attributes, ce.Data)
+			if (err != nil) != test.wantErr {
+				t.Errorf("CloudEventFromBinary() = %v, want an error: %v", err, test.wantErr)
+			}
+		})
+	}
+}
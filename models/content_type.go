@@ -0,0 +1,24 @@
+package models
+
+const (
+	// AttributeContentType is the SNS/SQS message attribute declaring how the message body is encoded.
+	// Messages without it are decoded as ContentTypeJSON, falling back to the legacy base64 payloads.
+	AttributeContentType = "content_type"
+
+	// AttributeContentEncoding is the SNS/SQS message attribute naming the algorithm the message body was
+	// compressed with. Compressed bodies are base64 encoded because SNS message bodies must be strings.
+	AttributeContentEncoding = "content_encoding"
+)
+
+const (
+	// ContentTypeJSON is a JSON PublishedProtoMutationEvent with Before and After embedded as protojson.
+	ContentTypeJSON = "application/json"
+
+	// ContentTypeProtobufPayloadJSON is a JSON PublishedProtoMutationEvent with Before and After encoded as
+	// base64 strings of the protobuf wire format.
+	ContentTypeProtobufPayloadJSON = "application/vnd.psss.protobuf-payload+json"
+
+	// ContentTypeProtobuf is the whole envelope in protobuf wire format, see MarshalProtoEnvelope, base64 encoded
+	// because SNS message bodies must be strings.
+	ContentTypeProtobuf = "application/vnd.psss.mutation+protobuf"
+)
@@ -0,0 +1,272 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Field numbers of the protobuf form of PublishedProtoMutationEvent, whose wire format is defined by the
+// PublishedProtoMutationEvent message of envelope.proto.
+const (
+	protoFieldEventID       protowire.Number = 1
+	protoFieldEventType     protowire.Number = 2
+	protoFieldEventTime     protowire.Number = 3
+	protoFieldSource        protowire.Number = 4
+	protoFieldCorrelationID protowire.Number = 5
+	protoFieldResourceType  protowire.Number = 6
+	protoFieldResourceID    protowire.Number = 7
+	protoFieldUserID        protowire.Number = 8
+	protoFieldReason        protowire.Number = 9
+	protoFieldBefore        protowire.Number = 10
+	protoFieldAfter         protowire.Number = 11
+	protoFieldMetaData      protowire.Number = 12
+	protoFieldEncryption    protowire.Number = 13
+	protoFieldSequence      protowire.Number = 14
+	protoFieldProtoType     protowire.Number = 15
+
+	protoFieldTimestampSeconds protowire.Number = 1
+	protoFieldTimestampNanos   protowire.Number = 2
+
+	protoFieldEncryptionAlgorithm  protowire.Number = 1
+	protoFieldEncryptionKeyID      protowire.Number = 2
+	protoFieldEncryptionWrappedKey protowire.Number = 3
+)
+
+// MarshalProtoEnvelope encodes the envelope e in protobuf wire format (ContentTypeProtobuf). before and after are
+// the resource states in protobuf wire format; e.Before and e.After are ignored.
+func MarshalProtoEnvelope(e PublishedProtoMutationEvent, before, after []byte) ([]byte, error) {
+	var b []byte
+
+	b = appendStringField(b, protoFieldEventID, e.EventID)
+	if e.EventType != 0 {
+		b = protowire.AppendTag(b, protoFieldEventType, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(e.EventType))
+	}
+	if !e.EventTime.IsZero() {
+		var ts []byte
+		ts = protowire.AppendTag(ts, protoFieldTimestampSeconds, protowire.VarintType)
+		ts = protowire.AppendVarint(ts, uint64(e.EventTime.Unix()))
+		ts = protowire.AppendTag(ts, protoFieldTimestampNanos, protowire.VarintType)
+		ts = protowire.AppendVarint(ts, uint64(e.EventTime.Nanosecond()))
+		b = appendBytesField(b, protoFieldEventTime, ts)
+	}
+	b = appendStringField(b, protoFieldSource, e.Source)
+	b = appendStringField(b, protoFieldCorrelationID, e.CorrelationID)
+	b = appendStringField(b, protoFieldResourceType, e.ResourceType)
+	b = appendStringField(b, protoFieldResourceID, e.ResourceID)
+	b = appendStringField(b, protoFieldUserID, e.UserID)
+	b = appendStringField(b, protoFieldReason, e.Reason)
+	b = appendBytesField(b, protoFieldBefore, before)
+	b = appendBytesField(b, protoFieldAfter, after)
+
+	if len(e.MetaData) > 0 {
+		metaData, err := json.Marshal(e.MetaData)
+		if err != nil {
+			return nil, fmt.Errorf("marshaling metadata: %w", err)
+		}
+		b = appendBytesField(b, protoFieldMetaData, metaData)
+	}
+
+	if e.Encryption != nil {
+		var enc []byte
+		enc = appendStringField(enc, protoFieldEncryptionAlgorithm, e.Encryption.Algorithm)
+		enc = appendStringField(enc, protoFieldEncryptionKeyID, e.Encryption.KeyID)
+		enc = appendBytesField(enc, protoFieldEncryptionWrappedKey, e.Encryption.WrappedKey)
+		b = appendBytesField(b, protoFieldEncryption, enc)
+	}
+
+	if e.Sequence != 0 {
+		b = protowire.AppendTag(b, protoFieldSequence, protowire.VarintType)
+		b = protowire.AppendVarint(b, e.Sequence)
+	}
+	b = appendStringField(b, protoFieldProtoType, e.ProtoType)
+
+	return b, nil
+}
+
+// UnmarshalProtoEnvelope decodes an envelope encoded by MarshalProtoEnvelope. It returns the envelope, with
+// Before and After unset, and the resource states in protobuf wire format. Unknown fields are skipped so that
+// fields can be added to the envelope without breaking older consumers.
+func UnmarshalProtoEnvelope(b []byte) (e PublishedProtoMutationEvent, before, after []byte, err error) {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return e, nil, nil, fmt.Errorf("invalid envelope tag: %w", protowire.ParseError(n))
+		}
+		b = b[n:]
+
+		switch {
+		case num == protoFieldEventType && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return e, nil, nil, fmt.Errorf("invalid event_type: %w", protowire.ParseError(n))
+			}
+			e.EventType = EventType(v)
+			b = b[n:]
+		case num == protoFieldSequence && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return e, nil, nil, fmt.Errorf("invalid sequence: %w", protowire.ParseError(n))
+			}
+			e.Sequence = v
+			b = b[n:]
+		case typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return e, nil, nil, fmt.Errorf("invalid field %d: %w", num, protowire.ParseError(n))
+			}
+			b = b[n:]
+
+			err = setProtoEnvelopeField(&e, &before, &after, num, v)
+			if err != nil {
+				return e, nil, nil, err
+			}
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return e, nil, nil, fmt.Errorf("invalid field %d: %w", num, protowire.ParseError(n))
+			}
+			b = b[n:]
+		}
+	}
+
+	return e, before, after, nil
+}
+
+func setProtoEnvelopeField(e *PublishedProtoMutationEvent, before, after *[]byte, num protowire.Number, v []byte) error {
+	switch num {
+	case protoFieldEventID:
+		e.EventID = string(v)
+	case protoFieldEventTime:
+		eventTime, err := consumeTimestamp(v)
+		if err != nil {
+			return fmt.Errorf("invalid event_time: %w", err)
+		}
+		e.EventTime = eventTime
+	case protoFieldSource:
+		e.Source = string(v)
+	case protoFieldCorrelationID:
+		e.CorrelationID = string(v)
+	case protoFieldResourceType:
+		e.ResourceType = string(v)
+	case protoFieldResourceID:
+		e.ResourceID = string(v)
+	case protoFieldUserID:
+		e.UserID = string(v)
+	case protoFieldReason:
+		e.Reason = string(v)
+	case protoFieldProtoType:
+		e.ProtoType = string(v)
+	case protoFieldBefore:
+		*before = v
+	case protoFieldAfter:
+		*after = v
+	case protoFieldMetaData:
+		err := json.Unmarshal(v, &e.MetaData)
+		if err != nil {
+			return fmt.Errorf("invalid metadata: %w", err)
+		}
+	case protoFieldEncryption:
+		encryption, err := consumeEncryptionInfo(v)
+		if err != nil {
+			return fmt.Errorf("invalid encryption: %w", err)
+		}
+		e.Encryption = encryption
+	}
+	return nil
+}
+
+func consumeEncryptionInfo(b []byte) (*EncryptionInfo, error) {
+	info := &EncryptionInfo{}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		if typ != protowire.BytesType {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			b = b[n:]
+			continue
+		}
+
+		v, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		switch num {
+		case protoFieldEncryptionAlgorithm:
+			info.Algorithm = string(v)
+		case protoFieldEncryptionKeyID:
+			info.KeyID = string(v)
+		case protoFieldEncryptionWrappedKey:
+			info.WrappedKey = v
+		}
+	}
+	return info, nil
+}
+
+func consumeTimestamp(b []byte) (time.Time, error) {
+	var seconds, nanos uint64
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return time.Time{}, protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		if typ != protowire.VarintType {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return time.Time{}, protowire.ParseError(n)
+			}
+			b = b[n:]
+			continue
+		}
+
+		v, n := protowire.ConsumeVarint(b)
+		if n < 0 {
+			return time.Time{}, protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		switch num {
+		case protoFieldTimestampSeconds:
+			seconds = v
+		case protoFieldTimestampNanos:
+			nanos = v
+		}
+	}
+
+	if nanos >= uint64(time.Second) {
+		return time.Time{}, errors.New("nanos out of range")
+	}
+
+	return time.Unix(int64(seconds), int64(nanos)).UTC(), nil
+}
+
+func appendStringField(b []byte, num protowire.Number, v string) []byte {
+	if v == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, v)
+}
+
+func appendBytesField(b []byte, num protowire.Number, v []byte) []byte {
+	if v == nil {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
@@ -0,0 +1,188 @@
+package models
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bufbuild/protocompile"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// testProtoEnvelope is an envelope with every field of the protobuf form set.
+var testProtoEnvelope = PublishedProtoMutationEvent{
+	EventID:       "0192d4e0-0000-7000-8000-000000000001",
+	EventType:     EventTypeDeleted,
+	EventTime:     time.Date(2025, 1, 2, 3, 4, 5, 6, time.UTC),
+	Source:        "notes-service",
+	CorrelationID: "request-1",
+	ResourceType:  "note",
+	ResourceID:    "1",
+	UserID:        "user-1",
+	Reason:        "cleanup",
+	MetaData:      map[string]any{"tenant": "acme"},
+	Encryption:    &EncryptionInfo{Algorithm: "AES-256-GCM", KeyID: "key-1", WrappedKey: []byte("wrapped")},
+	Sequence:      7,
+	ProtoType:     "notes.v1.Note",
+}
+
+func TestProtoEnvelopeRoundTrip(t *testing.T) {
+	before, after := []byte("before"), []byte("after")
+
+	b, err := MarshalProtoEnvelope(testProtoEnvelope, before, after)
+	if err != nil {
+		t.Fatalf("MarshalProtoEnvelope() = %v", err)
+	}
+
+	got, gotBefore, gotAfter, err := UnmarshalProtoEnvelope(b)
+	if err != nil {
+		t.Fatalf("UnmarshalProtoEnvelope() = %v", err)
+	}
+	if !reflect.DeepEqual(got, testProtoEnvelope) {
+		t.Errorf("UnmarshalProtoEnvelope() = %+v, want %+v", got, testProtoEnvelope)
+	}
+	if string(gotBefore) != "before" || string(gotAfter) != "after" {
+		t.Errorf("UnmarshalProtoEnvelope() before = %q, after = %q, want before, after", gotBefore, gotAfter)
+	}
+}
+
+// envelopeDescriptor compiles envelope.proto and returns the descriptor of its PublishedProtoMutationEvent message.
+func envelopeDescriptor(t *testing.T) protoreflect.MessageDescriptor {
+	t.Helper()
+
+	compiler := protocompile.Compiler{
+		Resolver: protocompile.WithStandardImports(&protocompile.SourceResolver{}),
+	}
+	files, err := compiler.Compile(context.Background(), "envelope.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files[0].Messages().ByName("PublishedProtoMutationEvent")
+}
+
+// TestProtoEnvelopeMatchesProtoFile checks that envelope.proto documents the wire format of MarshalProtoEnvelope.
+func TestProtoEnvelopeMatchesProtoFile(t *testing.T) {
+	b, err := MarshalProtoEnvelope(testProtoEnvelope, []byte("before"), []byte("after"))
+	if err != nil {
+		t.Fatalf("MarshalProtoEnvelope() = %v", err)
+	}
+
+	message := dynamicpb.NewMessage(envelopeDescriptor(t))
+	err = proto.Unmarshal(b, message)
+	if err != nil {
+		t.Fatalf("proto.Unmarshal() = %v", err)
+	}
+	if unknown := message.GetUnknown(); len(unknown) > 0 {
+		t.Errorf("envelope.proto doesn't declare some fields of the envelope: %v", unknown)
+	}
+
+	fields := message.Descriptor().Fields()
+	get := func(name protoreflect.Name) protoreflect.Value {
+		return message.Get(fields.ByName(name))
+	}
+	eventTime := get("event_time").Message()
+	timeFields := eventTime.Descriptor().Fields()
+	encryption := get("encryption").Message()
+	encryptionFields := encryption.Descriptor().Fields()
+
+	tests := map[string]struct {
+		got  any
+		want any
+	}{
+		"event_id":               {get("event_id").String(), testProtoEnvelope.EventID},
+		"event_type":             {get("event_type").Uint(), uint64(testProtoEnvelope.EventType)},
+		"event_time.seconds":     {eventTime.Get(timeFields.ByName("seconds")).Int(), testProtoEnvelope.EventTime.Unix()},
+		"event_time.nanos":       {eventTime.Get(timeFields.ByName("nanos")).Int(), int64(testProtoEnvelope.EventTime.Nanosecond())},
+		"source":                 {get("source").String(), testProtoEnvelope.Source},
+		"correlation_id":         {get("correlation_id").String(), testProtoEnvelope.CorrelationID},
+		"resource_type":          {get("resource_type").String(), testProtoEnvelope.ResourceType},
+		"resource_id":            {get("resource_id").String(), testProtoEnvelope.ResourceID},
+		"user_id":                {get("user_id").String(), testProtoEnvelope.UserID},
+		"reason":                 {get("reason").String(), testProtoEnvelope.Reason},
+		"before":                 {string(get("before").Bytes()), "before"},
+		"after":                  {string(get("after").Bytes()), "after"},
+		"metadata":               {string(get("metadata").Bytes()), `{"tenant":"acme"}`},
+		"encryption.algorithm":   {encryption.Get(encryptionFields.ByName("algorithm")).String(), testProtoEnvelope.Encryption.Algorithm},
+		"encryption.key_id":      {encryption.Get(encryptionFields.ByName("key_id")).String(), testProtoEnvelope.Encryption.KeyID},
+		"encryption.wrapped_key": {string(encryption.Get(encryptionFields.ByName("wrapped_key")).Bytes()), "wrapped"},
+		"sequence":               {get("sequence").Uint(), testProtoEnvelope.Sequence},
+		"proto_type":             {get("proto_type").String(), testProtoEnvelope.ProtoType},
+	}
+	for name, test := range tests {
+		if test.got != test.want {
+			t.Errorf("%s = %v, want %v", name, test.got, test.want)
+		}
+	}
+
+	// and the other way around, envelopes encoded from envelope.proto are decoded.
+	b, err = proto.Marshal(message)
+	if err != nil {
+		t.Fatalf("proto.Marshal() = %v", err)
+	}
+	got, _, _, err := UnmarshalProtoEnvelope(b)
+	if err != nil {
+		t.Fatalf("UnmarshalProtoEnvelope() = %v", err)
+	}
+	if !reflect.DeepEqual(got, testProtoEnvelope) {
+		t.Errorf("UnmarshalProtoEnvelope() = %+v, want %+v", got, testProtoEnvelope)
+	}
+}
+
+func TestUnmarshalProtoEnvelopeSkipsUnknownFields(t *testing.T) {
+	b, err := MarshalProtoEnvelope(testProtoEnvelope, nil, nil)
+	if err != nil {
+		t.Fatalf("MarshalProtoEnvelope() = %v", err)
+	}
+
+	// fields added by newer publishers, of every wire type.
+	b = protowire.AppendTag(b, 100, protowire.VarintType)
+	b = protowire.AppendVarint(b, 42)
+	b = protowire.AppendTag(b, 101, protowire.BytesType)
+	b = protowire.AppendString(b, "new")
+	b = protowire.AppendTag(b, 102, protowire.Fixed32Type)
+	b = protowire.AppendFixed32(b, 42)
+	b = protowire.AppendTag(b, 103, protowire.Fixed64Type)
+	b = protowire.AppendFixed64(b, 42)
+	// and a known field with an unexpected wire type.
+	b = protowire.AppendTag(b, protoFieldSequence, protowire.Fixed64Type)
+	b = protowire.AppendFixed64(b, 42)
+
+	got, _, _, err := UnmarshalProtoEnvelope(b)
+	if err != nil {
+		t.Fatalf("UnmarshalProtoEnvelope() = %v", err)
+	}
+	if !reflect.DeepEqual(got, testProtoEnvelope) {
+		t.Errorf("UnmarshalProtoEnvelope() = %+v, want %+v", got, testProtoEnvelope)
+	}
+}
+
+func TestUnmarshalProtoEnvelopeTruncated(t *testing.T) {
+	b, err := MarshalProtoEnvelope(testProtoEnvelope, []byte("before"), []byte("after"))
+	if err != nil {
+		t.Fatalf("MarshalProtoEnvelope() = %v", err)
+	}
+
+	tests := map[string][]byte{
+		"truncated field":     b[:len(b)-1],
+		"truncated tag":       {0x80},
+		"truncated varint":    protowire.AppendTag(nil, protoFieldSequence, protowire.VarintType),
+		"truncated length":    append(protowire.AppendTag(nil, protoFieldEventID, protowire.BytesType), 0x05, 'a'),
+		"truncated timestamp": protowire.AppendBytes(protowire.AppendTag(nil, protoFieldEventTime, protowire.BytesType), []byte{0x08}),
+		"truncated encryption": protowire.AppendBytes(protowire.AppendTag(nil, protoFieldEncryption, protowire.BytesType),
+			[]byte{0x0a, 0x05}),
+		"invalid metadata": protowire.AppendString(protowire.AppendTag(nil, protoFieldMetaData, protowire.BytesType), "{"),
+	}
+
+	for name, b := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, _, _, err := UnmarshalProtoEnvelope(b)
+			if err == nil {
+				t.Error("UnmarshalProtoEnvelope() succeeded, want an error")
+			}
+		})
+	}
+}
@@ -1,6 +1,9 @@
 package models
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
 	"time"
 
 	"google.golang.org/protobuf/proto"
@@ -37,6 +40,11 @@ type ProtoMutationEvent[T proto.Message] struct {
 	// Unique identifier for the affected resource
 	ResourceID string
 
+	// Sequence is the version of the resource after the event, set by the producer to a number that increases by
+	// one with each event of the resource, starting at 1. Zero means the event is not versioned. Consumers use it
+	// to detect stale, duplicate and missing events, see sub.WithVersionGuard.
+	Sequence uint64
+
 	// 'user_id' of the user who performed the action
 	UserID string
 
@@ -76,18 +84,71 @@ type PublishedProtoMutationEvent struct {
 	// Unique identifier for the affected resource
 	ResourceID string `json:"resource_id"`
 
+	// Sequence is the version of the resource after the event, zero if the event is not versioned.
+	Sequence uint64 `json:"sequence,omitempty"`
+
 	// 'user_id' of the user who performed the action
 	UserID string `json:"performed_by"`
 
 	// Explanation or justification for the event (if applicable)
 	Reason string `json:"reason"`
 
-	// State of the resource before the event occurred
-	Before []byte `json:"before,omitempty"`
+	// State of the resource before the event occurred, embedded as protojson.
+	// Older publishers encoded it as a base64 string, use DecodeProtoJSON to read either form.
+	Before json.RawMessage `json:"before,omitempty"`
 
-	// State of the resource after the event occurred
-	After []byte `json:"after,omitempty"`
+	// State of the resource after the event occurred, embedded as protojson.
+	// Older publishers encoded it as a base64 string, use DecodeProtoJSON to read either form.
+	After json.RawMessage `json:"after,omitempty"`
 
 	// Additional metadata related to the event
 	MetaData map[string]any `json:"metadata,omitempty"`
+
+	// Encryption is set when Before and After are encrypted. They are then base64 strings of the ciphertext.
+	Encryption *EncryptionInfo `json:"encryption,omitempty"`
+
+	// ProtoType is the full name of the proto message type of Before and After, e.g. "notes.v1.Note", if the
+	// publisher records it. It lets consumers decode events of any type without a registry.
+	ProtoType string `json:"proto_type,omitempty"`
+}
+
+// EncryptionInfo describes how the Before and After payloads of an event were encrypted.
+type EncryptionInfo struct {
+	// Algorithm used to encrypt the payloads with the data key, e.g. "AES-256-GCM".
+	Algorithm string `json:"algorithm"`
+
+	// ID of the master key that wrapped the data key.
+	KeyID string `json:"key_id"`
+
+	// Data key wrapped by the master key.
+	WrappedKey []byte `json:"wrapped_key"`
+}
+
+// DecodeProtoJSON returns the protojson document held by the Before or After field of a PublishedProtoMutationEvent.
+// It accepts both the nested JSON written by current publishers and the base64 encoded string written by legacy
+// ones. Some well-known types such as google.protobuf.StringValue or Timestamp are represented as JSON strings, so
+// a string is only taken as legacy base64 when it decodes to a JSON document, as the legacy protojson payloads
+// always do. It returns nil if the field is absent or null.
+// When the message declares ContentTypeJSON the payload is always nested JSON and this guesswork isn't needed.
+func DecodeProtoJSON(raw json.RawMessage) ([]byte, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+
+	if raw[0] != '"' {
+		return raw, nil
+	}
+
+	var s string
+	err := json.Unmarshal(raw, &s)
+	if err != nil {
+		return nil, fmt.Errorf("decoding string payload: %w", err)
+	}
+
+	legacy, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || !json.Valid(legacy) {
+		return raw, nil
+	}
+
+	return legacy, nil
 }
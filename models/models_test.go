@@ -0,0 +1,67 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestDecodeProtoJSON(t *testing.T) {
+	tests := map[string]proto.Message{
+		"string value": wrapperspb.String("abcd"),
+		"empty string": wrapperspb.String(""),
+		"base64 word":  wrapperspb.String("dGVzdA=="),
+		"timestamp":    timestamppb.New(time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)),
+		"duration":     durationpb.New(1500 * time.Millisecond),
+		"struct":       &structpb.Struct{Fields: map[string]*structpb.Value{"title": structpb.NewStringValue("abcd")}},
+		"int64 value":  wrapperspb.Int64(42),
+		"bytes value":  wrapperspb.Bytes([]byte("abcd")),
+		"bool value":   wrapperspb.Bool(true),
+		"list value":   &structpb.ListValue{Values: []*structpb.Value{structpb.NewStringValue("abcd")}},
+	}
+
+	for name, message := range tests {
+		payload, err := protojson.Marshal(message)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		t.Run(name+"/nested", func(t *testing.T) {
+			assertDecodesTo(t, json.RawMessage(payload), message)
+		})
+
+		t.Run(name+"/legacy", func(t *testing.T) {
+			legacy, err := json.Marshal(base64.StdEncoding.EncodeToString(payload))
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertDecodesTo(t, legacy, message)
+		})
+	}
+}
+
+func assertDecodesTo(t *testing.T, raw json.RawMessage, want proto.Message) {
+	t.Helper()
+
+	b, err := DecodeProtoJSON(raw)
+	if err != nil {
+		t.Fatalf("DecodeProtoJSON(%s) = %v", raw, err)
+	}
+
+	got := want.ProtoReflect().New().Interface()
+	err = protojson.Unmarshal(b, got)
+	if err != nil {
+		t.Fatalf("DecodeProtoJSON(%s) = %q, which isn't a %T: %v", raw, b, want, err)
+	}
+	if !proto.Equal(got, want) {
+		t.Errorf("DecodeProtoJSON(%s) decodes to %v, want %v", raw, got, want)
+	}
+}
@@ -0,0 +1,90 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// String returns the name of the event type, e.g. "created".
+func (t EventType) String() string {
+	switch t {
+	case EventTypeCreated:
+		return "created"
+	case EventTypeUpdated:
+		return "updated"
+	case EventTypeDeleted:
+		return "deleted"
+	default:
+		return fmt.Sprintf("EventType(%d)", uint8(t))
+	}
+}
+
+// IsValid reports whether t is one of the known event types.
+func (t EventType) IsValid() bool {
+	return t == EventTypeCreated || t == EventTypeUpdated || t == EventTypeDeleted
+}
+
+// ValidationError is returned when a mutation event envelope is invalid. It lists every rule the event violates.
+type ValidationError struct {
+	Violations []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid mutation event: " + strings.Join(e.Violations, "; ")
+}
+
+// Validate checks that the event envelope is complete and consistent with its EventType:
+// created events must have After and no Before, updated events must have After and deleted events must have Before.
+// It returns a *ValidationError listing every violated rule, or nil if the event is valid.
+func (e ProtoMutationEvent[T]) Validate() error {
+	var violations []string
+
+	if e.EventID == "" {
+		violations = append(violations, "event_id is required")
+	}
+	if e.EventTime.IsZero() {
+		violations = append(violations, "event_time is required")
+	}
+	if e.ResourceType == "" {
+		violations = append(violations, "resource_type is required")
+	}
+	if e.ResourceID == "" {
+		violations = append(violations, "resource_id is required")
+	}
+
+	hasBefore := !isEmptyMessage(e.Before)
+	hasAfter := !isEmptyMessage(e.After)
+
+	switch e.EventType {
+	case EventTypeCreated:
+		if !hasAfter {
+			violations = append(violations, "created events must have after")
+		}
+		if hasBefore {
+			violations = append(violations, "created events must not have before")
+		}
+	case EventTypeUpdated:
+		if !hasAfter {
+			violations = append(violations, "updated events must have after")
+		}
+	case EventTypeDeleted:
+		if !hasBefore {
+			violations = append(violations, "deleted events must have before")
+		}
+	default:
+		violations = append(violations, fmt.Sprintf("unknown event_type %d", uint8(e.EventType)))
+	}
+
+	if len(violations) > 0 {
+		return &ValidationError{Violations: violations}
+	}
+
+	return nil
+}
+
+// isEmptyMessage reports whether m is nil, including typed nil pointers of generated messages.
+func isEmptyMessage(m proto.Message) bool {
+	return m == nil || !m.ProtoReflect().IsValid()
+}
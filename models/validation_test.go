@@ -0,0 +1,160 @@
+package models
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestEventType(t *testing.T) {
+	tests := []struct {
+		eventType EventType
+		name      string
+		valid     bool
+	}{
+		{eventType: EventTypeCreated, name: "created", valid: true},
+		{eventType: EventTypeUpdated, name: "updated", valid: true},
+		{eventType: EventTypeDeleted, name: "deleted", valid: true},
+		{eventType: 0, name: "EventType(0)"},
+		{eventType: 4, name: "EventType(4)"},
+	}
+
+	for _, test := range tests {
+		if got := test.eventType.String(); got != test.name {
+			t.Errorf("EventType(%d).String() = %q, want %q", uint8(test.eventType), got, test.name)
+		}
+		if got := test.eventType.IsValid(); got != test.valid {
+			t.Errorf("EventType(%d).IsValid() = %v, want %v", uint8(test.eventType), got, test.valid)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := ProtoMutationEvent[*wrapperspb.StringValue]{
+		EventID:      "1",
+		EventType:    EventTypeUpdated,
+		EventTime:    time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC),
+		ResourceType: "note",
+		ResourceID:   "1",
+		Before:       wrapperspb.String("before"),
+		After:        wrapperspb.String("after"),
+	}
+
+	tests := []struct {
+		name  string
+		event func(e *ProtoMutationEvent[*wrapperspb.StringValue])
+		want  []string
+	}{
+		{name: "valid update"},
+		{name: "update without before", event: func(e *ProtoMutationEvent[*wrapperspb.StringValue]) { e.Before = nil }},
+		{name: "valid creation", event: func(e *ProtoMutationEvent[*wrapperspb.StringValue]) {
+			e.EventType = EventTypeCreated
+			e.Before = nil
+		}},
+		{name: "valid deletion", event: func(e *ProtoMutationEvent[*wrapperspb.StringValue]) {
+			e.EventType = EventTypeDeleted
+			e.After = nil
+		}},
+		{
+			name:  "missing event_id",
+			event: func(e *ProtoMutationEvent[*wrapperspb.StringValue]) { e.EventID = "" },
+			want:  []string{"event_id is required"},
+		},
+		{
+			name:  "missing event_time",
+			event: func(e *ProtoMutationEvent[*wrapperspb.StringValue]) { e.EventTime = time.Time{} },
+			want:  []string{"event_time is required"},
+		},
+		{
+			name:  "missing resource_type",
+			event: func(e *ProtoMutationEvent[*wrapperspb.StringValue]) { e.ResourceType = "" },
+			want:  []string{"resource_type is required"},
+		},
+		{
+			name:  "missing resource_id",
+			event: func(e *ProtoMutationEvent[*wrapperspb.StringValue]) { e.ResourceID = "" },
+			want:  []string{"resource_id is required"},
+		},
+		{
+			name: "creation with before and without after",
+			event: func(e *ProtoMutationEvent[*wrapperspb.StringValue]) {
+				e.EventType = EventTypeCreated
+				e.After = nil
+			},
+			want: []string{"created events must have after", "created events must not have before"},
+		},
+		{
+			name:  "update without after",
+			event: func(e *ProtoMutationEvent[*wrapperspb.StringValue]) { e.After = nil },
+			want:  []string{"updated events must have after"},
+		},
+		{
+			name: "deletion without before",
+			event: func(e *ProtoMutationEvent[*wrapperspb.StringValue]) {
+				e.EventType = EventTypeDeleted
+				e.Before = nil
+			},
+			want: []string{"deleted events must have before"},
+		},
+		{
+			// typed nil pointers count as missing.
+			name:  "typed nil after",
+			event: func(e *ProtoMutationEvent[*wrapperspb.StringValue]) { e.After = (*wrapperspb.StringValue)(nil) },
+			want:  []string{"updated events must have after"},
+		},
+		{
+			name:  "unknown event type",
+			event: func(e *ProtoMutationEvent[*wrapperspb.StringValue]) { e.EventType = 7 },
+			want:  []string{"unknown event_type 7"},
+		},
+		{
+			name: "every violation is listed",
+			event: func(e *ProtoMutationEvent[*wrapperspb.StringValue]) {
+				*e = ProtoMutationEvent[*wrapperspb.StringValue]{}
+			},
+			want: []string{
+				"event_id is required",
+				"event_time is required",
+				"resource_type is required",
+				"resource_id is required",
+				"unknown event_type 0",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			event := valid
+			if test.event != nil {
+				test.event(&event)
+			}
+
+			err := event.Validate()
+			if test.want == nil {
+				if err != nil {
+					t.Errorf("Validate() = %v", err)
+				}
+				return
+			}
+
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("Validate() = %v, want a *ValidationError", err)
+			}
+			if !slices.Equal(validationErr.Violations, test.want) {
+				t.Errorf("Validate() violations = %q, want %q", validationErr.Violations, test.want)
+			}
+		})
+	}
+}
+
+func TestValidationError(t *testing.T) {
+	err := &ValidationError{Violations: []string{"event_id is required", "resource_id is required"}}
+	want := "invalid mutation event: event_id is required; resource_id is required"
+	if err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err.Error(), want)
+	}
+}
@@ -0,0 +1,181 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.9
+// 	protoc        (unknown)
+// source: psssoptions/options.proto
+
+package psssoptions
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	descriptorpb "google.golang.org/protobuf/types/descriptorpb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// Resource describes the mutation events of a message, for protoc-gen-psss.
+type Resource struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Resource type of the events, e.g. "note".
+	Type string `protobuf:"bytes,1,opt,name=type,proto3" json:"type,omitempty"`
+	// Name of the SNS topic the events are published to, if any.
+	Topic string `protobuf:"bytes,2,opt,name=topic,proto3" json:"topic,omitempty"`
+	// Event types of the resource: "created", "updated" and/or "deleted". Defaults to all of them.
+	EventTypes []string `protobuf:"bytes,3,rep,name=event_types,json=eventTypes,proto3" json:"event_types,omitempty"`
+	// Name of the string field of the message holding the resource ID, if any.
+	IdField       string `protobuf:"bytes,4,opt,name=id_field,json=idField,proto3" json:"id_field,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Resource) Reset() {
+	*x = Resource{}
+	mi := &file_psssoptions_options_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Resource) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Resource) ProtoMessage() {}
+
+func (x *Resource) ProtoReflect() protoreflect.Message {
+	mi := &file_psssoptions_options_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Resource.ProtoReflect.Descriptor instead.
+func (*Resource) Descriptor() ([]byte, []int) {
+	return file_psssoptions_options_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Resource) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *Resource) GetTopic() string {
+	if x != nil {
+		return x.Topic
+	}
+	return ""
+}
+
+func (x *Resource) GetEventTypes() []string {
+	if x != nil {
+		return x.EventTypes
+	}
+	return nil
+}
+
+func (x *Resource) GetIdField() string {
+	if x != nil {
+		return x.IdField
+	}
+	return ""
+}
+
+var file_psssoptions_options_proto_extTypes = []protoimpl.ExtensionInfo{
+	{
+		ExtendedType:  (*descriptorpb.MessageOptions)(nil),
+		ExtensionType: (*Resource)(nil),
+		Field:         1291,
+		Name:          "psss.options.resource",
+		Tag:           "bytes,1291,opt,name=resource",
+		Filename:      "psssoptions/options.proto",
+	},
+}
+
+// Extension fields to descriptorpb.MessageOptions.
+var (
+	// Declares the message as a resource whose mutation events are published with psss.
+	//
+	// optional psss.options.Resource resource = 1291;
+	E_Resource = &file_psssoptions_options_proto_extTypes[0]
+)
+
+var File_psssoptions_options_proto protoreflect.FileDescriptor
+
+const file_psssoptions_options_proto_rawDesc = "" +
+	"\n" +
+	"\x19psssoptions/options.proto\x12\fpsss.options\x1a google/protobuf/descriptor.proto\"p\n" +
+	"\bResource\x12\x12\n" +
+	"\x04type\x18\x01 \x01(\tR\x04type\x12\x14\n" +
+	"\x05topic\x18\x02 \x01(\tR\x05topic\x12\x1f\n" +
+	"\vevent_types\x18\x03 \x03(\tR\n" +
+	"eventTypes\x12\x19\n" +
+	"\bid_field\x18\x04 \x01(\tR\aidField:T\n" +
+	"\bresource\x12\x1f.google.protobuf.MessageOptions\x18\x8b\n" +
+	" \x01(\v2\x16.psss.options.ResourceR\bresourceB*Z(github.com/Iknite-Space/psss/psssoptionsb\x06proto3"
+
+var (
+	file_psssoptions_options_proto_rawDescOnce sync.Once
+	file_psssoptions_options_proto_rawDescData []byte
+)
+
+func file_psssoptions_options_proto_rawDescGZIP() []byte {
+	file_psssoptions_options_proto_rawDescOnce.Do(func() {
+		file_psssoptions_options_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_psssoptions_options_proto_rawDesc), len(file_psssoptions_options_proto_rawDesc)))
+	})
+	return file_psssoptions_options_proto_rawDescData
+}
+
+var file_psssoptions_options_proto_msgTypes = make([]protoimpl.MessageInfo, 1)
+var file_psssoptions_options_proto_goTypes = []any{
+	(*Resource)(nil),                    // 0: psss.options.Resource
+	(*descriptorpb.MessageOptions)(nil), // 1: google.protobuf.MessageOptions
+}
+var file_psssoptions_options_proto_depIdxs = []int32{
+	1, // 0: psss.options.resource:extendee -> google.protobuf.MessageOptions
+	0, // 1: psss.options.resource:type_name -> psss.options.Resource
+	2, // [2:2] is the sub-list for method output_type
+	2, // [2:2] is the sub-list for method input_type
+	1, // [1:2] is the sub-list for extension type_name
+	0, // [0:1] is the sub-list for extension extendee
+	0, // [0:0] is the sub-list for field type_name
+}
+
+func init() { file_psssoptions_options_proto_init() }
+func file_psssoptions_options_proto_init() {
+	if File_psssoptions_options_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_psssoptions_options_proto_rawDesc), len(file_psssoptions_options_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   1,
+			NumExtensions: 1,
+			NumServices:   0,
+		},
+		GoTypes:           file_psssoptions_options_proto_goTypes,
+		DependencyIndexes: file_psssoptions_options_proto_depIdxs,
+		MessageInfos:      file_psssoptions_options_proto_msgTypes,
+		ExtensionInfos:    file_psssoptions_options_proto_extTypes,
+	}.Build()
+	File_psssoptions_options_proto = out.File
+	file_psssoptions_options_proto_goTypes = nil
+	file_psssoptions_options_proto_depIdxs = nil
+}
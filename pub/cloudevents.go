@@ -0,0 +1,67 @@
+package pub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/Iknite-Space/psss/models"
+)
+
+// Envelope selects the envelope format of published events.
+type Envelope uint8
+
+const (
+	// EnvelopeNative publishes models.PublishedProtoMutationEvent envelopes.
+	EnvelopeNative Envelope = iota
+
+	// EnvelopeCloudEventsStructured publishes models.CloudEvent envelopes in structured content mode: the message
+	// body is the JSON encoded CloudEvent.
+	EnvelopeCloudEventsStructured
+
+	// EnvelopeCloudEventsBinary publishes models.CloudEvent envelopes in binary content mode: the message body is
+	// the event data and the CloudEvents attributes are sent as "ce_" prefixed message attributes.
+	// Note that SNS delivers at most 10 message attributes to SQS with raw message delivery, and this mode uses up
+	// to 10 of them once the events carry a sequence number, leaving no headroom for other attributes. Publishing
+	// fails when the signature attributes of WithSigner or the content encoding attribute of WithCompression don't
+	// fit.
+	EnvelopeCloudEventsBinary
+)
+
+// WithEnvelope sets the envelope format of published events. CloudEvents envelopes only support EncodingJSON, and
+// the binary content mode leaves little room for the attributes of WithSigner and WithCompression.
+func (s *SNSPublisher[T]) WithEnvelope(envelope Envelope) *SNSPublisher[T] {
+	s.envelope = envelope
+	return s
+}
+
+// encodeCloudEvent serializes the event as a CloudEvent in the publisher's content mode.
+func (s *SNSPublisher[T]) encodeCloudEvent(ctx context.Context, e models.ProtoMutationEvent[T]) (string, map[string]string, error) {
+	if s.encoding != EncodingJSON || s.legacyBase64Payloads {
+		return "", nil, errors.New("cloudevents envelopes require the JSON encoding")
+	}
+
+	payload, err := s.newJSONEnvelope(ctx, e)
+	if err != nil {
+		return "", nil, err
+	}
+
+	ce, err := models.NewCloudEvent(payload)
+	if err != nil {
+		return "", nil, err
+	}
+
+	switch s.envelope {
+	case EnvelopeCloudEventsStructured:
+		b, err := json.Marshal(ce)
+		if err != nil {
+			return "", nil, fmt.Errorf("marshaling cloudevent: %w", err)
+		}
+		return string(b), contentTypeAttributes(models.ContentTypeCloudEventsJSON), nil
+	case EnvelopeCloudEventsBinary:
+		return string(ce.Data), ce.BinaryAttributes(), nil
+	default:
+		return "", nil, fmt.Errorf("unknown envelope %d", s.envelope)
+	}
+}
@@ -0,0 +1,37 @@
+package pub
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"github.com/Iknite-Space/psss/compression"
+	"github.com/Iknite-Space/psss/models"
+)
+
+// WithCompression compresses the serialized envelope of events whose body is at least threshold bytes long.
+// The algorithm is declared by the content_encoding message attribute and the sub processors decompress the
+// message transparently. Bodies that don't shrink once compressed and base64 encoded are sent uncompressed.
+func (s *SNSPublisher[T]) WithCompression(algorithm compression.Algorithm, threshold int) *SNSPublisher[T] {
+	s.compression = algorithm
+	s.compressionThreshold = threshold
+	return s
+}
+
+// compress compresses body if the publisher is configured to, adding the content encoding to attributes.
+func (s *SNSPublisher[T]) compress(body string, attributes map[string]string) (string, error) {
+	if s.compression == compression.None || len(body) < s.compressionThreshold {
+		return body, nil
+	}
+
+	compressed, err := compression.Compress(s.compression, []byte(body))
+	if err != nil {
+		return "", fmt.Errorf("failed to compress message: %w", err)
+	}
+
+	if base64.StdEncoding.EncodedLen(len(compressed)) >= len(body) {
+		return body, nil
+	}
+
+	attributes[models.AttributeContentEncoding] = string(s.compression)
+	return base64.StdEncoding.EncodeToString(compressed), nil
+}
@@ -0,0 +1,224 @@
+package pub
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/Iknite-Space/psss/models"
+	"google.golang.org/protobuf/proto"
+)
+
+// Encoding selects how the SNSPublisher serializes mutation events.
+type Encoding uint8
+
+const (
+	// EncodingJSON publishes a JSON envelope with Before and After embedded as protojson (models.ContentTypeJSON).
+	EncodingJSON Encoding = iota
+
+	// EncodingProtobufPayload publishes a JSON envelope with Before and After in protobuf wire format, base64
+	// encoded (models.ContentTypeProtobufPayloadJSON).
+	EncodingProtobufPayload
+
+	// EncodingProtobuf publishes the whole envelope in protobuf wire format, base64 encoded
+	// (models.ContentTypeProtobuf). This is the most compact encoding.
+	EncodingProtobuf
+)
+
+// WithEncoding sets the encoding of published events. Events are published as EncodingJSON by default.
+// Consumers detect the encoding of each message from its content_type attribute, so producers of a topic can be
+// migrated one at a time once all consumers are upgraded.
+func (s *SNSPublisher[T]) WithEncoding(encoding Encoding) *SNSPublisher[T] {
+	s.encoding = encoding
+	return s
+}
+
+// payloads holds the serialized Before and After of an event, which are nil when not set. When the publisher
+// encrypts events, they hold the ciphertexts and encryption describes how to decrypt them.
+type payloads struct {
+	before     []byte
+	after      []byte
+	encryption *models.EncryptionInfo
+}
+
+// encode serializes the event using the publisher's envelope and encoding. It returns the message body and its
+// message attributes. The content type attribute is omitted for the legacy base64 payloads since older consumers
+// don't know about content types.
+func (s *SNSPublisher[T]) encode(ctx context.Context, e models.ProtoMutationEvent[T]) (string, map[string]string, error) {
+	if s.legacyBase64Payloads && s.keyProvider != nil {
+		return "", nil, errors.New("legacy base64 payloads can't be encrypted")
+	}
+
+	if s.envelope != EnvelopeNative {
+		return s.encodeCloudEvent(ctx, e)
+	}
+
+	switch s.encoding {
+	case EncodingJSON:
+		b, err := s.marshalProtoMutationEventToJSON(ctx, e)
+		if err != nil {
+			return "", nil, err
+		}
+		if s.legacyBase64Payloads {
+			return string(b), map[string]string{}, nil
+		}
+		return string(b), contentTypeAttributes(models.ContentTypeJSON), nil
+	case EncodingProtobufPayload:
+		b, err := s.marshalProtoMutationEventToProtobufPayloadJSON(ctx, e)
+		if err != nil {
+			return "", nil, err
+		}
+		return string(b), contentTypeAttributes(models.ContentTypeProtobufPayloadJSON), nil
+	case EncodingProtobuf:
+		b, err := s.marshalProtoMutationEventToProtobuf(ctx, e)
+		if err != nil {
+			return "", nil, err
+		}
+		return base64.StdEncoding.EncodeToString(b), contentTypeAttributes(models.ContentTypeProtobuf), nil
+	default:
+		return "", nil, fmt.Errorf("unknown encoding %d", s.encoding)
+	}
+}
+
+func contentTypeAttributes(contentType string) map[string]string {
+	return map[string]string{models.AttributeContentType: contentType}
+}
+
+// marshalProtoMutationEventToJSON marshals a ProtoMutationEvent with proto.Message fields to JSON.
+func (s *SNSPublisher[T]) marshalProtoMutationEventToJSON(ctx context.Context, e models.ProtoMutationEvent[T]) ([]byte, error) {
+	payload, err := s.newJSONEnvelope(ctx, e)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(payload)
+}
+
+// newJSONEnvelope builds the envelope written by marshalProtoMutationEventToJSON. Before and After are embedded
+// as nested protojson objects, unless they are encrypted or the publisher writes legacy payloads, in which case
+// they are base64 strings.
+func (s *SNSPublisher[T]) newJSONEnvelope(ctx context.Context, e models.ProtoMutationEvent[T]) (models.PublishedProtoMutationEvent, error) {
+	p, err := s.marshalPayloads(ctx, e, false)
+	if err != nil {
+		return models.PublishedProtoMutationEvent{}, err
+	}
+
+	// actual struct for JSON encoding for top level fields using json package.
+	payload := s.newPublishedProtoMutationEvent(e)
+	payload.Encryption = p.encryption
+
+	if p.encryption == nil && !s.legacyBase64Payloads {
+		payload.Before = p.before
+		payload.After = p.after
+		return payload, nil
+	}
+
+	payload.Before, payload.After, err = base64Payloads(p)
+	if err != nil {
+		return models.PublishedProtoMutationEvent{}, err
+	}
+
+	return payload, nil
+}
+
+// marshalProtoMutationEventToProtobufPayloadJSON marshals a ProtoMutationEvent to a JSON envelope with Before and
+// After encoded as base64 strings of the protobuf wire format.
+func (s *SNSPublisher[T]) marshalProtoMutationEventToProtobufPayloadJSON(ctx context.Context, e models.ProtoMutationEvent[T]) ([]byte, error) {
+	p, err := s.marshalPayloads(ctx, e, true)
+	if err != nil {
+		return nil, err
+	}
+
+	payload := s.newPublishedProtoMutationEvent(e)
+	payload.Encryption = p.encryption
+	payload.Before, payload.After, err = base64Payloads(p)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(payload)
+}
+
+// marshalProtoMutationEventToProtobuf marshals a ProtoMutationEvent to the protobuf envelope described by
+// models.MarshalProtoEnvelope.
+func (s *SNSPublisher[T]) marshalProtoMutationEventToProtobuf(ctx context.Context, e models.ProtoMutationEvent[T]) ([]byte, error) {
+	p, err := s.marshalPayloads(ctx, e, true)
+	if err != nil {
+		return nil, err
+	}
+
+	payload := s.newPublishedProtoMutationEvent(e)
+	payload.Encryption = p.encryption
+
+	return models.MarshalProtoEnvelope(payload, p.before, p.after)
+}
+
+// marshalPayloads marshals Before and After to protojson, or to protobuf wire format if binary is true, and
+// encrypts them if the publisher has a KeyProvider.
+func (s *SNSPublisher[T]) marshalPayloads(ctx context.Context, e models.ProtoMutationEvent[T], binary bool) (payloads, error) {
+	marshal := func(m proto.Message) ([]byte, error) {
+		if binary {
+			return marshalProtoBinaryField(m)
+		}
+		return marshalProtoField(m, s.marshalOptions)
+	}
+
+	before, err := marshal(e.Before)
+	if err != nil {
+		return payloads{}, fmt.Errorf("marshaling 'Before': %w", err)
+	}
+
+	after, err := marshal(e.After)
+	if err != nil {
+		return payloads{}, fmt.Errorf("marshaling 'After': %w", err)
+	}
+
+	p := payloads{before: before, after: after}
+	if s.keyProvider == nil {
+		return p, nil
+	}
+
+	return encryptPayloads(ctx, s.keyProvider, e.EventID, p)
+}
+
+// base64Payloads encodes the payloads as JSON base64 strings.
+func base64Payloads(p payloads) (json.RawMessage, json.RawMessage, error) {
+	var before, after json.RawMessage
+	var err error
+
+	// encoding/json writes []byte as a base64 string.
+	if p.before != nil {
+		before, err = json.Marshal(p.before)
+		if err != nil {
+			return nil, nil, fmt.Errorf("marshaling 'Before': %w", err)
+		}
+	}
+	if p.after != nil {
+		after, err = json.Marshal(p.after)
+		if err != nil {
+			return nil, nil, fmt.Errorf("marshaling 'After': %w", err)
+		}
+	}
+
+	return before, after, nil
+}
+
+func marshalProtoBinaryField(m proto.Message) ([]byte, error) {
+	if m == nil || !m.ProtoReflect().IsValid() {
+		return nil, nil
+	}
+
+	b, err := proto.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+
+	// an empty message marshals to nil, keep it distinguishable from an unset field.
+	if b == nil {
+		b = []byte{}
+	}
+
+	return b, nil
+}
@@ -0,0 +1,49 @@
+package pub
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Iknite-Space/psss/encryption"
+	"github.com/Iknite-Space/psss/models"
+)
+
+// WithEncryption encrypts Before and After of every event with a fresh data key generated by keyProvider. The data
+// key is sent in the envelope, wrapped by the provider's master key, and the sub processors decrypt the payloads
+// when they are configured with a KeyProvider for the same master keys. The rest of the envelope is not encrypted.
+func (s *SNSPublisher[T]) WithEncryption(keyProvider encryption.KeyProvider) *SNSPublisher[T] {
+	s.keyProvider = keyProvider
+	return s
+}
+
+// encryptPayloads encrypts the payloads of the event with a new data key.
+func encryptPayloads(ctx context.Context, keyProvider encryption.KeyProvider, eventID string, p payloads) (payloads, error) {
+	dataKey, err := keyProvider.GenerateDataKey(ctx)
+	if err != nil {
+		return payloads{}, fmt.Errorf("failed to generate data key: %w", err)
+	}
+
+	encrypted := payloads{
+		encryption: &models.EncryptionInfo{
+			Algorithm:  encryption.AlgorithmAES256GCM,
+			KeyID:      dataKey.KeyID,
+			WrappedKey: dataKey.Wrapped,
+		},
+	}
+
+	if p.before != nil {
+		encrypted.before, err = encryption.Encrypt(dataKey.Plaintext, p.before, encryption.PayloadAdditionalData(eventID, "before"))
+		if err != nil {
+			return payloads{}, fmt.Errorf("encrypting 'Before': %w", err)
+		}
+	}
+
+	if p.after != nil {
+		encrypted.after, err = encryption.Encrypt(dataKey.Plaintext, p.after, encryption.PayloadAdditionalData(eventID, "after"))
+		if err != nil {
+			return payloads{}, fmt.Errorf("encrypting 'After': %w", err)
+		}
+	}
+
+	return encrypted, nil
+}
@@ -0,0 +1,137 @@
+package pub
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// PublishStatus is a snapshot of the outcome of a publisher's recent SNS publish calls.
+type PublishStatus struct {
+	// LastSuccess is the time of the last successful publish.
+	LastSuccess time.Time `json:"last_success,omitzero"`
+
+	// LastFailure is the time of the last failed publish.
+	LastFailure time.Time `json:"last_failure,omitzero"`
+
+	// LastError is the error of the last failed publish.
+	LastError string `json:"last_error,omitempty"`
+
+	// ConsecutiveFailures is the number of publishes that failed since the last successful one.
+	ConsecutiveFailures int `json:"consecutive_failures"`
+}
+
+// StatusReporter is implemented by publishers tracking the outcome of their publish calls, such as SNSPublisher.
+type StatusReporter interface {
+	PublishStatus() PublishStatus
+}
+
+// publishTracker records the outcome of publish calls. Events that fail to be encoded or validated are not
+// recorded since they say nothing about the health of the publisher.
+type publishTracker struct {
+	mu     sync.Mutex
+	status PublishStatus
+}
+
+func (t *publishTracker) record(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err == nil {
+		t.status.LastSuccess = time.Now()
+		t.status.ConsecutiveFailures = 0
+		return
+	}
+	t.status.LastFailure = time.Now()
+	t.status.LastError = err.Error()
+	t.status.ConsecutiveFailures++
+}
+
+func (t *publishTracker) snapshot() PublishStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.status
+}
+
+// PublishStatus returns the outcome of the publisher's recent publish calls.
+func (s *SNSPublisher[T]) PublishStatus() PublishStatus {
+	return s.tracker.snapshot()
+}
+
+// PublisherHealth is the health of a publisher as reported by HealthHandler.
+type PublisherHealth struct {
+	PublishStatus
+
+	Name    string `json:"name"`
+	Healthy bool   `json:"healthy"`
+}
+
+// HealthReport is the JSON body written by HealthHandler.
+type HealthReport struct {
+	Healthy    bool              `json:"healthy"`
+	Publishers []PublisherHealth `json:"publishers"`
+}
+
+// HealthHandler is an http.Handler reporting the health of publishers, e.g. for Kubernetes readiness probes.
+// A publisher is unhealthy once its last publishes failed more than a configurable number of times in a row.
+// The handler responds with 200 OK when all publishers are healthy and 503 Service Unavailable otherwise, along
+// with a JSON HealthReport.
+type HealthHandler struct {
+	publishers             []namedPublisher
+	maxConsecutiveFailures int
+}
+
+type namedPublisher struct {
+	name      string
+	publisher StatusReporter
+}
+
+var _ http.Handler = (*HealthHandler)(nil)
+
+// NewHealthHandler creates a HealthHandler without publishers.
+func NewHealthHandler() *HealthHandler {
+	return &HealthHandler{maxConsecutiveFailures: 1}
+}
+
+// WithPublisher adds a publisher to the report.
+func (h *HealthHandler) WithPublisher(name string, publisher StatusReporter) *HealthHandler {
+	h.publishers = append(h.publishers, namedPublisher{name: name, publisher: publisher})
+	return h
+}
+
+// WithMaxConsecutiveFailures sets the number of consecutive failed publishes after which a publisher is reported
+// unhealthy. Defaults to 1, i.e. a publisher is unhealthy as long as its last publish failed.
+func (h *HealthHandler) WithMaxConsecutiveFailures(n int) *HealthHandler {
+	h.maxConsecutiveFailures = n
+	return h
+}
+
+// Report returns the health of all publishers.
+func (h *HealthHandler) Report() HealthReport {
+	report := HealthReport{Healthy: true, Publishers: make([]PublisherHealth, 0, len(h.publishers))}
+	for _, p := range h.publishers {
+		status := p.publisher.PublishStatus()
+		healthy := status.ConsecutiveFailures < h.maxConsecutiveFailures
+		report.Healthy = report.Healthy && healthy
+		report.Publishers = append(report.Publishers, PublisherHealth{
+			PublishStatus: status,
+			Name:          p.name,
+			Healthy:       healthy,
+		})
+	}
+	return report
+}
+
+// ServeHTTP implements http.Handler.
+func (h *HealthHandler) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	report := h.Report()
+
+	status := http.StatusOK
+	if !report.Healthy {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(report)
+}
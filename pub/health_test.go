@@ -0,0 +1,108 @@
+package pub
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// trackedPublisher is a StatusReporter recording the outcome of publishes like SNSPublisher does.
+type trackedPublisher struct {
+	tracker publishTracker
+}
+
+func (p *trackedPublisher) PublishStatus() PublishStatus {
+	return p.tracker.snapshot()
+}
+
+func TestPublishTracker(t *testing.T) {
+	var tracker publishTracker
+
+	tracker.record(errors.New("throttled"))
+	tracker.record(errors.New("timeout"))
+	status := tracker.snapshot()
+	if status.ConsecutiveFailures != 2 || status.LastError != "timeout" || status.LastFailure.IsZero() {
+		t.Errorf("status after 2 failures = %+v, want 2 consecutive failures and the last error", status)
+	}
+	if !status.LastSuccess.IsZero() {
+		t.Errorf("LastSuccess = %v, want it unset", status.LastSuccess)
+	}
+
+	// a success resets the failure count but keeps the last error.
+	tracker.record(nil)
+	status = tracker.snapshot()
+	if status.ConsecutiveFailures != 0 || status.LastSuccess.IsZero() || status.LastError != "timeout" {
+		t.Errorf("status after a success = %+v, want no consecutive failures and the last error", status)
+	}
+}
+
+func TestHealthHandler(t *testing.T) {
+	serve := func(handler *HealthHandler) (int, HealthReport) {
+		t.Helper()
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+		if contentType := w.Header().Get("Content-Type"); contentType != "application/json" {
+			t.Errorf("Content-Type = %q, want application/json", contentType)
+		}
+		var report HealthReport
+		err := json.Unmarshal(w.Body.Bytes(), &report)
+		if err != nil {
+			t.Fatalf("invalid body %s: %v", w.Body, err)
+		}
+		return w.Code, report
+	}
+
+	notes, folders := &trackedPublisher{}, &trackedPublisher{}
+	handler := NewHealthHandler().WithPublisher("notes", notes).WithPublisher("folders", folders)
+
+	// publishers that didn't publish yet are healthy.
+	code, report := serve(handler)
+	if code != http.StatusOK || !report.Healthy || len(report.Publishers) != 2 {
+		t.Errorf("idle publishers = %d %+v, want 200 and healthy", code, report)
+	}
+
+	notes.tracker.record(nil)
+	folders.tracker.record(errors.New("throttled"))
+	code, report = serve(handler)
+	if code != http.StatusServiceUnavailable || report.Healthy {
+		t.Errorf("failed publish = %d, healthy %v, want 503 and unhealthy", code, report.Healthy)
+	}
+	if len(report.Publishers) != 2 {
+		t.Fatalf("report = %+v, want 2 publishers", report.Publishers)
+	}
+	if p := report.Publishers[0]; p.Name != "notes" || !p.Healthy || p.LastSuccess.IsZero() {
+		t.Errorf("notes = %+v, want healthy with a last success", p)
+	}
+	if p := report.Publishers[1]; p.Name != "folders" || p.Healthy || p.LastError != "throttled" || p.ConsecutiveFailures != 1 {
+		t.Errorf("folders = %+v, want unhealthy after 1 failure", p)
+	}
+
+	// the publisher recovers with its next successful publish.
+	folders.tracker.record(nil)
+	code, report = serve(handler)
+	if code != http.StatusOK || !report.Healthy {
+		t.Errorf("recovered publisher = %d %+v, want 200 and healthy", code, report)
+	}
+}
+
+func TestHealthHandlerMaxConsecutiveFailures(t *testing.T) {
+	publisher := &trackedPublisher{}
+	handler := NewHealthHandler().WithPublisher("notes", publisher).WithMaxConsecutiveFailures(3)
+
+	for failures := 1; failures <= 3; failures++ {
+		publisher.tracker.record(errors.New("throttled"))
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+		want := http.StatusOK
+		if failures == 3 {
+			want = http.StatusServiceUnavailable
+		}
+		if w.Code != want {
+			t.Errorf("status after %d failures = %d, want %d", failures, w.Code, want)
+		}
+	}
+}
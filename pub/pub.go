@@ -2,16 +2,20 @@ package pub
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"time"
 
+	"github.com/Iknite-Space/psss/compression"
+	"github.com/Iknite-Space/psss/encryption"
 	"github.com/Iknite-Space/psss/models"
+	"github.com/Iknite-Space/psss/signing"
 	"github.com/rs/zerolog"
 	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/proto"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sns/types"
 )
 
 // Publisher defines the interface for publishing messages
@@ -22,11 +26,28 @@ type Publisher[T proto.Message] interface {
 type SNSPublisher[T proto.Message] struct {
 	SnsClient *sns.Client
 	topicArn  string
+	source    string
 	logger    zerolog.Logger
+
+	envelope             Envelope
+	encoding             Encoding
+	marshalOptions       protojson.MarshalOptions
+	legacyBase64Payloads bool
+	keyProvider          encryption.KeyProvider
+	signer               *signing.Signer
+	compression          compression.Algorithm
+	compressionThreshold int
+	recordProtoType      bool
+
+	tracker publishTracker
 }
 
 var _ Publisher[proto.Message] = (*SNSPublisher[proto.Message])(nil)
 
+// maxMessageAttributes is the number of message attributes SNS delivers to SQS with raw message delivery. SNS drops
+// the messages with more attributes instead of delivering them.
+const maxMessageAttributes = 10
+
 func NewPubService[T proto.Message](SnsClient *sns.Client, topicArn string) *SNSPublisher[T] {
 	return &SNSPublisher[T]{
 		SnsClient: SnsClient,
@@ -41,20 +62,66 @@ func (s *SNSPublisher[T]) WithLogger(logger zerolog.Logger) *SNSPublisher[T] {
 	return s
 }
 
-// marshalProtoMutationEventToJSON marshals a ProtoMutationEvent with proto.Message fields to JSON.
-func marshalProtoMutationEventToJSON[T proto.Message](e models.ProtoMutationEvent[T]) ([]byte, error) {
-	beforeBytes, err := protojson.Marshal(e.Before)
-	if err != nil {
-		return nil, fmt.Errorf("marshaling 'Before': %w", err)
+// WithSource sets the Source used for events that are published without one.
+func (s *SNSPublisher[T]) WithSource(source string) *SNSPublisher[T] {
+	s.source = source
+	return s
+}
+
+// WithMarshalOptions sets the protojson options used to marshal Before and After, e.g. UseProtoNames,
+// EmitUnpopulated or a custom Resolver for google.protobuf.Any fields. The zero value is used by default.
+func (s *SNSPublisher[T]) WithMarshalOptions(opts protojson.MarshalOptions) *SNSPublisher[T] {
+	s.marshalOptions = opts
+	return s
+}
+
+// WithLegacyBase64Payloads makes the publisher encode Before and After as base64 strings, the format understood by
+// consumers built before nested JSON payloads were introduced. Use it until all consumers of the topic are upgraded.
+func (s *SNSPublisher[T]) WithLegacyBase64Payloads() *SNSPublisher[T] {
+	s.legacyBase64Payloads = true
+	return s
+}
+
+// WithProtoType makes the publisher record the full name of the proto message type of Before and After in the
+// envelope, so that consumers can decode its events without knowing their type in advance, see sub.WithTypeRegistry.
+func (s *SNSPublisher[T]) WithProtoType() *SNSPublisher[T] {
+	s.recordProtoType = true
+	return s
+}
+
+// applyDefaults fills the envelope fields that can be derived by the publisher: a UUIDv7 EventID, the current
+// EventTime and the publisher's Source.
+func (s *SNSPublisher[T]) applyDefaults(message *models.ProtoMutationEvent[T]) error {
+	now := time.Now().UTC()
+
+	if message.EventID == "" {
+		eventID, err := newUUIDv7(now)
+		if err != nil {
+			return fmt.Errorf("generating event id: %w", err)
+		}
+		message.EventID = eventID
 	}
 
-	afterBytes, err := protojson.Marshal(e.After)
-	if err != nil {
-		return nil, fmt.Errorf("marshaling 'After': %w", err)
+	if message.EventTime.IsZero() {
+		message.EventTime = now
 	}
 
-	// actual struct for JSON encoding for top level fields using json package.
-	payload := models.PublishedProtoMutationEvent{
+	if message.Source == "" {
+		message.Source = s.source
+	}
+
+	return nil
+}
+
+// newPublishedProtoMutationEvent copies the top level fields of e into a PublishedProtoMutationEvent.
+// Before and After are left unset because their encoding depends on the publisher's Encoding.
+func (s *SNSPublisher[T]) newPublishedProtoMutationEvent(e models.ProtoMutationEvent[T]) models.PublishedProtoMutationEvent {
+	var protoType string
+	if s.recordProtoType {
+		protoType = protoTypeName(e.After, e.Before)
+	}
+
+	return models.PublishedProtoMutationEvent{
 		EventID:       e.EventID,
 		EventType:     e.EventType,
 		EventTime:     e.EventTime,
@@ -62,39 +129,121 @@ func marshalProtoMutationEventToJSON[T proto.Message](e models.ProtoMutationEven
 		CorrelationID: e.CorrelationID,
 		ResourceType:  e.ResourceType,
 		ResourceID:    e.ResourceID,
+		Sequence:      e.Sequence,
 		UserID:        e.UserID,
 		Reason:        e.Reason,
-		Before:        beforeBytes,
-		After:         afterBytes,
 		MetaData:      e.MetaData,
+		ProtoType:     protoType,
 	}
+}
 
-	return json.Marshal(payload)
+// protoTypeName returns the full name of the type of the first message that is not a nil interface. Typed nil
+// pointers of generated messages have a type.
+func protoTypeName(messages ...proto.Message) string {
+	for _, m := range messages {
+		if m != nil {
+			return string(m.ProtoReflect().Descriptor().FullName())
+		}
+	}
+	return ""
+}
+
+// marshalProtoField marshals m to protojson, returning nil if m is not set.
+func marshalProtoField(m proto.Message, opts protojson.MarshalOptions) ([]byte, error) {
+	if m == nil || !m.ProtoReflect().IsValid() {
+		return nil, nil
+	}
+
+	return opts.Marshal(m)
 }
 
 // Publish publishes messages to a specified message broker.
+// Missing EventID, EventTime and Source are filled in before the event is validated. If the event is invalid,
+// the returned error wraps a *models.ValidationError listing the violated rules and nothing is published.
 func (s *SNSPublisher[T]) Publish(ctx context.Context, message models.ProtoMutationEvent[T]) error {
-	eventBytes, err := marshalProtoMutationEventToJSON(message)
+	err := s.applyDefaults(&message)
+	if err != nil {
+		return fmt.Errorf("failed to apply mutation event defaults: %w", err)
+	}
+
+	err = message.Validate()
+	if err != nil {
+		return fmt.Errorf("failed to validate mutation event: %w", err)
+	}
+
+	body, attributes, err := s.encode(ctx, message)
 	if err != nil {
 		return fmt.Errorf("failed to marshal mutation event: %w", err)
 	}
 
 	s.logger.Debug().
-		RawJSON("sns_message", eventBytes).
+		Str("sns_message", body).Str("content_type", attributes[models.AttributeContentType]).
 		Str("correlation_id", message.CorrelationID).Msg("Publishing event to SNS")
 
-	// Publish to SNS
-	response, err := s.SnsClient.Publish(ctx, &sns.PublishInput{
-		TopicArn: aws.String(s.topicArn),
-		Message:  aws.String(string(eventBytes)),
-	})
+	messageID, err := s.publishEnvelope(ctx, body, attributes)
 	if err != nil {
-		return fmt.Errorf("failed to publish message to SNS: %w", err)
+		return err
 	}
 
 	s.logger.Info().
-		Str("message_id", *response.MessageId).Str("correlation_id", message.CorrelationID).
+		Str("message_id", messageID).Str("correlation_id", message.CorrelationID).
 		Msg("Message published to SNS successfully")
 
 	return nil
 }
+
+// PublishEnvelope publishes an already serialized envelope with its message attributes, e.g. an archived event
+// being replayed. Like the envelopes of Publish, it is signed and compressed if the publisher is configured to, and
+// attributes is updated accordingly.
+func (s *SNSPublisher[T]) PublishEnvelope(ctx context.Context, body string, attributes map[string]string) error {
+	messageID, err := s.publishEnvelope(ctx, body, attributes)
+	if err != nil {
+		return err
+	}
+
+	s.logger.Info().Str("message_id", messageID).Msg("Message published to SNS successfully")
+	return nil
+}
+
+// publishEnvelope signs, compresses and publishes a serialized envelope, and returns its SNS message ID. It fails
+// without publishing if the envelope ends up with more message attributes than SNS can deliver.
+func (s *SNSPublisher[T]) publishEnvelope(ctx context.Context, body string, attributes map[string]string) (string, error) {
+	if s.signer != nil {
+		s.signer.Sign(body, attributes)
+	}
+
+	body, err := s.compress(body, attributes)
+	if err != nil {
+		return "", err
+	}
+
+	if len(attributes) > maxMessageAttributes {
+		return "", fmt.Errorf("message has %d attributes, more than the %d SNS delivers with raw message delivery",
+			len(attributes), maxMessageAttributes)
+	}
+
+	// Publish to SNS
+	response, err := s.SnsClient.Publish(ctx, &sns.PublishInput{
+		TopicArn:          aws.String(s.topicArn),
+		Message:           aws.String(body),
+		MessageAttributes: snsMessageAttributes(attributes),
+	})
+	s.tracker.record(err)
+	if err != nil {
+		return "", fmt.Errorf("failed to publish message to SNS: %w", err)
+	}
+
+	return aws.ToString(response.MessageId), nil
+}
+
+// snsMessageAttributes converts string message attributes to SNS message attributes.
+func snsMessageAttributes(attributes map[string]string) map[string]types.MessageAttributeValue {
+	snsAttributes := make(map[string]types.MessageAttributeValue, len(attributes))
+	for name, value := range attributes {
+		snsAttributes[name] = types.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(value),
+		}
+	}
+	return snsAttributes
+}
@@ -0,0 +1,13 @@
+package pub
+
+import (
+	"github.com/Iknite-Space/psss/signing"
+)
+
+// WithSigner signs every published envelope with the signer's key. The signature covers the message body and
+// attributes before compression, and the sub processors configured with a matching signing.Verifier reject
+// messages that are unsigned or were tampered with.
+func (s *SNSPublisher[T]) WithSigner(signer *signing.Signer) *SNSPublisher[T] {
+	s.signer = signer
+	return s
+}
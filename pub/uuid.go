@@ -0,0 +1,40 @@
+package pub
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// newUUIDv7 generates a time ordered UUID as described in RFC 9562, section 5.7.
+func newUUIDv7(now time.Time) (string, error) {
+	var u [16]byte
+
+	_, err := rand.Read(u[6:])
+	if err != nil {
+		return "", fmt.Errorf("failed to read random bytes: %w", err)
+	}
+
+	// 48 bit big-endian unix timestamp in milliseconds.
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], uint64(now.UnixMilli()))
+	copy(u[:6], ts[2:])
+
+	u[6] = (u[6] & 0x0f) | 0x70 // version 7
+	u[8] = (u[8] & 0x3f) | 0x80 // RFC 9562 variant
+
+	var buf [36]byte
+	hex.Encode(buf[0:8], u[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], u[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], u[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], u[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], u[10:])
+
+	return string(buf[:]), nil
+}
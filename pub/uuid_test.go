@@ -0,0 +1,74 @@
+package pub
+
+import (
+	"encoding/hex"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// uuidBytes returns the 16 bytes of the UUID u.
+func uuidBytes(t *testing.T, u string) []byte {
+	t.Helper()
+
+	b, err := hex.DecodeString(strings.ReplaceAll(u, "-", ""))
+	if err != nil || len(b) != 16 {
+		t.Fatalf("invalid UUID %q", u)
+	}
+	return b
+}
+
+func TestNewUUIDv7(t *testing.T) {
+	now := time.Date(2025, 1, 2, 3, 4, 5, 678_000_000, time.UTC)
+
+	seen := make(map[string]bool)
+	for range 100 {
+		u, err := newUUIDv7(now)
+		if err != nil {
+			t.Fatalf("newUUIDv7() = %v", err)
+		}
+		if !uuidPattern.MatchString(u) {
+			t.Fatalf("newUUIDv7() = %q, not in the canonical format", u)
+		}
+		if seen[u] {
+			t.Fatalf("newUUIDv7() returned %q twice", u)
+		}
+		seen[u] = true
+
+		b := uuidBytes(t, u)
+		if version := b[6] >> 4; version != 7 {
+			t.Errorf("version of %s = %d, want 7", u, version)
+		}
+		if variant := b[8] >> 6; variant != 0b10 {
+			t.Errorf("variant of %s = %b, want 10", u, variant)
+		}
+
+		var millis int64
+		for _, c := range b[:6] {
+			millis = millis<<8 | int64(c)
+		}
+		if millis != now.UnixMilli() {
+			t.Errorf("timestamp of %s = %d, want %d", u, millis, now.UnixMilli())
+		}
+	}
+}
+
+func TestNewUUIDv7TimeOrdered(t *testing.T) {
+	start := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	// UUIDs generated in later milliseconds sort after the earlier ones, as strings too.
+	var previous string
+	for i := range 50 {
+		u, err := newUUIDv7(start.Add(time.Duration(i) * time.Millisecond))
+		if err != nil {
+			t.Fatalf("newUUIDv7() = %v", err)
+		}
+		if u <= previous {
+			t.Errorf("newUUIDv7() = %s after %s, want a greater UUID", u, previous)
+		}
+		previous = u
+	}
+}
@@ -0,0 +1,112 @@
+package reqreply
+
+import (
+	"context"
+	"sync"
+
+	"github.com/Iknite-Space/psss/sub"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/rs/zerolog"
+)
+
+// reply is a reply received for a request.
+type reply struct {
+	body       string
+	attributes map[string]string
+}
+
+// Demux receives the replies sent to a reply queue and routes them to the requests waiting for them. Replies to
+// requests that are no longer waiting, e.g. because they timed out, are logged and dropped.
+//
+// Run must be running for requests to receive their replies. The reply queue should only be used by a single
+// Demux, and its messages should have a short retention since replies are only useful to waiting requests.
+type Demux struct {
+	queueURL  string
+	processor *sub.SqsEventProcessor
+	logger    zerolog.Logger
+
+	mu      sync.Mutex
+	waiters map[string]chan reply
+}
+
+var _ sub.Runner = (*Demux)(nil)
+
+// NewDemux creates a Demux receiving the replies sent to the queue at replyQueueURL.
+func NewDemux(svc *sqs.Client, replyQueueURL string) *Demux {
+	d := &Demux{
+		queueURL: replyQueueURL,
+		logger:   zerolog.Nop(),
+		waiters:  make(map[string]chan reply),
+	}
+	d.processor = sub.NewSqsEventProcessor(svc, replyQueueURL, sub.StringHandlerToAutoDetectSqsHandler(d.handle))
+	return d
+}
+
+// WithLogger sets the logger for the Demux and its processor.
+func (d *Demux) WithLogger(logger zerolog.Logger) *Demux {
+	d.logger = logger
+	d.processor.WithLogger(logger)
+	return d
+}
+
+// QueueURL returns the URL of the reply queue.
+func (d *Demux) QueueURL() string {
+	return d.queueURL
+}
+
+// Processor returns the processor receiving the replies, e.g. to configure its concurrency or add it to a
+// sub.HealthHandler.
+func (d *Demux) Processor() *sub.SqsEventProcessor {
+	return d.processor
+}
+
+// Run receives replies until ctx is cancelled, see sub.SqsEventProcessor.Run.
+func (d *Demux) Run(ctx context.Context) error {
+	return d.processor.Run(ctx)
+}
+
+// register returns the channel the reply to the request with correlationID will be sent to. It reports false if a
+// request with the same correlation ID is already waiting.
+func (d *Demux) register(correlationID string) (<-chan reply, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.waiters[correlationID]; ok {
+		return nil, false
+	}
+	ch := make(chan reply, 1)
+	d.waiters[correlationID] = ch
+	return ch, true
+}
+
+// unregister stops waiting for the reply to the request with correlationID, unless it was already received.
+func (d *Demux) unregister(correlationID string, ch <-chan reply) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.waiters[correlationID] == ch {
+		delete(d.waiters, correlationID)
+	}
+}
+
+// handle routes a reply to the request waiting for it.
+func (d *Demux) handle(ctx context.Context, body string) error {
+	info, _ := sub.MessageInfoFromContext(ctx)
+	correlationID := info.MessageAttributes[AttributeCorrelationID]
+
+	d.mu.Lock()
+	ch, ok := d.waiters[correlationID]
+	if ok {
+		delete(d.waiters, correlationID)
+	}
+	d.mu.Unlock()
+
+	if !ok {
+		d.logger.Warn().Str("correlation_id", correlationID).Str("message_id", info.MessageID).
+			Msg("Dropping reply to a request that is not waiting")
+		return nil
+	}
+
+	ch <- reply{body: body, attributes: info.MessageAttributes}
+	return nil
+}
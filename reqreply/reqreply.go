@@ -0,0 +1,45 @@
+// Package reqreply implements request/reply messaging on top of mutation events.
+//
+// A Requester publishes a request event with a pub.Publisher, recording in its MetaData the URL of the queue the
+// reply must be sent to and the correlation ID identifying the request, then waits for the reply. On the other
+// side, ReplyingHandler wraps the handler of a subscriber, e.g. one created with sub.NewMutationEventSqsProcessor,
+// and sends what it returns to the reply queue. A Demux receives the replies of a reply queue with a
+// sub.SqsEventProcessor and routes them to the waiting requests by correlation ID, so it can be shared by every
+// Requester of a service.
+package reqreply
+
+import (
+	"errors"
+)
+
+const (
+	// MetaDataReplyTo is the MetaData key of a request event holding the URL of the SQS queue to reply to.
+	MetaDataReplyTo = "reply_to"
+
+	// AttributeCorrelationID is the message attribute of a reply holding the CorrelationID of its request.
+	AttributeCorrelationID = "correlation_id"
+
+	// AttributeReplyError is the message attribute of a reply holding the error the responder failed with. The
+	// body of such a reply is an empty JSON object, since SQS doesn't accept empty messages, and should be ignored.
+	AttributeReplyError = "reply_error"
+)
+
+// ErrTimeout is returned by Requester.Request when no reply is received in time.
+var ErrTimeout = errors.New("timed out waiting for reply")
+
+// ErrDuplicateCorrelationID is returned by Requester.Request when a request with the same CorrelationID is already
+// waiting for its reply on the same Demux.
+var ErrDuplicateCorrelationID = errors.New("a request with this correlation ID is already waiting for its reply")
+
+// ErrReplyQueueNotAllowed is returned by the handler created with ReplyingHandler for a request whose reply queue is
+// not allowed.
+var ErrReplyQueueNotAllowed = errors.New("reply queue is not allowed")
+
+// ReplyError is the error a responder failed to handle a request with.
+type ReplyError struct {
+	Message string
+}
+
+func (e *ReplyError) Error() string {
+	return "request failed: " + e.Message
+}
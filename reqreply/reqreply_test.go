@@ -0,0 +1,301 @@
+package reqreply
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Iknite-Space/psss/models"
+	"github.com/Iknite-Space/psss/sub"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	awstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/smithy-go/middleware"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+const replyQueueURL = "https://sqs.us-east-1.amazonaws.com/123456789012/replies"
+
+// newSqsClient returns an SQS client passing the messages it sends to send instead of sending them.
+func newSqsClient(send func(input *sqs.SendMessageInput)) *sqs.Client {
+	intercept := middleware.InitializeMiddlewareFunc("intercept", func(ctx context.Context, in middleware.InitializeInput, _ middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+		send(in.Parameters.(*sqs.SendMessageInput))
+		return middleware.InitializeOutput{Result: &sqs.SendMessageOutput{MessageId: aws.String("1")}}, middleware.Metadata{}, nil
+	})
+
+	return sqs.New(sqs.Options{
+		Region: "us-east-1",
+		APIOptions: []func(*middleware.Stack) error{
+			func(stack *middleware.Stack) error {
+				return stack.Initialize.Add(intercept, middleware.Before)
+			},
+		},
+	})
+}
+
+// deliver passes a reply sent by a responder to demux, as its processor would.
+func deliver(demux *Demux, input *sqs.SendMessageInput) error {
+	attributes := make(map[string]string, len(input.MessageAttributes))
+	for name, value := range input.MessageAttributes {
+		attributes[name] = aws.ToString(value.StringValue)
+	}
+	ctx := sub.WithMessageInfo(context.Background(), sub.MessageInfo{MessageID: "reply", MessageAttributes: attributes})
+	return demux.handle(ctx, aws.ToString(input.MessageBody))
+}
+
+// publisherFunc is a pub.Publisher calling a function.
+type publisherFunc func(ctx context.Context, event models.ProtoMutationEvent[*wrapperspb.StringValue]) error
+
+func (f publisherFunc) Publish(ctx context.Context, event models.ProtoMutationEvent[*wrapperspb.StringValue]) error {
+	return f(ctx, event)
+}
+
+// newStringValue creates the responses of the tests.
+func newStringValue() *wrapperspb.StringValue {
+	return &wrapperspb.StringValue{}
+}
+
+// newRequester returns a Requester whose requests are handled by handler, with its replies delivered to demux.
+func newRequester(t *testing.T, demux *Demux, handler ReplyHandlerFn[*wrapperspb.StringValue, *wrapperspb.StringValue]) *Requester[*wrapperspb.StringValue, *wrapperspb.StringValue] {
+	svc := newSqsClient(func(input *sqs.SendMessageInput) {
+		err := deliver(demux, input)
+		if err != nil {
+			t.Errorf("deliver() = %v", err)
+		}
+	})
+	responder := ReplyingHandler(svc, []string{replyQueueURL}, handler)
+
+	publisher := publisherFunc(func(_ context.Context, event models.ProtoMutationEvent[*wrapperspb.StringValue]) error {
+		// the request is handled asynchronously, as it would be by a subscriber.
+		go func() {
+			_ = responder(context.Background(), event)
+		}()
+		return nil
+	})
+	return NewRequester(publisher, demux, newStringValue).WithTimeout(time.Second)
+}
+
+func TestRequest(t *testing.T) {
+	demux := NewDemux(newSqsClient(nil), replyQueueURL)
+	requester := newRequester(t, demux, func(_ context.Context, request models.ProtoMutationEvent[*wrapperspb.StringValue]) (*wrapperspb.StringValue, error) {
+		return wrapperspb.String("hello " + request.After.GetValue()), nil
+	})
+
+	response, err := requester.Request(context.Background(), models.ProtoMutationEvent[*wrapperspb.StringValue]{
+		EventType:    models.EventTypeCreated,
+		ResourceType: "greeting",
+		ResourceID:   "1",
+		After:        wrapperspb.String("world"),
+	})
+	if err != nil {
+		t.Fatalf("Request() = %v", err)
+	}
+	if response.GetValue() != "hello world" {
+		t.Errorf("Request() = %q, want %q", response.GetValue(), "hello world")
+	}
+}
+
+func TestRequestErrorReply(t *testing.T) {
+	demux := NewDemux(newSqsClient(nil), replyQueueURL)
+	requester := newRequester(t, demux, func(context.Context, models.ProtoMutationEvent[*wrapperspb.StringValue]) (*wrapperspb.StringValue, error) {
+		return nil, sub.Permanent(errors.New("greeting not found"))
+	})
+
+	_, err := requester.Request(context.Background(), models.ProtoMutationEvent[*wrapperspb.StringValue]{
+		EventType:    models.EventTypeCreated,
+		ResourceType: "greeting",
+		ResourceID:   "1",
+		After:        wrapperspb.String("world"),
+	})
+	var replyErr *ReplyError
+	if !errors.As(err, &replyErr) {
+		t.Fatalf("Request() = %v, want a *ReplyError", err)
+	}
+	if replyErr.Message != "greeting not found" {
+		t.Errorf("ReplyError.Message = %q, want %q", replyErr.Message, "greeting not found")
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	demux := NewDemux(newSqsClient(nil), replyQueueURL)
+
+	var request models.ProtoMutationEvent[*wrapperspb.StringValue]
+	publisher := publisherFunc(func(_ context.Context, event models.ProtoMutationEvent[*wrapperspb.StringValue]) error {
+		request = event
+		return nil
+	})
+	requester := NewRequester(publisher, demux, newStringValue).WithTimeout(10 * time.Millisecond)
+
+	_, err := requester.Request(context.Background(), models.ProtoMutationEvent[*wrapperspb.StringValue]{
+		EventType:     models.EventTypeCreated,
+		ResourceType:  "greeting",
+		ResourceID:    "1",
+		CorrelationID: "request-1",
+	})
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("Request() = %v, want ErrTimeout", err)
+	}
+	if replyTo := request.MetaData[MetaDataReplyTo]; replyTo != replyQueueURL {
+		t.Errorf("request reply queue = %v, want %s", replyTo, replyQueueURL)
+	}
+
+	// a late reply is dropped.
+	err = deliver(demux, &sqs.SendMessageInput{
+		MessageBody:       aws.String(`"late"`),
+		MessageAttributes: map[string]awstypes.MessageAttributeValue{AttributeCorrelationID: stringAttribute("request-1")},
+	})
+	if err != nil {
+		t.Errorf("handling a late reply = %v", err)
+	}
+	if len(demux.waiters) != 0 {
+		t.Errorf("%d requests still waiting", len(demux.waiters))
+	}
+}
+
+func TestDemuxCorrelation(t *testing.T) {
+	demux := NewDemux(newSqsClient(nil), replyQueueURL)
+
+	// the requests are only replied to once both were published, in reverse order.
+	var mu sync.Mutex
+	var requests []models.ProtoMutationEvent[*wrapperspb.StringValue]
+	published := make(chan struct{})
+	publisher := publisherFunc(func(_ context.Context, event models.ProtoMutationEvent[*wrapperspb.StringValue]) error {
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, event)
+		if len(requests) == 2 {
+			close(published)
+		}
+		return nil
+	})
+	requester := NewRequester(publisher, demux, newStringValue).WithTimeout(time.Second)
+
+	responses := make(map[string]string)
+	var wg sync.WaitGroup
+	for _, correlationID := range []string{"request-1", "request-2"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			response, err := requester.Request(context.Background(), models.ProtoMutationEvent[*wrapperspb.StringValue]{
+				EventType:     models.EventTypeCreated,
+				ResourceType:  "greeting",
+				ResourceID:    "1",
+				CorrelationID: correlationID,
+			})
+			if err != nil {
+				t.Errorf("Request(%s) = %v", correlationID, err)
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			responses[correlationID] = response.GetValue()
+		}()
+	}
+
+	<-published
+	_, err := requester.Request(context.Background(), models.ProtoMutationEvent[*wrapperspb.StringValue]{
+		EventType:     models.EventTypeCreated,
+		ResourceType:  "greeting",
+		ResourceID:    "1",
+		CorrelationID: "request-1",
+	})
+	if !errors.Is(err, ErrDuplicateCorrelationID) {
+		t.Errorf("Request() with a waiting correlation ID = %v, want ErrDuplicateCorrelationID", err)
+	}
+
+	svc := newSqsClient(func(input *sqs.SendMessageInput) {
+		err := deliver(demux, input)
+		if err != nil {
+			t.Errorf("deliver() = %v", err)
+		}
+	})
+	responder := ReplyingHandler(svc, []string{replyQueueURL}, func(_ context.Context, request models.ProtoMutationEvent[*wrapperspb.StringValue]) (*wrapperspb.StringValue, error) {
+		return wrapperspb.String("reply to " + request.CorrelationID), nil
+	})
+	for i := len(requests) - 1; i >= 0; i-- {
+		err := responder(context.Background(), requests[i])
+		if err != nil {
+			t.Fatalf("responder() = %v", err)
+		}
+	}
+	wg.Wait()
+
+	for _, correlationID := range []string{"request-1", "request-2"} {
+		if got, want := responses[correlationID], "reply to "+correlationID; got != want {
+			t.Errorf("response to %s = %q, want %q", correlationID, got, want)
+		}
+	}
+}
+
+func TestReplyingHandler(t *testing.T) {
+	var sent []*sqs.SendMessageInput
+	svc := newSqsClient(func(input *sqs.SendMessageInput) {
+		sent = append(sent, input)
+	})
+
+	handled := 0
+	handlerErr := error(nil)
+	responder := ReplyingHandler(svc, []string{replyQueueURL}, func(context.Context, models.ProtoMutationEvent[*wrapperspb.StringValue]) (*wrapperspb.StringValue, error) {
+		handled++
+		return wrapperspb.String("reply"), handlerErr
+	})
+	request := func(replyTo string) models.ProtoMutationEvent[*wrapperspb.StringValue] {
+		event := models.ProtoMutationEvent[*wrapperspb.StringValue]{
+			EventType:     models.EventTypeCreated,
+			ResourceType:  "greeting",
+			ResourceID:    "1",
+			CorrelationID: "request-1",
+		}
+		if replyTo != "" {
+			event.MetaData = map[string]any{MetaDataReplyTo: replyTo}
+		}
+		return event
+	}
+
+	// a request to reply to another queue is rejected without being handled.
+	err := responder(context.Background(), request("https://sqs.us-east-1.amazonaws.com/210987654321/elsewhere"))
+	if !errors.Is(err, ErrReplyQueueNotAllowed) || !sub.IsPermanent(err) {
+		t.Errorf("responder() with another reply queue = %v, want a permanent ErrReplyQueueNotAllowed", err)
+	}
+	if handled != 0 || len(sent) != 0 {
+		t.Errorf("request with another reply queue was handled %d times and replied to %d times", handled, len(sent))
+	}
+
+	// an event without a reply queue is handled without replying.
+	err = responder(context.Background(), request(""))
+	if err != nil || handled != 1 || len(sent) != 0 {
+		t.Errorf("responder() without a reply queue = %v, handled %d times and replied to %d times", err, handled, len(sent))
+	}
+
+	// a temporary error is returned without replying, so that the request is retried.
+	handlerErr = errors.New("database unavailable")
+	err = responder(context.Background(), request(replyQueueURL))
+	if !errors.Is(err, handlerErr) || len(sent) != 0 {
+		t.Errorf("responder() failing temporarily = %v, replied to %d times", err, len(sent))
+	}
+
+	// a permanent error is replied with an empty JSON object.
+	handlerErr = sub.Permanent(errors.New("greeting not found"))
+	err = responder(context.Background(), request(replyQueueURL))
+	if !sub.IsPermanent(err) {
+		t.Errorf("responder() failing permanently = %v, want a permanent error", err)
+	}
+	if len(sent) != 1 {
+		t.Fatalf("responder() failing permanently replied %d times, want 1", len(sent))
+	}
+	reply := sent[0]
+	if aws.ToString(reply.QueueUrl) != replyQueueURL {
+		t.Errorf("reply sent to %s, want %s", aws.ToString(reply.QueueUrl), replyQueueURL)
+	}
+	if body := aws.ToString(reply.MessageBody); body != errorReplyBody {
+		t.Errorf("error reply body = %q, want %q", body, errorReplyBody)
+	}
+	if message := aws.ToString(reply.MessageAttributes[AttributeReplyError].StringValue); message != "greeting not found" {
+		t.Errorf("error reply message = %q, want %q", message, "greeting not found")
+	}
+	if correlationID := aws.ToString(reply.MessageAttributes[AttributeCorrelationID].StringValue); correlationID != "request-1" {
+		t.Errorf("error reply correlation ID = %q, want request-1", correlationID)
+	}
+}
@@ -0,0 +1,117 @@
+package reqreply
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"time"
+
+	"github.com/Iknite-Space/psss/models"
+	"github.com/Iknite-Space/psss/pub"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const defaultRequestTimeout = 30 * time.Second
+
+// Requester publishes requests and waits for their replies, which are received by a Demux.
+type Requester[Req proto.Message, Resp proto.Message] struct {
+	publisher        pub.Publisher[Req]
+	demux            *Demux
+	newResponse      func() Resp
+	timeout          time.Duration
+	unmarshalOptions protojson.UnmarshalOptions
+}
+
+// NewRequester creates a Requester publishing requests with publisher and receiving their replies with demux.
+// newResponse creates the messages replies are unmarshaled into.
+func NewRequester[Req proto.Message, Resp proto.Message](publisher pub.Publisher[Req], demux *Demux, newResponse func() Resp) *Requester[Req, Resp] {
+	return &Requester[Req, Resp]{
+		publisher:        publisher,
+		demux:            demux,
+		newResponse:      newResponse,
+		timeout:          defaultRequestTimeout,
+		unmarshalOptions: protojson.UnmarshalOptions{DiscardUnknown: true},
+	}
+}
+
+// WithTimeout sets how long Request waits for a reply, including the time it takes to publish the request.
+// Defaults to 30s.
+func (r *Requester[Req, Resp]) WithTimeout(timeout time.Duration) *Requester[Req, Resp] {
+	r.timeout = timeout
+	return r
+}
+
+// Request publishes event as a request and waits for its reply. The CorrelationID of event identifies the request
+// and is generated if it is empty; it must not be shared by requests waiting at the same time. The reply queue is
+// recorded in the MetaData of event under MetaDataReplyTo.
+//
+// Request returns ErrTimeout if no reply is received in time, or a *ReplyError if the responder failed to handle
+// the request.
+func (r *Requester[Req, Resp]) Request(ctx context.Context, event models.ProtoMutationEvent[Req]) (Resp, error) {
+	var response Resp
+
+	if event.CorrelationID == "" {
+		correlationID, err := newCorrelationID()
+		if err != nil {
+			return response, err
+		}
+		event.CorrelationID = correlationID
+	}
+
+	metaData := make(map[string]any, len(event.MetaData)+1)
+	maps.Copy(metaData, event.MetaData)
+	metaData[MetaDataReplyTo] = r.demux.QueueURL()
+	event.MetaData = metaData
+
+	ch, ok := r.demux.register(event.CorrelationID)
+	if !ok {
+		return response, ErrDuplicateCorrelationID
+	}
+	defer r.demux.unregister(event.CorrelationID, ch)
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	err := r.publisher.Publish(timeoutCtx, event)
+	if err != nil {
+		return response, fmt.Errorf("failed to publish request: %w", err)
+	}
+
+	select {
+	case reply := <-ch:
+		return r.decodeReply(reply)
+	case <-timeoutCtx.Done():
+		if ctx.Err() != nil {
+			return response, ctx.Err()
+		}
+		return response, ErrTimeout
+	}
+}
+
+// decodeReply returns the response or the error carried by a reply.
+func (r *Requester[Req, Resp]) decodeReply(reply reply) (Resp, error) {
+	var zero Resp
+	if message, ok := reply.attributes[AttributeReplyError]; ok {
+		return zero, &ReplyError{Message: message}
+	}
+
+	response := r.newResponse()
+	err := r.unmarshalOptions.Unmarshal([]byte(reply.body), response)
+	if err != nil {
+		return zero, fmt.Errorf("failed to unmarshal reply: %w", err)
+	}
+	return response, nil
+}
+
+// newCorrelationID generates a random correlation ID.
+func newCorrelationID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate correlation ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
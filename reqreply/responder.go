@@ -0,0 +1,89 @@
+package reqreply
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/Iknite-Space/psss/models"
+	"github.com/Iknite-Space/psss/sub"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	awstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// ReplyHandlerFn handles a request and returns its reply.
+type ReplyHandlerFn[Req proto.Message, Resp proto.Message] func(ctx context.Context, request models.ProtoMutationEvent[Req]) (Resp, error)
+
+// ReplyingHandler wraps handler into a mutation event handler that sends what it returns to the reply queue of
+// the request, so that it can be used with sub.NewMutationEventSqsProcessor. Events without a reply queue are
+// handled without replying.
+//
+// Since anyone able to publish a request chooses its reply queue, replies are only sent to the queues whose URLs are
+// in replyQueueURLs. Requests asking for a reply to another queue are rejected with a permanent error, without
+// calling handler.
+//
+// When handler fails permanently (see sub.Permanent) the error is sent to the requester as a reply. Other errors
+// are returned without replying, so that the request is retried; the requester times out if the request is never
+// handled successfully. If the reply can't be sent the request is retried, so handler should be idempotent.
+func ReplyingHandler[Req proto.Message, Resp proto.Message](svc *sqs.Client, replyQueueURLs []string, handler ReplyHandlerFn[Req, Resp]) sub.ProtoMutationEventHandlerFn[Req] {
+	allowed := make(map[string]bool, len(replyQueueURLs))
+	for _, url := range replyQueueURLs {
+		allowed[url] = true
+	}
+
+	return func(ctx context.Context, request models.ProtoMutationEvent[Req]) error {
+		replyTo, _ := request.MetaData[MetaDataReplyTo].(string)
+		if replyTo != "" && !allowed[replyTo] {
+			return sub.Permanent(fmt.Errorf("%w: %s", ErrReplyQueueNotAllowed, replyTo))
+		}
+
+		response, err := handler(ctx, request)
+		if replyTo == "" || (err != nil && !sub.IsPermanent(err)) {
+			return err
+		}
+
+		attributes := map[string]awstypes.MessageAttributeValue{
+			AttributeCorrelationID: stringAttribute(request.CorrelationID),
+		}
+		body := errorReplyBody
+		if err != nil {
+			message := err.Error()
+			var permanent *sub.PermanentError
+			if errors.As(err, &permanent) {
+				message = permanent.Err.Error()
+			}
+			attributes[AttributeReplyError] = stringAttribute(message)
+		} else {
+			attributes[models.AttributeContentType] = stringAttribute(models.ContentTypeJSON)
+			b, marshalErr := protojson.Marshal(response)
+			if marshalErr != nil {
+				return fmt.Errorf("failed to marshal reply: %w", marshalErr)
+			}
+			body = string(b)
+		}
+
+		_, sendErr := svc.SendMessage(ctx, &sqs.SendMessageInput{
+			QueueUrl:          aws.String(replyTo),
+			MessageBody:       aws.String(body),
+			MessageAttributes: attributes,
+		})
+		if sendErr != nil {
+			return fmt.Errorf("failed to send reply: %w", sendErr)
+		}
+
+		return err
+	}
+}
+
+// errorReplyBody is the body of the replies carrying an error, as SQS doesn't accept empty messages.
+const errorReplyBody = "{}"
+
+func stringAttribute(value string) awstypes.MessageAttributeValue {
+	return awstypes.MessageAttributeValue{
+		DataType:    aws.String("String"),
+		StringValue: aws.String(value),
+	}
+}
@@ -0,0 +1,128 @@
+// Package signing implements the HMAC signatures of published envelopes shared by the pub and sub packages.
+// The signature covers the message body and its message attributes, and is sent in the AttributeSignature message
+// attribute along with the ID of the key in AttributeKeyID, so several keys can be active during a rotation.
+package signing
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/Iknite-Space/psss/models"
+)
+
+const (
+	// AttributeSignature is the message attribute holding the base64 encoded HMAC-SHA256 signature.
+	AttributeSignature = "signature"
+
+	// AttributeKeyID is the message attribute holding the ID of the key the message was signed with.
+	AttributeKeyID = "signature_key_id"
+
+	// canonicalPrefix versions the canonical form, so that it can evolve without ambiguity.
+	canonicalPrefix = "psss-hmac-sha256-v1"
+)
+
+var (
+	// ErrUnsigned is returned when a message has no signature.
+	ErrUnsigned = errors.New("message is not signed")
+
+	// ErrUnknownKey is returned when a message was signed with a key the verifier doesn't know.
+	ErrUnknownKey = errors.New("message is signed with an unknown key")
+
+	// ErrInvalidSignature is returned when the signature doesn't match the message.
+	ErrInvalidSignature = errors.New("message signature is invalid")
+)
+
+// unsignedAttributes are excluded from the signature: the signature attributes themselves, and the content
+// encoding since messages are compressed after they are signed.
+var unsignedAttributes = map[string]bool{
+	AttributeSignature:              true,
+	AttributeKeyID:                  true,
+	models.AttributeContentEncoding: true,
+}
+
+// Signer signs messages with a single key.
+type Signer struct {
+	keyID  string
+	secret []byte
+}
+
+// NewSigner creates a Signer using the given key. Secrets should be at least 32 random bytes.
+func NewSigner(keyID string, secret []byte) *Signer {
+	return &Signer{
+		keyID:  keyID,
+		secret: slices.Clone(secret),
+	}
+}
+
+// Sign signs the message body and attributes, and adds the signature and key ID to the attributes.
+func (s *Signer) Sign(body string, attributes map[string]string) {
+	attributes[AttributeSignature] = base64.StdEncoding.EncodeToString(mac(s.secret, body, attributes))
+	attributes[AttributeKeyID] = s.keyID
+}
+
+// Verifier verifies signed messages against a set of active keys.
+type Verifier struct {
+	keys map[string][]byte
+}
+
+// NewVerifier creates a Verifier accepting messages signed with any of the given keys, indexed by key ID.
+// To rotate keys, add the new key to the verifiers, then switch the signers to it, then remove the old key.
+func NewVerifier(keys map[string][]byte) *Verifier {
+	return &Verifier{
+		keys: maps.Clone(keys),
+	}
+}
+
+// Verify checks the signature of the message body and attributes. It returns an error wrapping ErrUnsigned,
+// ErrUnknownKey or ErrInvalidSignature if the message can't be trusted.
+func (v *Verifier) Verify(body string, attributes map[string]string) error {
+	signature, ok := attributes[AttributeSignature]
+	if !ok {
+		return ErrUnsigned
+	}
+
+	keyID := attributes[AttributeKeyID]
+	secret, ok := v.keys[keyID]
+	if !ok {
+		return fmt.Errorf("%w %q", ErrUnknownKey, keyID)
+	}
+
+	expected, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidSignature, err)
+	}
+
+	if !hmac.Equal(expected, mac(secret, body, attributes)) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+// mac computes the HMAC-SHA256 of the canonical form of a message, which is the version prefix, the signed
+// attributes sorted by name and the body, separated by newlines.
+func mac(secret []byte, body string, attributes map[string]string) []byte {
+	h := hmac.New(sha256.New, secret)
+
+	var canonical strings.Builder
+	canonical.WriteString(canonicalPrefix)
+	canonical.WriteByte('\n')
+	for _, name := range slices.Sorted(maps.Keys(attributes)) {
+		if unsignedAttributes[name] {
+			continue
+		}
+		// names can't contain '=' or newlines, values are quoted so they can't be confused with the next line.
+		fmt.Fprintf(&canonical, "%s=%q\n", name, attributes[name])
+	}
+	canonical.WriteByte('\n')
+	canonical.WriteString(body)
+
+	h.Write([]byte(canonical.String()))
+	return h.Sum(nil)
+}
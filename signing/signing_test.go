@@ -0,0 +1,162 @@
+package signing
+
+import (
+	"errors"
+	"maps"
+	"testing"
+
+	"github.com/Iknite-Space/psss/models"
+)
+
+var (
+	oldSecret = []byte("0123456789abcdef0123456789abcdef")
+	newSecret = []byte("fedcba9876543210fedcba9876543210")
+)
+
+const testBody = `{"event_id":"1","resource_type":"note"}`
+
+// signedAttributes returns the attributes of testBody signed by signer.
+func signedAttributes(signer *Signer) map[string]string {
+	attributes := map[string]string{
+		models.AttributeContentType: models.ContentTypeJSON,
+		"tenant":                    "acme",
+	}
+	signer.Sign(testBody, attributes)
+	return attributes
+}
+
+func TestSignVerify(t *testing.T) {
+	attributes := signedAttributes(NewSigner("key-1", oldSecret))
+	if attributes[AttributeKeyID] != "key-1" || attributes[AttributeSignature] == "" {
+		t.Fatalf("Sign() attributes = %v, want a signature by key-1", attributes)
+	}
+
+	verifier := NewVerifier(map[string][]byte{"key-1": oldSecret})
+	err := verifier.Verify(testBody, attributes)
+	if err != nil {
+		t.Errorf("Verify() = %v", err)
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	verifier := NewVerifier(map[string][]byte{"key-1": oldSecret})
+	signed := signedAttributes(NewSigner("key-1", oldSecret))
+
+	tests := []struct {
+		name       string
+		body       string
+		attributes func(attributes map[string]string)
+		want       error
+	}{
+		{
+			name: "tampered body",
+			body: `{"event_id":"2","resource_type":"note"}`,
+			want: ErrInvalidSignature,
+		},
+		{
+			name:       "tampered attribute",
+			attributes: func(attributes map[string]string) { attributes["tenant"] = "evil" },
+			want:       ErrInvalidSignature,
+		},
+		{
+			name:       "added attribute",
+			attributes: func(attributes map[string]string) { attributes["admin"] = "true" },
+			want:       ErrInvalidSignature,
+		},
+		{
+			name:       "removed attribute",
+			attributes: func(attributes map[string]string) { delete(attributes, "tenant") },
+			want:       ErrInvalidSignature,
+		},
+		{
+			// the attributes can't be shifted into the body or the other way around.
+			name:       "attribute value with a newline",
+			attributes: func(attributes map[string]string) { attributes["tenant"] = "acme\n" },
+			want:       ErrInvalidSignature,
+		},
+		{
+			name:       "malformed signature",
+			attributes: func(attributes map[string]string) { attributes[AttributeSignature] = "not base64!" },
+			want:       ErrInvalidSignature,
+		},
+		{
+			name:       "unknown key",
+			attributes: func(attributes map[string]string) { attributes[AttributeKeyID] = "key-2" },
+			want:       ErrUnknownKey,
+		},
+		{
+			name:       "unsigned",
+			attributes: func(attributes map[string]string) { delete(attributes, AttributeSignature) },
+			want:       ErrUnsigned,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body := testBody
+			if test.body != "" {
+				body = test.body
+			}
+			attributes := maps.Clone(signed)
+			if test.attributes != nil {
+				test.attributes(attributes)
+			}
+
+			err := verifier.Verify(body, attributes)
+			if !errors.Is(err, test.want) {
+				t.Errorf("Verify() = %v, want %v", err, test.want)
+			}
+		})
+	}
+}
+
+func TestVerifyKeyRotation(t *testing.T) {
+	signedByOld := signedAttributes(NewSigner("key-1", oldSecret))
+	signedByNew := signedAttributes(NewSigner("key-2", newSecret))
+
+	// during the rotation the verifiers accept both keys.
+	verifier := NewVerifier(map[string][]byte{"key-1": oldSecret, "key-2": newSecret})
+	for name, attributes := range map[string]map[string]string{"key-1": signedByOld, "key-2": signedByNew} {
+		err := verifier.Verify(testBody, attributes)
+		if err != nil {
+			t.Errorf("Verify() of a message signed by %s = %v", name, err)
+		}
+	}
+
+	// once the old key is removed, its messages are rejected.
+	verifier = NewVerifier(map[string][]byte{"key-2": newSecret})
+	err := verifier.Verify(testBody, signedByOld)
+	if !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Verify() of a message signed by a removed key = %v, want ErrUnknownKey", err)
+	}
+
+	// a message can't be passed off as signed by another key.
+	forged := maps.Clone(signedByOld)
+	forged[AttributeKeyID] = "key-2"
+	err = verifier.Verify(testBody, forged)
+	if !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Verify() of a message with another key ID = %v, want ErrInvalidSignature", err)
+	}
+}
+
+func TestUnsignedAttributes(t *testing.T) {
+	attributes := map[string]string{models.AttributeContentType: models.ContentTypeJSON}
+	want := mac(oldSecret, testBody, attributes)
+
+	// the signature attributes and the content encoding, which is added after signing, don't change the signature.
+	for name := range unsignedAttributes {
+		withUnsigned := maps.Clone(attributes)
+		withUnsigned[name] = "gzip"
+		if got := mac(oldSecret, testBody, withUnsigned); string(got) != string(want) {
+			t.Errorf("the signature covers the %s attribute", name)
+		}
+	}
+
+	signed := maps.Clone(attributes)
+	NewSigner("key-1", oldSecret).Sign(testBody, signed)
+	signed[models.AttributeContentEncoding] = "gzip"
+	err := NewVerifier(map[string][]byte{"key-1": oldSecret}).Verify(testBody, signed)
+	if err != nil {
+		t.Errorf("Verify() of a message with a content encoding = %v", err)
+	}
+}
@@ -0,0 +1,128 @@
+package sub
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// AdminHandler is an http.Handler exposing runtime controls of processors, e.g. to stop consuming from a queue
+// during an incident without redeploying. It serves:
+//
+//	GET  /processors                    stats of all processors
+//	GET  /processors/{name}             stats of a processor
+//	POST /processors/{name}/pause       pauses a processor
+//	POST /processors/{name}/resume      resumes a processor
+//	PUT  /processors/{name}/concurrency sets the concurrency of a processor, from a {"limit": n} body
+//
+// The handler has no authentication of its own and should only be reachable by operators. Use http.StripPrefix to
+// mount it under a prefix.
+type AdminHandler struct {
+	mu         sync.RWMutex
+	names      []string
+	processors map[string]*SqsEventProcessor
+	mux        *http.ServeMux
+}
+
+// ProcessorAdminStatus is the JSON representation of a processor served by AdminHandler.
+type ProcessorAdminStatus struct {
+	Name string `json:"name"`
+	ProcessorStats
+}
+
+type setConcurrencyRequest struct {
+	Limit int `json:"limit"`
+}
+
+var _ http.Handler = (*AdminHandler)(nil)
+
+// NewAdminHandler creates an AdminHandler without processors.
+func NewAdminHandler() *AdminHandler {
+	h := &AdminHandler{
+		processors: make(map[string]*SqsEventProcessor),
+		mux:        http.NewServeMux(),
+	}
+	h.mux.HandleFunc("GET /processors", h.list)
+	h.mux.HandleFunc("GET /processors/{name}", h.withProcessor(h.get))
+	h.mux.HandleFunc("POST /processors/{name}/pause", h.withProcessor(h.pause))
+	h.mux.HandleFunc("POST /processors/{name}/resume", h.withProcessor(h.resume))
+	h.mux.HandleFunc("PUT /processors/{name}/concurrency", h.withProcessor(h.setConcurrency))
+	return h
+}
+
+// WithProcessor adds a processor to the handler under name, replacing any processor with the same name.
+func (h *AdminHandler) WithProcessor(name string, processor *SqsEventProcessor) *AdminHandler {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.processors[name]; !ok {
+		h.names = append(h.names, name)
+	}
+	h.processors[name] = processor
+	return h
+}
+
+// ServeHTTP implements http.Handler.
+func (h *AdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *AdminHandler) list(w http.ResponseWriter, _ *http.Request) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	statuses := make([]ProcessorAdminStatus, 0, len(h.names))
+	for _, name := range h.names {
+		statuses = append(statuses, ProcessorAdminStatus{Name: name, ProcessorStats: h.processors[name].Stats()})
+	}
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+func (h *AdminHandler) get(w http.ResponseWriter, _ *http.Request, name string, processor *SqsEventProcessor) {
+	writeJSON(w, http.StatusOK, ProcessorAdminStatus{Name: name, ProcessorStats: processor.Stats()})
+}
+
+func (h *AdminHandler) pause(w http.ResponseWriter, r *http.Request, name string, processor *SqsEventProcessor) {
+	processor.Pause()
+	h.get(w, r, name, processor)
+}
+
+func (h *AdminHandler) resume(w http.ResponseWriter, r *http.Request, name string, processor *SqsEventProcessor) {
+	processor.Resume()
+	h.get(w, r, name, processor)
+}
+
+func (h *AdminHandler) setConcurrency(w http.ResponseWriter, r *http.Request, name string, processor *SqsEventProcessor) {
+	var req setConcurrencyRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	if req.Limit < 1 {
+		http.Error(w, "limit must be at least 1", http.StatusBadRequest)
+		return
+	}
+
+	processor.SetConcurrency(req.Limit)
+	h.get(w, r, name, processor)
+}
+
+// withProcessor resolves the processor named in the request path before calling fn, or responds with 404 Not
+// Found if there is no such processor.
+func (h *AdminHandler) withProcessor(fn func(http.ResponseWriter, *http.Request, string, *SqsEventProcessor)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+
+		h.mu.RLock()
+		processor, ok := h.processors[name]
+		h.mu.RUnlock()
+		if !ok {
+			http.Error(w, fmt.Sprintf("unknown processor %q", name), http.StatusNotFound)
+			return
+		}
+
+		fn(w, r, name, processor)
+	}
+}
@@ -0,0 +1,94 @@
+package sub
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAdminHandler(t *testing.T) {
+	notes := NewSqsEventProcessor(nil, testQueueURL, nil).WithConcurrency(4)
+	notes.recordResult(nil)
+	notes.recordResult(errors.New("boom"))
+	folders := NewSqsEventProcessor(nil, testQueueURL, nil)
+	handler := NewAdminHandler().WithProcessor("notes", notes).WithProcessor("folders", folders)
+
+	// serve sends a request to the handler and returns the response, decoding its JSON body into body if it is OK.
+	serve := func(method, path, requestBody string, body any) *httptest.ResponseRecorder {
+		t.Helper()
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(requestBody)))
+		if w.Code == http.StatusOK && body != nil {
+			err := json.Unmarshal(w.Body.Bytes(), body)
+			if err != nil {
+				t.Fatalf("%s %s body %s: %v", method, path, w.Body, err)
+			}
+		}
+		return w
+	}
+
+	t.Run("list", func(t *testing.T) {
+		var statuses []ProcessorAdminStatus
+		w := serve(http.MethodGet, "/processors", "", &statuses)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status %d, want 200", w.Code)
+		}
+		if len(statuses) != 2 || statuses[0].Name != "notes" || statuses[1].Name != "folders" {
+			t.Fatalf("statuses = %+v, want notes and folders in the order they were added", statuses)
+		}
+		stats := statuses[0].ProcessorStats
+		if stats.Processed != 1 || stats.Failed != 1 || stats.LastError != "boom" || stats.Concurrency.Limit != 4 {
+			t.Errorf("notes stats = %+v, want 1 processed, 1 failed with boom and a limit of 4", stats)
+		}
+	})
+
+	t.Run("pause and resume", func(t *testing.T) {
+		var status ProcessorAdminStatus
+		w := serve(http.MethodPost, "/processors/notes/pause", "", &status)
+		if w.Code != http.StatusOK || !status.Paused || !notes.Paused() {
+			t.Errorf("pause: status %d, paused %v, want 200 and paused", w.Code, status.Paused)
+		}
+		if folders.Paused() {
+			t.Error("pausing notes paused folders")
+		}
+
+		w = serve(http.MethodPost, "/processors/notes/resume", "", &status)
+		if w.Code != http.StatusOK || status.Paused || notes.Paused() {
+			t.Errorf("resume: status %d, paused %v, want 200 and running", w.Code, status.Paused)
+		}
+	})
+
+	t.Run("concurrency", func(t *testing.T) {
+		var status ProcessorAdminStatus
+		w := serve(http.MethodPut, "/processors/notes/concurrency", `{"limit":2}`, &status)
+		if w.Code != http.StatusOK || status.Concurrency.Limit != 2 || notes.Concurrency().Limit != 2 {
+			t.Errorf("status %d, limit %d, want 200 and 2", w.Code, status.Concurrency.Limit)
+		}
+	})
+
+	errorTests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{name: "unknown processor", method: http.MethodGet, path: "/processors/tags", want: http.StatusNotFound},
+		{name: "pause unknown processor", method: http.MethodPost, path: "/processors/tags/pause", want: http.StatusNotFound},
+		{name: "pause with GET", method: http.MethodGet, path: "/processors/notes/pause", want: http.StatusMethodNotAllowed},
+		{name: "invalid concurrency body", method: http.MethodPut, path: "/processors/notes/concurrency", body: `{`, want: http.StatusBadRequest},
+		{name: "zero concurrency", method: http.MethodPut, path: "/processors/notes/concurrency", body: `{"limit":0}`, want: http.StatusBadRequest},
+	}
+	for _, test := range errorTests {
+		t.Run(test.name, func(t *testing.T) {
+			w := serve(test.method, test.path, test.body, nil)
+			if w.Code != test.want {
+				t.Errorf("status %d, want %d", w.Code, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,297 @@
+package sub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"strconv"
+	"time"
+
+	"github.com/Iknite-Space/psss/models"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	awstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/rs/zerolog"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	defaultBatchSize = 10
+	defaultBatchWait = time.Second
+)
+
+// BatchResult is the outcome of handling a batch of messages. Items that didn't fail are deleted from the queue,
+// failed ones are released back to it to be retried, unless their error is permanent (see Permanent).
+type BatchResult struct {
+	// Err fails every item of the batch that has no error of its own in Failures.
+	Err error
+
+	// Failures holds the errors of the items that failed, by their index in the batch.
+	Failures map[int]error
+}
+
+// Fail records that the item at index in the batch failed with err.
+func (r *BatchResult) Fail(index int, err error) {
+	if r.Failures == nil {
+		r.Failures = make(map[int]error)
+	}
+	r.Failures[index] = err
+}
+
+// itemError returns the error the item at index failed with, or nil if it succeeded.
+func (r BatchResult) itemError(index int) error {
+	if err := r.Failures[index]; err != nil {
+		return err
+	}
+	return r.Err
+}
+
+// SqsBatchHandlerFn is a function that handles a batch of SQS messages.
+type SqsBatchHandlerFn func(ctx context.Context, messages []awstypes.Message) BatchResult
+
+// JSONBatchHandlerFn is a function that handles a batch of messages of type T.
+type JSONBatchHandlerFn[T any] func(ctx context.Context, messages []T) BatchResult
+
+// ProtoMutationEventBatchHandlerFn is a function that handles a batch of mutation events.
+type ProtoMutationEventBatchHandlerFn[T proto.Message] func(ctx context.Context, events []models.ProtoMutationEvent[T]) BatchResult
+
+// NewSqsBatchEventProcessor creates an SqsEventProcessor that passes the messages it receives to handlerFn in
+// batches, see WithBatchWindow. Each batch takes one of the processor's workers, see WithConcurrency.
+func NewSqsBatchEventProcessor(svc *sqs.Client, queueURL string, handlerFn SqsBatchHandlerFn) *SqsEventProcessor {
+	return &SqsEventProcessor{
+		svc:            svc,
+		queueURL:       queueURL,
+		logger:         zerolog.Nop(),
+		batchHandlerFn: handlerFn,
+		batchSize:      defaultBatchSize,
+		batchWait:      defaultBatchWait,
+	}
+}
+
+// NewJSONSqsBatchEventProcessor creates a batch SqsEventProcessor that unmarshals the body of each message as JSON
+// into a T. Messages that fail to be unmarshaled are reported as failed and are not passed to handlerFn.
+func NewJSONSqsBatchEventProcessor[T any](svc *sqs.Client, queueURL string, handlerFn JSONBatchHandlerFn[T]) *SqsEventProcessor {
+	return NewSqsBatchEventProcessor(svc, queueURL, func(ctx context.Context, messages []awstypes.Message) BatchResult {
+		return decodeBatch(ctx, messages, func(_ context.Context, message awstypes.Message) (T, error) {
+			return decodeJSONMessage[T](message)
+		}, handlerFn)
+	})
+}
+
+// NewMutationEventSqsBatchProcessor creates a batch SqsEventProcessor that decodes each message into a mutation
+// event, like NewMutationEventSqsProcessor does. Messages that fail to be decoded are reported as failed and are not
+// passed to handler. WithCoalescing and WithVersionGuard are not supported: Run fails with ErrUnsupportedOption.
+func NewMutationEventSqsBatchProcessor[T proto.Message](svc *sqs.Client, queueURL string, newMessage func() T, handler ProtoMutationEventBatchHandlerFn[T], opts ...MutationEventOption) *SqsEventProcessor {
+	cfg := newMutationEventConfig(opts)
+
+	processor := NewSqsBatchEventProcessor(svc, queueURL, func(ctx context.Context, messages []awstypes.Message) BatchResult {
+		return decodeBatch(ctx, messages, func(ctx context.Context, message awstypes.Message) (models.ProtoMutationEvent[T], error) {
+			ctx, body, err := unwrapSqsMessage(ctx, message)
+			if err != nil {
+				return models.ProtoMutationEvent[T]{}, err
+			}
+			return decodeProtoMutationEvent(ctx, body, newMessage, cfg)
+		}, handler)
+	})
+	processor.configErr = cfg.validateBatch()
+	return processor
+}
+
+// validateBatch returns an error wrapping ErrUnsupportedOption if cfg has options batch processors don't support:
+// WithCoalescing and WithVersionGuard, which act on the events of a resource one at a time.
+func (cfg mutationEventConfig) validateBatch() error {
+	if cfg.coalesceWindow > 0 {
+		return fmt.Errorf("%w: batch processors don't support WithCoalescing", ErrUnsupportedOption)
+	}
+	if cfg.versionGuard != nil {
+		return fmt.Errorf("%w: batch processors don't support WithVersionGuard", ErrUnsupportedOption)
+	}
+	return nil
+}
+
+// WithBatchWindow sets the maximum number of messages in a batch and how long to wait for more messages once the
+// first message of a batch was received. maxWait should be well below the visibility timeout of the messages.
+// Defaults to 10 messages and 1s. Only applies to batch processors.
+func (s *SqsEventProcessor) WithBatchWindow(size int, maxWait time.Duration) *SqsEventProcessor {
+	s.batchSize = max(size, 1)
+	s.batchWait = maxWait
+	return s
+}
+
+// decodeBatch decodes each message and passes the decoded ones to handler, mapping the failures it reports back to
+// the indexes of the messages.
+func decodeBatch[T any](ctx context.Context, messages []awstypes.Message, decode func(context.Context, awstypes.Message) (T, error), handler func(context.Context, []T) BatchResult) BatchResult {
+	var result BatchResult
+
+	items := make([]T, 0, len(messages))
+	indexes := make([]int, 0, len(messages))
+	for i, message := range messages {
+		item, err := decode(ctx, message)
+		if err != nil {
+			result.Fail(i, err)
+			continue
+		}
+		items = append(items, item)
+		indexes = append(indexes, i)
+	}
+	if len(items) == 0 {
+		return result
+	}
+
+	itemsResult := handler(ctx, items)
+	for j, i := range indexes {
+		if err := itemsResult.itemError(j); err != nil {
+			result.Fail(i, err)
+		}
+	}
+	return result
+}
+
+// receiveBatch receives messages until the batch is full or the batch window is over. The batch takes a single
+// worker slot.
+func (s *SqsEventProcessor) receiveBatch(ctx context.Context, limiter *concurrencyLimiter) ([]awstypes.Message, int, error) {
+	slots, ok := limiter.acquire(ctx, 1)
+	if !ok {
+		return nil, 0, ctx.Err()
+	}
+
+	var batch []awstypes.Message
+	var deadline time.Time
+	for len(batch) < s.batchSize {
+		// long poll for the first message, then only until the batch window is over.
+		waitSeconds := int32(defaultWaitTimeSeconds)
+		if len(batch) > 0 {
+			remaining := time.Until(deadline)
+			if remaining <= 0 {
+				break
+			}
+			waitSeconds = int32(min(remaining/time.Second, defaultWaitTimeSeconds))
+		}
+
+		n, err := s.rateLimit(ctx, min(s.batchSize-len(batch), maxReceiveMessages))
+		if err != nil {
+			return batch, slots, err
+		}
+
+		out, err := s.svc.ReceiveMessage(ctx, s.receiveMessageInput(n, waitSeconds))
+		if err != nil {
+			return batch, slots, err
+		}
+		s.takeTokens(len(out.Messages))
+
+		if len(batch) == 0 && len(out.Messages) > 0 {
+			deadline = time.Now().Add(s.batchWait)
+		}
+		batch = append(batch, out.Messages...)
+
+		if len(batch) == 0 {
+			// nothing received yet, let Run check whether it should stop before polling again.
+			break
+		}
+		if len(out.Messages) == 0 && waitSeconds == 0 {
+			// less than a second of the batch window is left and the queue is empty.
+			break
+		}
+	}
+
+	return batch, slots, nil
+}
+
+// processBatch prepares the messages and passes them to the batch handler function. The messages that were handled
+// successfully or failed permanently are deleted from the queue. It returns the errors the messages failed with.
+func (s *SqsEventProcessor) processBatch(ctx context.Context, messages []awstypes.Message) error {
+	errs := make([]error, len(messages))
+	prepared := make([]awstypes.Message, 0, len(messages))
+	indexes := make([]int, 0, len(messages))
+	for i, message := range messages {
+		if message.ReceiptHandle == nil {
+			errs[i] = errors.New("message has no receipt handle")
+			continue
+		}
+		message, err := s.prepareMessage(message)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		prepared = append(prepared, message)
+		indexes = append(indexes, i)
+	}
+
+	if len(prepared) > 0 {
+		result := s.callBatchHandler(ctx, prepared)
+
+		for j, i := range indexes {
+			errs[i] = result.itemError(j)
+		}
+	}
+
+	var deletable []awstypes.Message
+	for i, message := range messages {
+		err := errs[i]
+		s.recordResult(err)
+
+		messageID := aws.ToString(message.MessageId)
+		if err != nil {
+			if !IsPermanent(err) {
+				// Note:    This is a debug message because "true" errors should be logged by the handling function.
+				s.logger.Debug().Err(err).Str("message_id", messageID).Msg("Error processing message")
+				continue
+			}
+			s.logger.Error().Err(err).Str("message_id", messageID).Msg("Message failed permanently, deleting it")
+		}
+		deletable = append(deletable, message)
+	}
+	s.deleteMessages(ctx, deletable)
+
+	return errors.Join(errs...)
+}
+
+// callBatchHandler passes the messages to the batch handler function, turning a panic into a result failing the
+// whole batch with an error wrapping ErrHandlerPanicked.
+func (s *SqsEventProcessor) callBatchHandler(ctx context.Context, messages []awstypes.Message) (result BatchResult) {
+	id := s.handlers.start()
+	defer s.handlers.done(id)
+	defer func() {
+		if r := recover(); r != nil {
+			s.logger.Error().Int("count", len(messages)).Interface("panic", r).Str("stack", string(debug.Stack())).
+				Msg("Batch handler panicked")
+			result = BatchResult{Err: fmt.Errorf("%w: %v", ErrHandlerPanicked, r)}
+		}
+	}()
+	return s.batchHandlerFn(ctx, messages)
+}
+
+// deleteMessages deletes messages from the queue, in batches of up to 10 messages.
+func (s *SqsEventProcessor) deleteMessages(ctx context.Context, messages []awstypes.Message) {
+	for start := 0; start < len(messages); start += maxReceiveMessages {
+		chunk := messages[start:min(start+maxReceiveMessages, len(messages))]
+
+		entries := make([]awstypes.DeleteMessageBatchRequestEntry, len(chunk))
+		for i, message := range chunk {
+			entries[i] = awstypes.DeleteMessageBatchRequestEntry{
+				Id:            aws.String(strconv.Itoa(i)),
+				ReceiptHandle: message.ReceiptHandle,
+			}
+		}
+
+		out, err := s.svc.DeleteMessageBatch(ctx, &sqs.DeleteMessageBatchInput{
+			QueueUrl: aws.String(s.queueURL),
+			Entries:  entries,
+		})
+		if err != nil {
+			s.logger.Error().Err(err).Int("count", len(chunk)).Msg("Error deleting messages. Warning these " +
+				"messages will likely get reprocessed")
+			continue
+		}
+		for _, failed := range out.Failed {
+			messageID := ""
+			if i, err := strconv.Atoi(aws.ToString(failed.Id)); err == nil && i < len(chunk) {
+				messageID = aws.ToString(chunk[i].MessageId)
+			}
+			s.logger.Error().Str("message_id", messageID).Str("code", aws.ToString(failed.Code)).
+				Str("reason", aws.ToString(failed.Message)).
+				Msg("Error deleting message. Warning this message will likely get reprocessed")
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package sub
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Iknite-Space/psss/models"
+	awstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestProcessBatch(t *testing.T) {
+	errRetry := errors.New("try again")
+	errInvalid := errors.New("invalid note")
+
+	tests := []struct {
+		name        string
+		result      BatchResult
+		wantDeleted []string
+		wantErr     []error
+	}{
+		{
+			name:        "success",
+			wantDeleted: []string{"handle-0", "handle-1", "handle-2", "handle-3"},
+		},
+		{
+			name: "partial failure",
+			result: BatchResult{Failures: map[int]error{
+				1: errRetry,
+				2: Permanent(errInvalid),
+			}},
+			// only the message that can be retried is released.
+			wantDeleted: []string{"handle-0", "handle-2", "handle-3"},
+			wantErr:     []error{errRetry, errInvalid},
+		},
+		{
+			name:    "whole batch error",
+			result:  BatchResult{Err: errRetry},
+			wantErr: []error{errRetry},
+		},
+		{
+			name:        "permanent whole batch error",
+			result:      BatchResult{Err: Permanent(errInvalid)},
+			wantDeleted: []string{"handle-0", "handle-1", "handle-2", "handle-3"},
+			wantErr:     []error{errInvalid},
+		},
+		{
+			// the failures of the items override the error of the batch.
+			name: "whole batch error with item failures",
+			result: BatchResult{Err: errRetry, Failures: map[int]error{
+				3: Permanent(errInvalid),
+			}},
+			wantDeleted: []string{"handle-3"},
+			wantErr:     []error{errRetry, errInvalid},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := &fakeSqs{}
+			var handled int
+			processor := NewSqsBatchEventProcessor(fake.client(), testQueueURL, func(_ context.Context, messages []awstypes.Message) BatchResult {
+				handled = len(messages)
+				return test.result
+			})
+
+			messages := []awstypes.Message{newTestMessage(0, "{}"), newTestMessage(1, "{}"), newTestMessage(2, "{}"), newTestMessage(3, "{}")}
+			err := processor.processBatch(context.Background(), messages)
+			if handled != len(messages) {
+				t.Errorf("handler received %d messages, want %d", handled, len(messages))
+			}
+			for _, want := range test.wantErr {
+				if !errors.Is(err, want) {
+					t.Errorf("processBatch() = %v, want %v", err, want)
+				}
+			}
+			if len(test.wantErr) == 0 && err != nil {
+				t.Errorf("processBatch() = %v", err)
+			}
+
+			// the messages are deleted with a single DeleteMessageBatch call.
+			deleted := fake.deletedHandles()
+			slices.Sort(deleted)
+			if !slices.Equal(deleted, test.wantDeleted) {
+				t.Errorf("deleted %v, want %v", deleted, test.wantDeleted)
+			}
+			wantCalls := 0
+			if len(test.wantDeleted) > 0 {
+				wantCalls = 1
+			}
+			if fake.deleteCalls != wantCalls {
+				t.Errorf("DeleteMessageBatch called %d times, want %d", fake.deleteCalls, wantCalls)
+			}
+		})
+	}
+}
+
+func TestProcessBatchDecodeFailure(t *testing.T) {
+	fake := &fakeSqs{}
+	var handled []string
+	processor := NewJSONSqsBatchEventProcessor(fake.client(), testQueueURL, func(_ context.Context, notes []map[string]string) BatchResult {
+		for _, note := range notes {
+			handled = append(handled, note["id"])
+		}
+		return BatchResult{}
+	})
+
+	// the message that fails to decode isn't passed to the handler, and is released.
+	err := processor.processBatch(context.Background(), []awstypes.Message{
+		newTestMessage(0, `{"id":"a"}`),
+		newTestMessage(1, `not json`),
+		newTestMessage(2, `{"id":"b"}`),
+	})
+	if err == nil {
+		t.Error("processBatch() succeeded, want the decoding error")
+	}
+	if !slices.Equal(handled, []string{"a", "b"}) {
+		t.Errorf("handled %v, want [a b]", handled)
+	}
+	deleted := fake.deletedHandles()
+	slices.Sort(deleted)
+	if !slices.Equal(deleted, []string{"handle-0", "handle-2"}) {
+		t.Errorf("deleted %v, want [handle-0 handle-2]", deleted)
+	}
+}
+
+func TestReceiveBatch(t *testing.T) {
+	queue := func(n int) []awstypes.Message {
+		messages := make([]awstypes.Message, n)
+		for i := range messages {
+			messages[i] = newTestMessage(i, "{}")
+		}
+		return messages
+	}
+
+	tests := []struct {
+		name         string
+		queued       int
+		receiveLimit int
+		size         int
+		wait         time.Duration
+		want         int
+		wantReceives int
+	}{
+		// the batch is flushed once it is full, even though more messages are available.
+		{name: "full batch", queued: 25, size: 5, wait: time.Minute, want: 5, wantReceives: 1},
+		// messages arriving within the window are added to the batch until it is full.
+		{name: "full batch over several receives", queued: 25, receiveLimit: 2, size: 5, wait: time.Minute, want: 5, wantReceives: 3},
+		// batches larger than a ReceiveMessage call are received in several calls.
+		{name: "batch larger than a receive", queued: 25, size: 15, wait: time.Minute, want: 15, wantReceives: 2},
+		// the batch is flushed when the window is over, with the messages received so far.
+		{name: "window over", queued: 2, size: 10, wait: 500 * time.Millisecond, want: 2, wantReceives: 2},
+		{name: "empty queue", size: 10, wait: time.Minute, wantReceives: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := &fakeSqs{queue: queue(test.queued), receiveLimit: test.receiveLimit}
+			processor := NewSqsBatchEventProcessor(fake.client(), testQueueURL, nil).WithBatchWindow(test.size, test.wait)
+
+			batch, slots, err := processor.receiveBatch(context.Background(), processor.concurrencyLimiter())
+			if err != nil {
+				t.Fatalf("receiveBatch() = %v", err)
+			}
+			if slots != 1 {
+				t.Errorf("receiveBatch() took %d worker slots, want 1", slots)
+			}
+			if len(batch) != test.want {
+				t.Errorf("receiveBatch() returned %d messages, want %d", len(batch), test.want)
+			}
+			if fake.receives != test.wantReceives {
+				t.Errorf("ReceiveMessage called %d times, want %d", fake.receives, test.wantReceives)
+			}
+		})
+	}
+}
+
+func TestBatchProcessorUnsupportedOptions(t *testing.T) {
+	handler := func(context.Context, []models.ProtoMutationEvent[*wrapperspb.StringValue]) BatchResult {
+		return BatchResult{}
+	}
+	newMessage := func() *wrapperspb.StringValue { return &wrapperspb.StringValue{} }
+
+	tests := map[string]MutationEventOption{
+		"coalescing":    WithCoalescing(time.Second),
+		"version guard": WithVersionGuard(NewVersionGuard(NewMemoryVersionStore(), VersionPolicyDrop)),
+	}
+
+	for name, opt := range tests {
+		t.Run(name, func(t *testing.T) {
+			fake := &fakeSqs{}
+			processor := NewMutationEventSqsBatchProcessor(fake.client(), testQueueURL, newMessage, handler, opt)
+
+			err := processor.Run(context.Background())
+			if !errors.Is(err, ErrUnsupportedOption) {
+				t.Errorf("Run() = %v, want ErrUnsupportedOption", err)
+			}
+			if fake.receives != 0 {
+				t.Errorf("ReceiveMessage called %d times, want none", fake.receives)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package sub
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/Iknite-Space/psss/models"
+	"github.com/Iknite-Space/psss/pub"
+	"github.com/Iknite-Space/psss/signing"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// sqsDelivery returns the SQS message SNS delivers for message: the message itself with raw message delivery, or
+// an SNS notification wrapping it otherwise.
+func sqsDelivery(t *testing.T, message publishedMessage, raw bool) awstypes.Message {
+	t.Helper()
+
+	if raw {
+		sqsMessage := awstypes.Message{
+			MessageId:         aws.String("1"),
+			Body:              aws.String(message.body),
+			MessageAttributes: make(map[string]awstypes.MessageAttributeValue),
+		}
+		for name, value := range message.attributes {
+			sqsMessage.MessageAttributes[name] = awstypes.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(value)}
+		}
+		return sqsMessage
+	}
+
+	notification := SnsWrapper{
+		Type:              snsTypeNotification,
+		MessageID:         "sns-1",
+		TopicArn:          "arn:aws:sns:us-east-1:123456789012:notes",
+		Timestamp:         "2025-01-02T03:04:05.000Z",
+		Message:           message.body,
+		MessageAttributes: make(map[string]SnsMessageAttribute),
+	}
+	for name, value := range message.attributes {
+		notification.MessageAttributes[name] = SnsMessageAttribute{Type: "String", Value: value}
+	}
+	b, err := json.Marshal(notification)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return awstypes.Message{MessageId: aws.String("1"), Body: aws.String(string(b))}
+}
+
+func TestCloudEventsRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	event := models.ProtoMutationEvent[*wrapperspb.StringValue]{
+		EventType:     models.EventTypeUpdated,
+		CorrelationID: "request-1",
+		ResourceType:  "note",
+		ResourceID:    "1",
+		Sequence:      7,
+		UserID:        "user-1",
+		Before:        wrapperspb.String("before"),
+		After:         wrapperspb.String("after"),
+	}
+
+	for _, envelope := range []pub.Envelope{pub.EnvelopeCloudEventsStructured, pub.EnvelopeCloudEventsBinary} {
+		var published []publishedMessage
+		publisher := pub.NewPubService[*wrapperspb.StringValue](newRecordingSnsClient(&published), "arn:aws:sns:us-east-1:123456789012:notes").
+			WithSource("notes-service").
+			WithEnvelope(envelope)
+		err := publisher.Publish(ctx, event)
+		if err != nil {
+			t.Fatalf("Publish() with envelope %d = %v", envelope, err)
+		}
+		message := published[0]
+
+		wantBinary := envelope == pub.EnvelopeCloudEventsBinary
+		if got := models.IsBinaryCloudEvent(message.attributes); got != wantBinary {
+			t.Errorf("IsBinaryCloudEvent() with envelope %d = %v, want %v", envelope, got, wantBinary)
+		}
+
+		// the auto-detect handler finds the attributes of both raw and SNS wrapped deliveries.
+		for _, raw := range []bool{true, false} {
+			var got models.ProtoMutationEvent[*wrapperspb.StringValue]
+			handler := StringHandlerToAutoDetectSqsHandler(MutationEventHandlerToStringHandler(
+				func(_ context.Context, e models.ProtoMutationEvent[*wrapperspb.StringValue]) error {
+					got = e
+					return nil
+				}, func() *wrapperspb.StringValue { return &wrapperspb.StringValue{} }))
+
+			err = handler(ctx, sqsDelivery(t, message, raw))
+			if err != nil {
+				t.Fatalf("handler() with envelope %d and raw delivery %v = %v", envelope, raw, err)
+			}
+			if got.EventID == "" || got.EventType != event.EventType || got.Source != "notes-service" ||
+				got.CorrelationID != event.CorrelationID || got.ResourceType != event.ResourceType ||
+				got.ResourceID != event.ResourceID || got.Sequence != event.Sequence || got.UserID != event.UserID ||
+				!proto.Equal(got.Before, event.Before) || !proto.Equal(got.After, event.After) {
+				t.Errorf("handler() with envelope %d and raw delivery %v decoded %+v, want %+v", envelope, raw, got, event)
+			}
+		}
+	}
+}
+
+func TestCloudEventsBinaryAttributeLimit(t *testing.T) {
+	ctx := context.Background()
+	event := models.ProtoMutationEvent[*wrapperspb.StringValue]{
+		EventType:    models.EventTypeCreated,
+		ResourceType: "note",
+		ResourceID:   "1",
+		After:        wrapperspb.String("after"),
+	}
+	secret := []byte("0123456789abcdef0123456789abcdef")
+
+	tests := []struct {
+		name     string
+		sequence uint64
+		userID   string
+		wantErr  bool
+	}{
+		{name: "signed"},
+		// the 6 required attributes, the subject, the user ID and the 2 signature attributes.
+		{name: "signed with all the attributes allowed", userID: "user-1"},
+		{name: "signed with a sequence", sequence: 1, userID: "user-1", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var published []publishedMessage
+			publisher := pub.NewPubService[*wrapperspb.StringValue](newRecordingSnsClient(&published), "arn:aws:sns:us-east-1:123456789012:notes").
+				WithEnvelope(pub.EnvelopeCloudEventsBinary).
+				WithSigner(signing.NewSigner("key-1", secret))
+
+			e := event
+			e.Sequence = test.sequence
+			e.UserID = test.userID
+			err := publisher.Publish(ctx, e)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Publish() = %v, want an error: %v", err, test.wantErr)
+			}
+			if test.wantErr {
+				if len(published) != 0 {
+					t.Errorf("published %v, want nothing published", published)
+				}
+				return
+			}
+			if n := len(published[0].attributes); n > 10 {
+				t.Errorf("published %d attributes, more than SNS delivers", n)
+			}
+		})
+	}
+}
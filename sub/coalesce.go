@@ -0,0 +1,182 @@
+package sub
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Iknite-Space/psss/models"
+	"google.golang.org/protobuf/proto"
+)
+
+// WithCoalescing buffers the mutation events of each resource, identified by its ResourceType and ResourceID, for
+// window after the first one is received, then invokes the handler once with a single event summarizing them:
+//   - if the last event is a deletion, it is passed as is;
+//   - if the resource was deleted and created again, the event has the type "created", no Before and the After of
+//     the latest event;
+//   - otherwise the event has the Before of the earliest event, the After of the latest one, and the type "created"
+//     if the earliest event was a creation or "updated" otherwise.
+//
+// The other fields of the event are those of the latest event, and the handler is invoked with the context of the
+// message of the latest event, so that MessageInfoFromContext describes that message.
+//
+// Events are ordered by EventTime. The messages of all the buffered events are only acknowledged once the handler
+// succeeds, and are all released back to the queue if it fails. CoalescedEventIDs returns the IDs of the events
+// summarized by the event being handled.
+//
+// Since the messages of a resource are in flight while they are buffered, coalescing is only effective when the
+// processor handles several messages concurrently, see SqsEventProcessor.WithConcurrency, and window must be well
+// below the visibility timeout of the messages. Batch processors don't support this option.
+func WithCoalescing(window time.Duration) MutationEventOption {
+	return func(cfg *mutationEventConfig) {
+		cfg.coalesceWindow = window
+	}
+}
+
+type coalescedEventIDsKey struct{}
+
+// CoalescedEventIDs returns the IDs of the events summarized by the event being handled, in the order they were
+// received, when the handler was created with WithCoalescing.
+func CoalescedEventIDs(ctx context.Context) []string {
+	ids, _ := ctx.Value(coalescedEventIDsKey{}).([]string)
+	return ids
+}
+
+type coalesceKey struct {
+	resourceType string
+	resourceID   string
+}
+
+// coalescer groups the mutation events of each resource received within a window and invokes the handler once per
+// group. The groups of a resource are handled one after the other.
+type coalescer[T proto.Message] struct {
+	window  time.Duration
+	handler ProtoMutationEventHandlerFn[T]
+
+	mu sync.Mutex
+	// open holds the groups still accepting events, latest holds the last group of each resource until it has
+	// been handled.
+	open   map[coalesceKey]*coalesceGroup[T]
+	latest map[coalesceKey]*coalesceGroup[T]
+}
+
+type coalesceGroup[T proto.Message] struct {
+	// events are the events of the group along with the context of their message, in the order they were received.
+	events []coalescedEvent[T]
+
+	// previous is the group of the same resource that must be handled before this one, if any.
+	previous *coalesceGroup[T]
+
+	done chan struct{}
+	err  error
+}
+
+type coalescedEvent[T proto.Message] struct {
+	ctx   context.Context
+	event models.ProtoMutationEvent[T]
+}
+
+func newCoalescer[T proto.Message](window time.Duration, handler ProtoMutationEventHandlerFn[T]) *coalescer[T] {
+	return &coalescer[T]{
+		window:  window,
+		handler: handler,
+		open:    make(map[coalesceKey]*coalesceGroup[T]),
+		latest:  make(map[coalesceKey]*coalesceGroup[T]),
+	}
+}
+
+// handle adds event to the open group of its resource, or opens a new one, and waits until the group is handled.
+// It returns the error of the handler, or the error of ctx if it is cancelled first.
+func (c *coalescer[T]) handle(ctx context.Context, event models.ProtoMutationEvent[T]) error {
+	key := coalesceKey{resourceType: event.ResourceType, resourceID: event.ResourceID}
+
+	c.mu.Lock()
+	group, ok := c.open[key]
+	if ok {
+		group.events = append(group.events, coalescedEvent[T]{ctx: ctx, event: event})
+	} else {
+		group = &coalesceGroup[T]{
+			events:   []coalescedEvent[T]{{ctx: ctx, event: event}},
+			previous: c.latest[key],
+			done:     make(chan struct{}),
+		}
+		c.open[key] = group
+		c.latest[key] = group
+		time.AfterFunc(c.window, func() {
+			c.flush(key, group)
+		})
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-group.done:
+		return group.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// flush closes group and handles it once the previous group of the resource was handled.
+func (c *coalescer[T]) flush(key coalesceKey, group *coalesceGroup[T]) {
+	c.mu.Lock()
+	delete(c.open, key)
+	c.mu.Unlock()
+
+	if group.previous != nil {
+		<-group.previous.done
+		group.previous = nil
+	}
+
+	ctx, event := group.summary()
+	group.err = c.handler(ctx, event)
+	close(group.done)
+
+	c.mu.Lock()
+	if c.latest[key] == group {
+		delete(c.latest, key)
+	}
+	c.mu.Unlock()
+}
+
+// summary returns the event summarizing the events of the group, and the context to handle it with.
+func (g *coalesceGroup[T]) summary() (context.Context, models.ProtoMutationEvent[T]) {
+	eventIDs := make([]string, len(g.events))
+	for i, e := range g.events {
+		eventIDs[i] = e.event.EventID
+	}
+
+	// the events received at the same time keep the order in which they were received.
+	events := slices.Clone(g.events)
+	slices.SortStableFunc(events, func(a, b coalescedEvent[T]) int {
+		return a.event.EventTime.Compare(b.event.EventTime)
+	})
+
+	last := events[len(events)-1]
+	ctx := context.WithValue(last.ctx, coalescedEventIDsKey{}, eventIDs)
+	event := last.event
+	if event.EventType == models.EventTypeDeleted {
+		return ctx, event
+	}
+
+	// only the events following the last deletion describe the current resource, which was created again.
+	first := 0
+	for i, e := range events {
+		if e.event.EventType == models.EventTypeDeleted {
+			first = i + 1
+		}
+	}
+	if first > 0 {
+		var zero T
+		event.Before = zero
+		event.EventType = models.EventTypeCreated
+		return ctx, event
+	}
+
+	event.Before = events[0].event.Before
+	event.EventType = models.EventTypeUpdated
+	if events[0].event.EventType == models.EventTypeCreated {
+		event.EventType = models.EventTypeCreated
+	}
+	return ctx, event
+}
@@ -0,0 +1,164 @@
+package sub
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Iknite-Space/psss/models"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestCoalescerSummary(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	event := func(i int, eventType models.EventType, before, after string) models.ProtoMutationEvent[*wrapperspb.StringValue] {
+		e := models.ProtoMutationEvent[*wrapperspb.StringValue]{
+			EventID:      string(rune('a' + i)),
+			EventType:    eventType,
+			EventTime:    start.Add(time.Duration(i) * time.Second),
+			ResourceType: "note",
+			ResourceID:   "1",
+		}
+		if before != "" {
+			e.Before = wrapperspb.String(before)
+		}
+		if after != "" {
+			e.After = wrapperspb.String(after)
+		}
+		return e
+	}
+
+	tests := []struct {
+		name       string
+		events     []models.ProtoMutationEvent[*wrapperspb.StringValue]
+		wantType   models.EventType
+		wantBefore string
+		wantAfter  string
+	}{
+		{
+			name:      "created then updated",
+			events:    []models.ProtoMutationEvent[*wrapperspb.StringValue]{event(0, models.EventTypeCreated, "", "v1"), event(1, models.EventTypeUpdated, "v1", "v2")},
+			wantType:  models.EventTypeCreated,
+			wantAfter: "v2",
+		},
+		{
+			name:       "updated twice",
+			events:     []models.ProtoMutationEvent[*wrapperspb.StringValue]{event(0, models.EventTypeUpdated, "v1", "v2"), event(1, models.EventTypeUpdated, "v2", "v3")},
+			wantType:   models.EventTypeUpdated,
+			wantBefore: "v1",
+			wantAfter:  "v3",
+		},
+		{
+			name:       "updated out of order",
+			events:     []models.ProtoMutationEvent[*wrapperspb.StringValue]{event(1, models.EventTypeUpdated, "v2", "v3"), event(0, models.EventTypeUpdated, "v1", "v2")},
+			wantType:   models.EventTypeUpdated,
+			wantBefore: "v1",
+			wantAfter:  "v3",
+		},
+		{
+			name:       "updated then deleted",
+			events:     []models.ProtoMutationEvent[*wrapperspb.StringValue]{event(0, models.EventTypeUpdated, "v1", "v2"), event(1, models.EventTypeDeleted, "v2", "")},
+			wantType:   models.EventTypeDeleted,
+			wantBefore: "v2",
+		},
+		{
+			name:      "deleted then created",
+			events:    []models.ProtoMutationEvent[*wrapperspb.StringValue]{event(0, models.EventTypeDeleted, "v1", ""), event(1, models.EventTypeCreated, "", "v2")},
+			wantType:  models.EventTypeCreated,
+			wantAfter: "v2",
+		},
+		{
+			name: "updated, deleted, created and updated",
+			events: []models.ProtoMutationEvent[*wrapperspb.StringValue]{
+				event(0, models.EventTypeUpdated, "v1", "v2"),
+				event(1, models.EventTypeDeleted, "v2", ""),
+				event(2, models.EventTypeCreated, "", "v3"),
+				event(3, models.EventTypeUpdated, "v3", "v4"),
+			},
+			wantType:  models.EventTypeCreated,
+			wantAfter: "v4",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			group := &coalesceGroup[*wrapperspb.StringValue]{}
+			for _, e := range test.events {
+				group.events = append(group.events, coalescedEvent[*wrapperspb.StringValue]{ctx: context.Background(), event: e})
+			}
+
+			ctx, got := group.summary()
+			if got.EventType != test.wantType {
+				t.Errorf("EventType = %d, want %d", got.EventType, test.wantType)
+			}
+			if got.Before.GetValue() != test.wantBefore || (got.Before == nil) != (test.wantBefore == "") {
+				t.Errorf("Before = %v, want %q", got.Before, test.wantBefore)
+			}
+			if got.After.GetValue() != test.wantAfter || (got.After == nil) != (test.wantAfter == "") {
+				t.Errorf("After = %v, want %q", got.After, test.wantAfter)
+			}
+			if err := got.Validate(); err != nil {
+				t.Errorf("summary is invalid: %v", err)
+			}
+
+			var wantIDs []string
+			for _, e := range test.events {
+				wantIDs = append(wantIDs, e.EventID)
+			}
+			if ids := CoalescedEventIDs(ctx); !slices.Equal(ids, wantIDs) {
+				t.Errorf("CoalescedEventIDs = %v, want %v", ids, wantIDs)
+			}
+		})
+	}
+}
+
+func TestCoalescerContext(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	var mu sync.Mutex
+	var handled []MessageInfo
+	coalescer := newCoalescer(100*time.Millisecond, func(ctx context.Context, _ models.ProtoMutationEvent[proto.Message]) error {
+		info, _ := MessageInfoFromContext(ctx)
+		mu.Lock()
+		defer mu.Unlock()
+		handled = append(handled, info)
+		return nil
+	})
+
+	// the latest event is received first.
+	var wg sync.WaitGroup
+	for i, messageID := range []string{"latest", "earliest", "middle"} {
+		eventTime := start.Add(-time.Duration(i) * time.Second)
+		if messageID == "latest" {
+			eventTime = start.Add(time.Minute)
+		}
+		ctx := WithMessageInfo(context.Background(), MessageInfo{MessageID: messageID})
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := coalescer.handle(ctx, models.ProtoMutationEvent[proto.Message]{
+				EventID:      messageID,
+				EventType:    models.EventTypeUpdated,
+				EventTime:    eventTime,
+				ResourceType: "note",
+				ResourceID:   "1",
+			})
+			if err != nil {
+				t.Errorf("handle(%s) = %v", messageID, err)
+			}
+		}()
+		// make sure the first message opens the group.
+		if i == 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	wg.Wait()
+
+	if len(handled) != 1 || handled[0].MessageID != "latest" {
+		t.Errorf("handled messages %v, want one with the info of the latest message", handled)
+	}
+}
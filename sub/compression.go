@@ -0,0 +1,101 @@
+package sub
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"strings"
+
+	"github.com/Iknite-Space/psss/compression"
+	"github.com/Iknite-Space/psss/models"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// decompressMessage returns the message with its body decompressed if it was published with a content_encoding
+// attribute, so that handlers never see compressed payloads. Both raw deliveries, where the attribute is an SQS
+// message attribute, and SNS notifications, where it is part of the notification's MessageAttributes, are
+// supported. The content_encoding attribute is removed from the returned message.
+func decompressMessage(message awstypes.Message) (awstypes.Message, error) {
+	if message.Body == nil {
+		return message, nil
+	}
+
+	if value, ok := message.MessageAttributes[models.AttributeContentEncoding]; ok && value.StringValue != nil {
+		body, err := decompressBody(*value.StringValue, *message.Body)
+		if err != nil {
+			return message, err
+		}
+
+		message.Body = aws.String(body)
+		message.MessageAttributes = maps.Clone(message.MessageAttributes)
+		delete(message.MessageAttributes, models.AttributeContentEncoding)
+		return message, nil
+	}
+
+	// cheap check to avoid parsing the bodies of uncompressed messages.
+	if !strings.Contains(*message.Body, models.AttributeContentEncoding) {
+		return message, nil
+	}
+
+	sw, ok := parseSnsNotification(*message.Body)
+	if !ok {
+		return message, nil
+	}
+
+	encoding, ok := sw.MessageAttributes[models.AttributeContentEncoding]
+	if !ok {
+		return message, nil
+	}
+
+	body, err := decompressBody(encoding.Value, sw.Message)
+	if err != nil {
+		return message, err
+	}
+
+	// rewrite the notification through a generic map to preserve the fields SnsWrapper doesn't know about.
+	var notification map[string]json.RawMessage
+	err = json.Unmarshal([]byte(*message.Body), &notification)
+	if err != nil {
+		return message, fmt.Errorf("failed to unmarshal notification: %w", err)
+	}
+
+	delete(sw.MessageAttributes, models.AttributeContentEncoding)
+	notification["Message"], err = json.Marshal(body)
+	if err != nil {
+		return message, fmt.Errorf("failed to marshal decompressed message: %w", err)
+	}
+	notification["MessageAttributes"], err = json.Marshal(sw.MessageAttributes)
+	if err != nil {
+		return message, fmt.Errorf("failed to marshal message attributes: %w", err)
+	}
+
+	b, err := json.Marshal(notification)
+	if err != nil {
+		return message, fmt.Errorf("failed to marshal decompressed notification: %w", err)
+	}
+
+	message.Body = aws.String(string(b))
+	return message, nil
+}
+
+// decompressBody decodes and decompresses a base64 encoded, compressed message body. Bodies decompressing to more
+// than compression.MaxDecompressedSize fail permanently, since they would fail the same way on every delivery.
+func decompressBody(algorithm string, body string) (string, error) {
+	compressed, err := base64.StdEncoding.DecodeString(body)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode compressed message: %w", err)
+	}
+
+	b, err := compression.Decompress(compression.Algorithm(algorithm), compressed)
+	if errors.Is(err, compression.ErrTooLarge) {
+		return "", Permanent(fmt.Errorf("failed to decompress message: %w", err))
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to decompress message: %w", err)
+	}
+
+	return string(b), nil
+}
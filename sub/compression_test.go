@@ -0,0 +1,31 @@
+package sub
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"github.com/Iknite-Space/psss/compression"
+	"github.com/Iknite-Space/psss/models"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+func TestDecompressMessageTooLarge(t *testing.T) {
+	compressed, err := compression.Compress(compression.Gzip, make([]byte, compression.MaxDecompressedSize+1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message := awstypes.Message{
+		Body: aws.String(base64.StdEncoding.EncodeToString(compressed)),
+		MessageAttributes: map[string]awstypes.MessageAttributeValue{
+			models.AttributeContentEncoding: {DataType: aws.String("String"), StringValue: aws.String(string(compression.Gzip))},
+		},
+	}
+
+	_, err = (&SqsEventProcessor{}).prepareMessage(message)
+	if !errors.Is(err, compression.ErrTooLarge) || !IsPermanent(err) {
+		t.Errorf("prepareMessage() = %v, want a permanent compression.ErrTooLarge", err)
+	}
+}
@@ -0,0 +1,297 @@
+package sub
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// maxReceiveMessages is the maximum number of messages a single ReceiveMessage call can return.
+	maxReceiveMessages = 10
+
+	defaultAdaptiveInterval       = 10 * time.Second
+	defaultAdaptiveDecreaseFactor = 0.5
+)
+
+// AdaptiveConcurrency configures the adaptive concurrency control of a processor, see
+// SqsEventProcessor.WithAdaptiveConcurrency.
+type AdaptiveConcurrency struct {
+	// MinConcurrency and MaxConcurrency bound the number of messages handled concurrently. MinConcurrency must be
+	// at least 1.
+	MinConcurrency int
+	MaxConcurrency int
+
+	// InitialConcurrency is the concurrency the processor starts with. Defaults to MinConcurrency.
+	InitialConcurrency int
+
+	// TargetLatency is the average handler latency above which the concurrency is decreased. Zero disables the
+	// latency check.
+	TargetLatency time.Duration
+
+	// MaxErrorRate is the ratio of failed handler invocations, between 0 and 1, above which the concurrency is
+	// decreased. Zero disables the error rate check.
+	MaxErrorRate float64
+
+	// Interval is the window over which latency and error rate are observed before adjusting the concurrency.
+	// Defaults to 10s.
+	Interval time.Duration
+
+	// Increase is the number of workers added after a window without congestion. Defaults to 1.
+	Increase int
+
+	// DecreaseFactor is the factor the concurrency is multiplied with after a window with congestion, between 0
+	// and 1. Defaults to 0.5.
+	DecreaseFactor float64
+}
+
+// ConcurrencyStats is a snapshot of the concurrency of a processor.
+type ConcurrencyStats struct {
+	// Limit is the current maximum number of messages handled concurrently.
+	Limit int `json:"limit"`
+
+	// InFlight is the number of messages being handled.
+	InFlight int `json:"in_flight"`
+
+	// MinLimit and MaxLimit are the bounds of Limit when the concurrency is adaptive. They are both equal to Limit
+	// otherwise.
+	MinLimit int `json:"min_limit"`
+	MaxLimit int `json:"max_limit"`
+}
+
+// WithConcurrency sets the number of messages the processor handles concurrently. Defaults to 1, i.e. messages are
+// handled one after the other.
+func (s *SqsEventProcessor) WithConcurrency(n int) *SqsEventProcessor {
+	s.limiter = newConcurrencyLimiter(max(n, 1))
+	s.adaptive = nil
+	return s
+}
+
+// WithAdaptiveConcurrency makes the processor adjust the number of messages it handles concurrently, AIMD-style:
+// after each interval without congestion the concurrency is increased by a constant, and after an interval in
+// which the average handler latency or the error rate exceeded their targets it is decreased by a factor.
+func (s *SqsEventProcessor) WithAdaptiveConcurrency(cfg AdaptiveConcurrency) *SqsEventProcessor {
+	s.adaptive = newAIMDController(cfg, time.Now)
+	s.limiter = newConcurrencyLimiter(s.adaptive.initial())
+	return s
+}
+
+// Concurrency returns the current concurrency limit of the processor and the number of messages in flight.
+func (s *SqsEventProcessor) Concurrency() ConcurrencyStats {
+	limiter := s.concurrencyLimiter()
+	limit, inFlight := limiter.stats()
+
+	stats := ConcurrencyStats{Limit: limit, InFlight: inFlight, MinLimit: limit, MaxLimit: limit}
+	if s.adaptive != nil {
+		stats.MinLimit = s.adaptive.cfg.MinConcurrency
+		stats.MaxLimit = s.adaptive.cfg.MaxConcurrency
+	}
+	return stats
+}
+
+// concurrencyLimiter returns the processor's limiter, creating the default one for processors that were not
+// configured with WithConcurrency or WithAdaptiveConcurrency.
+func (s *SqsEventProcessor) concurrencyLimiter() *concurrencyLimiter {
+	s.limiterOnce.Do(func() {
+		if s.limiter == nil {
+			s.limiter = newConcurrencyLimiter(1)
+		}
+	})
+	return s.limiter
+}
+
+// observeHandler feeds the outcome of a handler invocation to the adaptive concurrency controller, if any, and
+// applies the resulting limit.
+func (s *SqsEventProcessor) observeHandler(latency time.Duration, err error) {
+	if s.adaptive == nil {
+		return
+	}
+	limiter := s.concurrencyLimiter()
+	limit, _ := limiter.stats()
+	if newLimit, ok := s.adaptive.observe(limit, latency, err); ok {
+		s.logger.Debug().Int("previous_limit", limit).Int("limit", newLimit).Msg("Adjusting concurrency")
+		limiter.setLimit(newLimit)
+	}
+}
+
+// concurrencyLimiter is a semaphore whose size can change while it is in use.
+type concurrencyLimiter struct {
+	mu       sync.Mutex
+	limit    int
+	inFlight int
+
+	// changed is closed and replaced whenever slots may have become free.
+	changed chan struct{}
+}
+
+func newConcurrencyLimiter(limit int) *concurrencyLimiter {
+	return &concurrencyLimiter{limit: limit, changed: make(chan struct{})}
+}
+
+// acquire blocks until at least one slot is free and takes up to n free slots, returning how many were taken. It
+// returns false if ctx is cancelled first.
+func (l *concurrencyLimiter) acquire(ctx context.Context, n int) (int, bool) {
+	for {
+		l.mu.Lock()
+		if free := l.limit - l.inFlight; free > 0 {
+			taken := min(n, free)
+			l.inFlight += taken
+			l.mu.Unlock()
+			return taken, true
+		}
+		changed := l.changed
+		l.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return 0, false
+		case <-changed:
+		}
+	}
+}
+
+// release frees n slots. A negative n takes slots beyond the limit, e.g. for messages received in excess.
+func (l *concurrencyLimiter) release(n int) {
+	if n == 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.inFlight -= n
+	l.notify()
+}
+
+// setLimit changes the number of slots. Lowering it doesn't affect the slots already taken.
+func (l *concurrencyLimiter) setLimit(limit int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.limit = max(limit, 1)
+	l.notify()
+}
+
+func (l *concurrencyLimiter) stats() (limit, inFlight int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limit, l.inFlight
+}
+
+// notify wakes up the callers waiting in acquire. It must be called with l.mu held.
+func (l *concurrencyLimiter) notify() {
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// aimdController computes concurrency limits with additive increase and multiplicative decrease, based on the
+// latency and error rate of the handler invocations observed over fixed windows.
+type aimdController struct {
+	cfg AdaptiveConcurrency
+	now func() time.Time
+
+	mu           sync.Mutex
+	windowStart  time.Time
+	count        int
+	failures     int
+	totalLatency time.Duration
+}
+
+func newAIMDController(cfg AdaptiveConcurrency, now func() time.Time) *aimdController {
+	cfg.MinConcurrency = max(cfg.MinConcurrency, 1)
+	cfg.MaxConcurrency = max(cfg.MaxConcurrency, cfg.MinConcurrency)
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaultAdaptiveInterval
+	}
+	if cfg.Increase <= 0 {
+		cfg.Increase = 1
+	}
+	if cfg.DecreaseFactor <= 0 || cfg.DecreaseFactor >= 1 {
+		cfg.DecreaseFactor = defaultAdaptiveDecreaseFactor
+	}
+
+	return &aimdController{cfg: cfg, now: now, windowStart: now()}
+}
+
+// initial returns the concurrency to start with.
+func (c *aimdController) initial() int {
+	if c.cfg.InitialConcurrency <= 0 {
+		return c.cfg.MinConcurrency
+	}
+	return min(max(c.cfg.InitialConcurrency, c.cfg.MinConcurrency), c.cfg.MaxConcurrency)
+}
+
+// observe records a handler invocation. When the current window is over, it returns the limit that should replace
+// limit and true.
+func (c *aimdController) observe(limit int, latency time.Duration, err error) (int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.count++
+	c.totalLatency += latency
+	if err != nil {
+		c.failures++
+	}
+
+	now := c.now()
+	if now.Sub(c.windowStart) < c.cfg.Interval {
+		return 0, false
+	}
+
+	congested := false
+	if c.cfg.TargetLatency > 0 && c.totalLatency/time.Duration(c.count) > c.cfg.TargetLatency {
+		congested = true
+	}
+	if c.cfg.MaxErrorRate > 0 && float64(c.failures)/float64(c.count) > c.cfg.MaxErrorRate {
+		congested = true
+	}
+
+	c.windowStart = now
+	c.count, c.failures, c.totalLatency = 0, 0, 0
+
+	newLimit := limit + c.cfg.Increase
+	if congested {
+		newLimit = int(float64(limit) * c.cfg.DecreaseFactor)
+	}
+	return min(max(newLimit, c.cfg.MinConcurrency), c.cfg.MaxConcurrency), true
+}
+
+// handlerTracker tracks the start times of the handler invocations in flight.
+type handlerTracker struct {
+	mu      sync.Mutex
+	next    uint64
+	started map[uint64]time.Time
+}
+
+// start records the start of a handler invocation and returns its id.
+func (t *handlerTracker) start() uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.started == nil {
+		t.started = make(map[uint64]time.Time)
+	}
+	t.next++
+	t.started[t.next] = time.Now()
+	return t.next
+}
+
+// done records the end of the handler invocation with the given id.
+func (t *handlerTracker) done(id uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.started, id)
+}
+
+// oldest returns the start time of the oldest handler invocation in flight, or the zero time if there is none.
+func (t *handlerTracker) oldest() time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var oldest time.Time
+	for _, started := range t.started {
+		if oldest.IsZero() || started.Before(oldest) {
+			oldest = started
+		}
+	}
+	return oldest
+}
@@ -0,0 +1,118 @@
+package sub
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock that only moves when advanced.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func TestAIMDController(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	controller := newAIMDController(AdaptiveConcurrency{
+		MinConcurrency: 2,
+		MaxConcurrency: 8,
+		TargetLatency:  100 * time.Millisecond,
+		MaxErrorRate:   0.2,
+		Interval:       time.Second,
+	}, clock.Now)
+
+	limit := controller.initial()
+	if limit != 2 {
+		t.Fatalf("initial limit = %d, want 2", limit)
+	}
+
+	// observe a window of invocations and return the limit computed at its end.
+	window := func(latency time.Duration, failures int) int {
+		t.Helper()
+
+		for i := range 10 {
+			var err error
+			if i < failures {
+				err = errors.New("handler failed")
+			}
+			if _, ok := controller.observe(limit, latency, err); ok {
+				t.Fatalf("limit changed before the end of the window")
+			}
+		}
+		clock.Advance(time.Second)
+
+		newLimit, ok := controller.observe(limit, latency, nil)
+		if !ok {
+			t.Fatalf("limit unchanged at the end of the window")
+		}
+		return newLimit
+	}
+
+	steps := []struct {
+		name     string
+		latency  time.Duration
+		failures int
+		want     int
+	}{
+		{name: "healthy increases", latency: 10 * time.Millisecond, want: 3},
+		{name: "healthy increases again", latency: 10 * time.Millisecond, want: 4},
+		{name: "slow decreases", latency: 200 * time.Millisecond, want: 2},
+		{name: "never below min", latency: 200 * time.Millisecond, want: 2},
+		{name: "recovers", latency: 10 * time.Millisecond, want: 3},
+		{name: "errors decrease", latency: 10 * time.Millisecond, failures: 5, want: 2},
+	}
+	for _, step := range steps {
+		limit = window(step.latency, step.failures)
+		if limit != step.want {
+			t.Fatalf("%s: limit = %d, want %d", step.name, limit, step.want)
+		}
+	}
+
+	for range 10 {
+		limit = window(time.Millisecond, 0)
+	}
+	if limit != 8 {
+		t.Fatalf("limit = %d, want it capped at 8", limit)
+	}
+}
+
+func TestConcurrencyLimiter(t *testing.T) {
+	limiter := newConcurrencyLimiter(3)
+
+	n, ok := limiter.acquire(context.Background(), 10)
+	if !ok || n != 3 {
+		t.Fatalf("acquire = %d, %t, want 3, true", n, ok)
+	}
+
+	acquired := make(chan int)
+	go func() {
+		n, _ := limiter.acquire(context.Background(), 10)
+		acquired <- n
+	}()
+
+	select {
+	case n := <-acquired:
+		t.Fatalf("acquired %d slots while none were free", n)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	limiter.setLimit(5)
+	if n := <-acquired; n != 2 {
+		t.Fatalf("acquired %d slots after raising the limit, want 2", n)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, ok := limiter.acquire(ctx, 1); ok {
+		t.Fatal("acquire succeeded with a cancelled context and no free slots")
+	}
+}
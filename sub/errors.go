@@ -0,0 +1,42 @@
+package sub
+
+import (
+	"errors"
+)
+
+// ErrHandlerPanicked is wrapped by the error of the messages whose handler panicked. The processor recovers the
+// panic and releases the messages back to the queue, like for any other handler error.
+var ErrHandlerPanicked = errors.New("handler panicked")
+
+// ErrUnsupportedOption is wrapped by the error Run returns when the processor was created with options it doesn't
+// support, e.g. WithCoalescing for a batch processor.
+var ErrUnsupportedOption = errors.New("unsupported option")
+
+// PermanentError marks a failure that retrying the message can't fix, such as a message that can't be decoded or
+// whose signature is invalid. The processor deletes messages that fail permanently instead of releasing them back
+// to the queue.
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return "permanent failure: " + e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// Permanent wraps err in a PermanentError. Handlers can return it to have the processor drop a message.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &PermanentError{Err: err}
+}
+
+// IsPermanent reports whether err is, or wraps, a PermanentError.
+func IsPermanent(err error) bool {
+	var permanent *PermanentError
+	return errors.As(err, &permanent)
+}
@@ -2,8 +2,14 @@ package sub
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/Iknite-Space/psss/signing"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
 	awstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
@@ -25,6 +31,38 @@ type SqsEventProcessor struct {
 	queueURL  string
 	handlerFn SqsHandlerFn
 	logger    zerolog.Logger
+	verifier  *signing.Verifier
+
+	// configErr is returned by Run when the processor was created with unsupported options.
+	configErr error
+
+	receiveBackoff     time.Duration
+	maxReceiveBackoff  time.Duration
+	maxReceiveFailures int
+	receiveErrorHook   ReceiveErrorHookFn
+	rateLimiter        *tokenBucket
+
+	batchHandlerFn SqsBatchHandlerFn
+	batchSize      int
+	batchWait      time.Duration
+
+	limiter     *concurrencyLimiter
+	limiterOnce sync.Once
+	adaptive    *aimdController
+
+	// runningSince and lastReceive are the times at which Run was started and the last ReceiveMessage call
+	// succeeded, in unix nanoseconds. They are 0 when Run is not running and no messages were received yet,
+	// respectively.
+	runningSince atomic.Int64
+	lastReceive  atomic.Int64
+	handlers     handlerTracker
+	gate         pauseGate
+
+	statsMu       sync.Mutex
+	processed     int64
+	failed        int64
+	lastError     string
+	lastErrorTime time.Time
 }
 
 // NewEventSqsProcessor creates a new SqsEventProcessor.
@@ -47,62 +85,293 @@ func (s *SqsEventProcessor) WithLogger(logger zerolog.Logger) *SqsEventProcessor
 	return s
 }
 
+// RunningSince returns the time at which Run was started, or the zero time if Run is not running.
+func (s *SqsEventProcessor) RunningSince() time.Time {
+	return unixNanoTime(s.runningSince.Load())
+}
+
+// LastReceive returns the time of the last successful ReceiveMessage call, or the zero time if there was none yet.
+func (s *SqsEventProcessor) LastReceive() time.Time {
+	return unixNanoTime(s.lastReceive.Load())
+}
+
+// HandlingSince returns the time at which the handler started handling the oldest message in flight, or the zero
+// time if no message is being handled.
+func (s *SqsEventProcessor) HandlingSince() time.Time {
+	return s.handlers.oldest()
+}
+
+func unixNanoTime(nanos int64) time.Time {
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
+
+// prepareMessage undoes the transport level transformations applied by the publisher, such as compression, so
+// that the handler function receives the message as it was serialized, and verifies the message signature if the
+// processor has a verifier, see signatureError.
+func (s *SqsEventProcessor) prepareMessage(message awstypes.Message) (awstypes.Message, error) {
+	message, err := decompressMessage(message)
+	if err != nil {
+		return message, fmt.Errorf("failed to decompress message: %w", err)
+	}
+
+	if s.verifier != nil {
+		err = verifyMessage(s.verifier, message)
+		if err != nil {
+			return message, signatureError(err)
+		}
+	}
+
+	return message, nil
+}
+
 // Run starts the processor and begins reading messages from the SQS queue. For each message, it passes the message
-// to the handler function. If the handler function returns an error, the message will be released back to the queue.
+// to the handler function. If the handler function returns an error, the message will be released back to the queue,
+// unless the error is permanent (see Permanent), in which case the message is logged and deleted.
 // If the handler function returns nil, then the message will be deleted from the queue. The processor will continue
 // running until the context is cancelled. Retries for failed messages are handled by SQS and the deadletter
 // configuration of the queue.
+// Messages are only received when a worker is free to handle them, see WithConcurrency, and Run waits for the
+// messages in flight to be handled before returning.
+// Transient ReceiveMessage failures, such as throttling or network errors, are retried with a capped exponential
+// backoff, see WithReceiveBackoff and WithMaxReceiveFailures. Run returns an error on fatal failures, see
+// IsFatalReceiveError, or once the maximum number of consecutive failures is reached. It returns an error wrapping
+// ErrUnsupportedOption right away if the processor was created with options it doesn't support.
 func (s *SqsEventProcessor) Run(ctx context.Context) error {
+	if s.configErr != nil {
+		return s.configErr
+	}
+
+	s.runningSince.Store(time.Now().UnixNano())
+	defer s.runningSince.Store(0)
+
+	limiter := s.concurrencyLimiter()
+	var workers sync.WaitGroup
+	defer workers.Wait()
+
+	stop := func() error {
+		s.logger.Info().Msg("Stopping SQS mutation event processor...")
+		return nil
+	}
+
+	consecutiveFailures := 0
 	for {
 		select {
 		case <-ctx.Done():
-			s.logger.Info().Msg("Stopping SQS mutation event processor...")
-			return nil
+			return stop()
 		default:
 		}
 
-		out, err := s.svc.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
-			MaxNumberOfMessages: 1,
-			QueueUrl:            aws.String(s.queueURL),
-			WaitTimeSeconds:     defaultWaitTimeSeconds,
-			VisibilityTimeout:   defaultVisibilityTimeout,
-		})
+		// wait while the processor is paused. The receive context is cancelled when it is paused again.
+		receiveCtx, cancelReceive, ok := s.gate.receiveContext(ctx)
+		if !ok {
+			return stop()
+		}
+		messages, slots, err := s.receive(receiveCtx, limiter)
+		cancelReceive()
+		if len(messages) > 0 {
+			// dispatch the messages received before an error too, e.g. those of a partially collected batch.
+			s.dispatch(ctx, &workers, limiter, messages, slots)
+		} else {
+			limiter.release(slots)
+		}
+
 		if err != nil {
-			return fmt.Errorf("failed to receive message: %w", err)
+			if ctx.Err() != nil {
+				return stop()
+			}
+			if receiveCtx.Err() != nil {
+				// the processor was paused.
+				continue
+			}
+
+			consecutiveFailures++
+			fatal := IsFatalReceiveError(err)
+			if s.receiveErrorHook != nil {
+				s.receiveErrorHook(err, consecutiveFailures, fatal)
+			}
+			if fatal {
+				return fmt.Errorf("failed to receive message: %w", err)
+			}
+			if s.maxReceiveFailures > 0 && consecutiveFailures >= s.maxReceiveFailures {
+				return fmt.Errorf("failed to receive message %d consecutive times: %w", consecutiveFailures, err)
+			}
+
+			delay := s.receiveBackoffDelay(consecutiveFailures)
+			s.logger.Warn().Err(err).Int("consecutive_failures", consecutiveFailures).Dur("retry_in", delay).
+				Msg("Error receiving messages from SQS")
+			if !sleep(ctx, delay) {
+				return stop()
+			}
+			continue
 		}
+		consecutiveFailures = 0
+		s.lastReceive.Store(time.Now().UnixNano())
 
 		// no messages, lets wait a bit before retrying for more messages.
-		if len(out.Messages) < 1 {
+		if len(messages) < 1 {
 			s.logger.Debug().Msgf("No messages received from SQS. Retrying now")
-			continue
 		}
+	}
+}
+
+// receive receives as many messages as there are free workers to handle them and the rate limit allows, or a
+// batch of messages when the processor has a batch handler. It returns the number of worker slots taken from
+// limiter, which must be released once the messages are handled. Messages received before an error are returned
+// along with it.
+func (s *SqsEventProcessor) receive(ctx context.Context, limiter *concurrencyLimiter) ([]awstypes.Message, int, error) {
+	if s.batchHandlerFn != nil {
+		return s.receiveBatch(ctx, limiter)
+	}
+
+	slots, ok := limiter.acquire(ctx, maxReceiveMessages)
+	if !ok {
+		return nil, 0, ctx.Err()
+	}
+
+	n, err := s.rateLimit(ctx, slots)
+	if err != nil {
+		return nil, slots, err
+	}
+
+	out, err := s.svc.ReceiveMessage(ctx, s.receiveMessageInput(n, defaultWaitTimeSeconds))
+	if err != nil {
+		return nil, slots, err
+	}
+	s.takeTokens(len(out.Messages))
+
+	return out.Messages, slots, nil
+}
+
+// rateLimit blocks until the rate limit allows handling at least one message and returns how many of the n
+// wanted messages it allows.
+func (s *SqsEventProcessor) rateLimit(ctx context.Context, n int) (int, error) {
+	if s.rateLimiter == nil {
+		return n, nil
+	}
+	if !s.rateLimiter.wait(ctx) {
+		return 0, ctx.Err()
+	}
+	return min(n, s.rateLimiter.available()), nil
+}
+
+// takeTokens takes the tokens of n received messages from the rate limit budget.
+func (s *SqsEventProcessor) takeTokens(n int) {
+	if s.rateLimiter != nil {
+		s.rateLimiter.take(n)
+	}
+}
+
+// receiveMessageInput returns the input of a ReceiveMessage call for up to n messages, long polling for up to
+// waitSeconds.
+func (s *SqsEventProcessor) receiveMessageInput(n int, waitSeconds int32) *sqs.ReceiveMessageInput {
+	return &sqs.ReceiveMessageInput{
+		MaxNumberOfMessages: int32(n),
+		QueueUrl:            aws.String(s.queueURL),
+		WaitTimeSeconds:     waitSeconds,
+		VisibilityTimeout:   defaultVisibilityTimeout,
+		// message attributes are needed by the handlers to detect e.g. the content type of raw deliveries.
+		MessageAttributeNames: []string{"All"},
+		// system attributes exposed to the handlers through MessageInfo.
+		MessageSystemAttributeNames: []awstypes.MessageSystemAttributeName{
+			awstypes.MessageSystemAttributeNameApproximateReceiveCount,
+			awstypes.MessageSystemAttributeNameSentTimestamp,
+		},
+	}
+}
+
+// dispatch hands the received messages to workers, which release the given worker slots once they are done. The
+// handlers can log with the logger of the processor through zerolog.Ctx.
+func (s *SqsEventProcessor) dispatch(ctx context.Context, workers *sync.WaitGroup, limiter *concurrencyLimiter, messages []awstypes.Message, slots int) {
+	ctx = s.logger.WithContext(ctx)
+	if s.batchHandlerFn != nil {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			defer limiter.release(slots)
+
+			started := time.Now()
+			err := s.processBatch(ctx, messages)
+			s.observeHandler(time.Since(started), err)
+		}()
+		return
+	}
+
+	// each message takes a slot of its own.
+	limiter.release(slots - len(messages))
+	for _, message := range messages {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			defer limiter.release(1)
+
+			started := time.Now()
+			err := s.processMessage(ctx, message)
+			s.observeHandler(time.Since(started), err)
+			s.recordResult(err)
+		}()
+	}
+}
+
+// callHandler passes the message to the handler function, turning a panic into an error wrapping
+// ErrHandlerPanicked so that a failing handler doesn't crash the process.
+func (s *SqsEventProcessor) callHandler(ctx context.Context, message awstypes.Message) (err error) {
+	id := s.handlers.start()
+	defer s.handlers.done(id)
+	defer func() {
+		if r := recover(); r != nil {
+			s.logger.Error().Str("message_id", aws.ToString(message.MessageId)).Interface("panic", r).
+				Str("stack", string(debug.Stack())).Msg("Handler panicked")
+			err = fmt.Errorf("%w: %v", ErrHandlerPanicked, r)
+		}
+	}()
+	return s.handlerFn(ctx, message)
+}
 
-		for _, message := range out.Messages {
-			messageID := ""
-			if message.MessageId != nil {
-				messageID = *message.MessageId
-			}
+// processMessage prepares the message and passes it to the handler function. The message is deleted from the
+// queue when the handler succeeds, or when the message failed permanently since retrying it would be pointless.
+// It returns the error the message failed with, if any.
+func (s *SqsEventProcessor) processMessage(ctx context.Context, message awstypes.Message) error {
+	messageID := ""
+	if message.MessageId != nil {
+		messageID = *message.MessageId
+	}
 
-			if message.ReceiptHandle == nil {
-				s.logger.Error().Str("message_id", messageID).Msg("Message has no receipt handle, cannot delete")
-				continue
-			}
-			messageHandle := *message.ReceiptHandle
+	if message.ReceiptHandle == nil {
+		s.logger.Error().Str("message_id", messageID).Msg("Message has no receipt handle, cannot delete")
+		return errors.New("message has no receipt handle")
+	}
+	messageHandle := *message.ReceiptHandle
 
-			err = s.handlerFn(ctx, message)
-			if err != nil {
-				// Note:    This is a debug message because "true" errors should be logged by the handling function.
-				s.logger.Debug().Err(err).Str("message_id", messageID).Msg("Error processing message")
-				continue
-			}
-			_, err = s.svc.DeleteMessage(ctx, &sqs.DeleteMessageInput{
-				QueueUrl:      &s.queueURL,
-				ReceiptHandle: aws.String(messageHandle),
-			})
-			if err != nil {
-				s.logger.Error().Err(err).Str("message_id", messageID).Msg("Error deleting message. Warning this " +
-					"message will likely get reprocessed")
-			} 
+	message, err := s.prepareMessage(message)
+	if err != nil {
+		if !IsPermanent(err) {
+			s.logger.Error().Err(err).Str("message_id", messageID).Msg("Error preparing message")
+			return err
 		}
+	} else {
+		err = s.callHandler(WithMessageInfo(ctx, sqsMessageInfo(message)), message)
 	}
+
+	if err != nil {
+		if !IsPermanent(err) {
+			// Note:    This is a debug message because "true" errors should be logged by the handling function.
+			s.logger.Debug().Err(err).Str("message_id", messageID).Msg("Error processing message")
+			return err
+		}
+		s.logger.Error().Err(err).Str("message_id", messageID).Msg("Message failed permanently, deleting it")
+	}
+
+	_, deleteErr := s.svc.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      &s.queueURL,
+		ReceiptHandle: aws.String(messageHandle),
+	})
+	if deleteErr != nil {
+		s.logger.Error().Err(deleteErr).Str("message_id", messageID).Msg("Error deleting message. Warning this " +
+			"message will likely get reprocessed")
+	}
+
+	return err
 }
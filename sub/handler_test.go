@@ -0,0 +1,135 @@
+package sub
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	awstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// testQueueURL is the URL of the queue of the processors under test.
+const testQueueURL = "https://sqs.us-east-1.amazonaws.com/123456789012/notes"
+
+// fakeSqs records the calls of an SQS client instead of sending them. The messages in its queue are returned by
+// ReceiveMessage, at most receiveLimit at a time if it is set, and deleteBatch, when set, returns the output of
+// DeleteMessageBatch.
+type fakeSqs struct {
+	mu           sync.Mutex
+	queue        []awstypes.Message
+	receiveLimit int
+	receives     int
+	deleted      []string
+	deleteCalls  int
+	deleteBatch  func(input *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error)
+}
+
+// newTestMessage returns a message with the given ID and body, received with the receipt handle "handle-<id>".
+func newTestMessage(id int, body string) awstypes.Message {
+	return awstypes.Message{
+		MessageId:     aws.String(strconv.Itoa(id)),
+		ReceiptHandle: aws.String("handle-" + strconv.Itoa(id)),
+		Body:          aws.String(body),
+	}
+}
+
+// client returns an SQS client backed by the fake.
+func (f *fakeSqs) client() *sqs.Client {
+	fake := middleware.InitializeMiddlewareFunc("fake", func(ctx context.Context, in middleware.InitializeInput, _ middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		switch input := in.Parameters.(type) {
+		case *sqs.ReceiveMessageInput:
+			f.receives++
+			n := min(len(f.queue), int(input.MaxNumberOfMessages))
+			if f.receiveLimit > 0 {
+				n = min(n, f.receiveLimit)
+			}
+			messages := f.queue[:n]
+			f.queue = f.queue[n:]
+			return middleware.InitializeOutput{Result: &sqs.ReceiveMessageOutput{Messages: messages}}, middleware.Metadata{}, nil
+		case *sqs.DeleteMessageInput:
+			f.deleteCalls++
+			f.deleted = append(f.deleted, aws.ToString(input.ReceiptHandle))
+			return middleware.InitializeOutput{Result: &sqs.DeleteMessageOutput{}}, middleware.Metadata{}, nil
+		case *sqs.DeleteMessageBatchInput:
+			f.deleteCalls++
+			if f.deleteBatch != nil {
+				out, err := f.deleteBatch(input)
+				return middleware.InitializeOutput{Result: out}, middleware.Metadata{}, err
+			}
+			out := &sqs.DeleteMessageBatchOutput{}
+			for _, entry := range input.Entries {
+				f.deleted = append(f.deleted, aws.ToString(entry.ReceiptHandle))
+				out.Successful = append(out.Successful, awstypes.DeleteMessageBatchResultEntry{Id: entry.Id})
+			}
+			return middleware.InitializeOutput{Result: out}, middleware.Metadata{}, nil
+		default:
+			return middleware.InitializeOutput{}, middleware.Metadata{}, errors.New("unexpected call")
+		}
+	})
+
+	return sqs.New(sqs.Options{
+		Region: "us-east-1",
+		APIOptions: []func(*middleware.Stack) error{
+			func(stack *middleware.Stack) error {
+				return stack.Initialize.Add(fake, middleware.Before)
+			},
+		},
+	})
+}
+
+// receiveCount returns the number of ReceiveMessage calls.
+func (f *fakeSqs) receiveCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.receives
+}
+
+// deletedHandles returns the receipt handles of the deleted messages.
+func (f *fakeSqs) deletedHandles() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.deleted...)
+}
+
+func TestProcessMessageRecoversPanic(t *testing.T) {
+	fake := &fakeSqs{}
+	processor := NewSqsEventProcessor(fake.client(), testQueueURL,
+		func(context.Context, awstypes.Message) error { panic("handler bug") })
+
+	err := processor.processMessage(context.Background(), newTestMessage(1, "{}"))
+	if !errors.Is(err, ErrHandlerPanicked) {
+		t.Errorf("processMessage() = %v, want ErrHandlerPanicked", err)
+	}
+	if IsPermanent(err) {
+		t.Errorf("processMessage() = %v, want a retryable error", err)
+	}
+	// the message is released back to the queue.
+	if deleted := fake.deletedHandles(); len(deleted) != 0 {
+		t.Errorf("deleted %v, want no deletion", deleted)
+	}
+	if since := processor.HandlingSince(); !since.IsZero() {
+		t.Errorf("HandlingSince() = %v after the panic, want no running handler", since)
+	}
+}
+
+func TestProcessBatchRecoversPanic(t *testing.T) {
+	fake := &fakeSqs{}
+	processor := NewSqsBatchEventProcessor(fake.client(), testQueueURL,
+		func(context.Context, []awstypes.Message) BatchResult { panic("handler bug") })
+
+	err := processor.processBatch(context.Background(), []awstypes.Message{newTestMessage(1, "{}"), newTestMessage(2, "{}")})
+	if !errors.Is(err, ErrHandlerPanicked) {
+		t.Errorf("processBatch() = %v, want ErrHandlerPanicked", err)
+	}
+	if deleted := fake.deletedHandles(); len(deleted) != 0 {
+		t.Errorf("deleted %v, want no deletion", deleted)
+	}
+}
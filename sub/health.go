@@ -0,0 +1,169 @@
+package sub
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	// defaultMaxPollAge is longer than a long poll plus a handler running up to the default visibility timeout, the
+	// longest a healthy processor goes without a successful ReceiveMessage call.
+	defaultMaxPollAge      = 2 * time.Minute
+	defaultHandlerDeadline = defaultVisibilityTimeout * time.Second
+)
+
+// ProcessorHealth is the health of a processor as reported by HealthHandler.
+type ProcessorHealth struct {
+	ProcessorStatus
+
+	Healthy bool `json:"healthy"`
+
+	// Reason explains why the processor is unhealthy.
+	Reason string `json:"reason,omitempty"`
+}
+
+// HealthReport is the JSON body written by HealthHandler.
+type HealthReport struct {
+	Healthy    bool              `json:"healthy"`
+	Processors []ProcessorHealth `json:"processors"`
+}
+
+// HealthHandler is an http.Handler reporting the health of processors, e.g. for Kubernetes liveness and readiness
+// probes. A processor is healthy when it is running and either had a successful ReceiveMessage call recently, or is
+// paused, or is handling a message for less than the handler deadline. The handler responds with 200 OK when all
+// processors are healthy and 503 Service Unavailable otherwise, along with a JSON HealthReport.
+type HealthHandler struct {
+	processors      []namedProcessor
+	supervisors     []*Supervisor
+	maxPollAge      time.Duration
+	handlerDeadline time.Duration
+	now             func() time.Time
+}
+
+type namedProcessor struct {
+	name      string
+	processor *SqsEventProcessor
+}
+
+var _ http.Handler = (*HealthHandler)(nil)
+
+// NewHealthHandler creates a HealthHandler without processors.
+func NewHealthHandler() *HealthHandler {
+	return &HealthHandler{
+		maxPollAge:      defaultMaxPollAge,
+		handlerDeadline: defaultHandlerDeadline,
+		now:             time.Now,
+	}
+}
+
+// WithProcessor adds a processor run outside of a Supervisor to the report.
+func (h *HealthHandler) WithProcessor(name string, processor *SqsEventProcessor) *HealthHandler {
+	h.processors = append(h.processors, namedProcessor{name: name, processor: processor})
+	return h
+}
+
+// WithSupervisor adds all processors of a Supervisor to the report.
+func (h *HealthHandler) WithSupervisor(supervisor *Supervisor) *HealthHandler {
+	h.supervisors = append(h.supervisors, supervisor)
+	return h
+}
+
+// WithMaxPollAge sets how long a processor that isn't handling a message may go without a successful
+// ReceiveMessage call before it is reported unhealthy. Defaults to 2m.
+func (h *HealthHandler) WithMaxPollAge(maxPollAge time.Duration) *HealthHandler {
+	h.maxPollAge = maxPollAge
+	return h
+}
+
+// WithHandlerDeadline sets how long a processor may handle a single message before it is reported unhealthy.
+// Defaults to the visibility timeout of received messages, after which they are redelivered anyway.
+func (h *HealthHandler) WithHandlerDeadline(deadline time.Duration) *HealthHandler {
+	h.handlerDeadline = deadline
+	return h
+}
+
+// Report returns the health of all processors.
+func (h *HealthHandler) Report() HealthReport {
+	var statuses []ProcessorStatus
+	for _, p := range h.processors {
+		statuses = append(statuses, processorStatus(p.name, p.processor))
+	}
+	for _, supervisor := range h.supervisors {
+		statuses = append(statuses, supervisor.Status()...)
+	}
+
+	now := h.now()
+	report := HealthReport{Healthy: true, Processors: make([]ProcessorHealth, 0, len(statuses))}
+	for _, status := range statuses {
+		health := h.check(now, status)
+		report.Healthy = report.Healthy && health.Healthy
+		report.Processors = append(report.Processors, health)
+	}
+	return report
+}
+
+// ServeHTTP implements http.Handler.
+func (h *HealthHandler) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	report := h.Report()
+
+	status := http.StatusOK
+	if !report.Healthy {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, report)
+}
+
+// check evaluates the health of a processor at now.
+func (h *HealthHandler) check(now time.Time, status ProcessorStatus) ProcessorHealth {
+	health := ProcessorHealth{ProcessorStatus: status, Healthy: true}
+
+	switch {
+	case status.State != ProcessorStateRunning:
+		health.Healthy = false
+		health.Reason = fmt.Sprintf("processor is %s", status.State)
+	case !status.HandlingSince.IsZero():
+		if handling := now.Sub(status.HandlingSince); handling > h.handlerDeadline {
+			health.Healthy = false
+			health.Reason = fmt.Sprintf("handler is running for %s, past its deadline of %s", handling.Round(time.Second), h.handlerDeadline)
+		}
+	case status.Paused:
+		// a paused processor doesn't poll on purpose.
+	default:
+		// a processor that was just (re)started gets the same grace period as one that just polled.
+		lastPoll := status.LastReceive
+		if lastPoll.Before(status.RunningSince) {
+			lastPoll = status.RunningSince
+		}
+		if age := now.Sub(lastPoll); age > h.maxPollAge {
+			health.Healthy = false
+			health.Reason = fmt.Sprintf("no successful poll for %s", age.Round(time.Second))
+		}
+	}
+
+	return health
+}
+
+// processorStatus returns the status of a processor run outside of a Supervisor.
+func processorStatus(name string, processor *SqsEventProcessor) ProcessorStatus {
+	status := ProcessorStatus{
+		Name:          name,
+		State:         ProcessorStateStopped,
+		RunningSince:  processor.RunningSince(),
+		LastReceive:   processor.LastReceive(),
+		HandlingSince: processor.HandlingSince(),
+		Paused:        processor.Paused(),
+	}
+	if !status.RunningSince.IsZero() {
+		status.State = ProcessorStateRunning
+	}
+	return status
+}
+
+// writeJSON writes v as the JSON body of a response with the given status code.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
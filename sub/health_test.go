@@ -0,0 +1,130 @@
+package sub
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHealthCheck(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	handler := NewHealthHandler().WithMaxPollAge(time.Minute).WithHandlerDeadline(30 * time.Second)
+
+	running := func(status ProcessorStatus) ProcessorStatus {
+		status.Name = "notes"
+		status.State = ProcessorStateRunning
+		if status.RunningSince.IsZero() {
+			status.RunningSince = now.Add(-time.Hour)
+		}
+		return status
+	}
+
+	tests := []struct {
+		name       string
+		status     ProcessorStatus
+		want       bool
+		wantReason string
+	}{
+		{name: "recent poll", status: running(ProcessorStatus{LastReceive: now.Add(-10 * time.Second)}), want: true},
+		{name: "old poll", status: running(ProcessorStatus{LastReceive: now.Add(-2 * time.Minute)}), wantReason: "no successful poll for 2m0s"},
+		{name: "never polled", status: running(ProcessorStatus{}), wantReason: "no successful poll for 1h0m0s"},
+		// a processor that was just restarted isn't judged on the polls of its previous run.
+		{name: "just restarted", status: running(ProcessorStatus{RunningSince: now.Add(-time.Second), LastReceive: now.Add(-time.Hour)}), want: true},
+		{name: "handling", status: running(ProcessorStatus{HandlingSince: now.Add(-10 * time.Second)}), want: true},
+		{name: "handler past its deadline", status: running(ProcessorStatus{HandlingSince: now.Add(-time.Minute)}), wantReason: "handler is running for 1m0s, past its deadline of 30s"},
+		{name: "paused", status: running(ProcessorStatus{Paused: true}), want: true},
+		{name: "backing off", status: ProcessorStatus{Name: "notes", State: ProcessorStateBackingOff}, wantReason: "processor is backing_off"},
+		{name: "stopped", status: ProcessorStatus{Name: "notes", State: ProcessorStateStopped}, wantReason: "processor is stopped"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			health := handler.check(now, test.status)
+			if health.Healthy != test.want || health.Reason != test.wantReason {
+				t.Errorf("check() = healthy %v, reason %q, want %v, %q", health.Healthy, health.Reason, test.want, test.wantReason)
+			}
+		})
+	}
+}
+
+func TestHealthHandler(t *testing.T) {
+	serve := func(handler *HealthHandler) (int, HealthReport) {
+		t.Helper()
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+		if contentType := w.Header().Get("Content-Type"); contentType != "application/json" {
+			t.Errorf("Content-Type = %q, want application/json", contentType)
+		}
+		var report HealthReport
+		err := json.Unmarshal(w.Body.Bytes(), &report)
+		if err != nil {
+			t.Fatalf("invalid body %s: %v", w.Body, err)
+		}
+		return w.Code, report
+	}
+
+	// no processors to check.
+	code, report := serve(NewHealthHandler())
+	if code != http.StatusOK || !report.Healthy || len(report.Processors) != 0 {
+		t.Errorf("empty handler = %d %+v, want 200 and healthy", code, report)
+	}
+
+	// a processor that isn't running.
+	stopped := NewSqsEventProcessor(nil, testQueueURL, nil)
+	code, report = serve(NewHealthHandler().WithProcessor("notes", stopped))
+	if code != http.StatusServiceUnavailable || report.Healthy {
+		t.Errorf("stopped processor = %d, healthy %v, want 503 and unhealthy", code, report.Healthy)
+	}
+	if len(report.Processors) != 1 || report.Processors[0].Name != "notes" || report.Processors[0].Reason != "processor is stopped" {
+		t.Errorf("stopped processor report = %+v", report.Processors)
+	}
+
+	// a running processor of a supervisor, which reports its last error.
+	supervisor := NewSupervisor()
+	err := supervisor.Add("folders", blockingRunner())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := runSupervisor(t, supervisor)
+	defer stop()
+	waitFor(t, "the processor to start", func() bool {
+		return statusOf(supervisor, "folders").State == ProcessorStateRunning
+	})
+	supervisor.mu.Lock()
+	supervisor.processors[0].lastError = errors.New("receive failed")
+	supervisor.mu.Unlock()
+
+	code, report = serve(NewHealthHandler().WithSupervisor(supervisor))
+	if code != http.StatusOK || !report.Healthy {
+		t.Errorf("running processor = %d %+v, want 200 and healthy", code, report)
+	}
+	if len(report.Processors) != 1 || report.Processors[0].LastError != "receive failed" {
+		t.Errorf("running processor report = %+v, want the last error", report.Processors)
+	}
+
+	// the report is unhealthy as soon as one processor is.
+	code, report = serve(NewHealthHandler().WithSupervisor(supervisor).WithProcessor("notes", stopped))
+	if code != http.StatusServiceUnavailable || report.Healthy || len(report.Processors) != 2 {
+		t.Errorf("mixed processors = %d %+v, want 503 and unhealthy", code, report)
+	}
+}
+
+func TestHealthReportJSON(t *testing.T) {
+	b, err := json.Marshal(ProcessorHealth{
+		ProcessorStatus: ProcessorStatus{Name: "notes", State: ProcessorStateBackingOff, LastError: "receive failed"},
+		Reason:          "processor is backing_off",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"name":"notes"`, `"healthy":false`, `"reason":"processor is backing_off"`, `"last_error":"receive failed"`} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("json.Marshal() = %s, want it to contain %s", b, want)
+		}
+	}
+}
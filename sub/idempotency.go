@@ -0,0 +1,138 @@
+package sub
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/Iknite-Space/psss/models"
+	"github.com/rs/zerolog"
+	"google.golang.org/protobuf/proto"
+)
+
+// ClaimStatus describes the outcome of claiming an event in an IdempotencyStore.
+type ClaimStatus uint8
+
+const (
+	// ClaimAcquired means the caller now owns the event and must either Complete or Release it.
+	ClaimAcquired ClaimStatus = 1
+
+	// ClaimInProgress means another consumer currently owns the event.
+	ClaimInProgress ClaimStatus = 2
+
+	// ClaimCompleted means the event has already been processed successfully.
+	ClaimCompleted ClaimStatus = 3
+)
+
+// ErrEventInProgress is returned by the idempotent handlers when a duplicate of the event is currently being
+// processed by another consumer. The message is released back to the queue, so it will be redelivered after the
+// visibility timeout and skipped then if the other consumer completed it.
+var ErrEventInProgress = errors.New("event is already being processed")
+
+// ErrClaimLost is returned by IdempotencyStore.Complete when the claim expired and the event was claimed again
+// by another consumer in the meantime.
+var ErrClaimLost = errors.New("claim is no longer held")
+
+// IdempotencyStore records which events have been processed so that duplicate deliveries can be skipped.
+// Implementations must be safe for concurrent use, and Claim must be atomic: when several consumers claim the same
+// key at the same time, exactly one of them gets ClaimAcquired.
+//
+// Each claim is identified by a token chosen by the caller, and Complete and Release only act on the claim holding
+// that token. A consumer whose claim expired therefore can't complete or release the claim of the consumer that
+// claimed the event after it.
+type IdempotencyStore interface {
+	// Claim marks the key as being processed by the caller, under token. Claims that are neither completed nor
+	// released expire after a store specific lease, so that a crashed consumer doesn't block the event forever.
+	Claim(ctx context.Context, key, token string) (ClaimStatus, error)
+
+	// Complete marks the key claimed under token as processed. It returns ErrClaimLost if the key was claimed
+	// again under another token.
+	Complete(ctx context.Context, key, token string) error
+
+	// Release drops the claim made under token on a key so that the event can be processed again. It does nothing
+	// if the key is no longer claimed under token.
+	Release(ctx context.Context, key, token string) error
+}
+
+// IdempotentMutationEventHandler wraps a ProtoMutationEventHandlerFn so that each EventID is processed at most once
+// successfully. Duplicates of completed events are skipped, duplicates of events still being processed fail with
+// ErrEventInProgress, and the claim is released when the handler fails so the event can be retried.
+// Events with an empty EventID are passed to the handler without deduplication.
+// Failures to complete a claim are logged with the logger of the context, which the processors set to their own.
+func IdempotentMutationEventHandler[T proto.Message](store IdempotencyStore, handler ProtoMutationEventHandlerFn[T]) ProtoMutationEventHandlerFn[T] {
+	return func(ctx context.Context, event models.ProtoMutationEvent[T]) error {
+		return runIdempotent(ctx, store, event.EventID, func() error {
+			return handler(ctx, event)
+		})
+	}
+}
+
+// IdempotentJSONEventHandler wraps a JSONEventHandlerFn with the same semantics as IdempotentMutationEventHandler.
+// keyFn extracts the event ID from the message. Messages with an empty key are passed to the handler without
+// deduplication.
+func IdempotentJSONEventHandler[T any](store IdempotencyStore, keyFn func(T) string, handler JSONEventHandlerFn[T]) JSONEventHandlerFn[T] {
+	return func(ctx context.Context, message T) error {
+		return runIdempotent(ctx, store, keyFn(message), func() error {
+			return handler(ctx, message)
+		})
+	}
+}
+
+// runIdempotent claims key, runs fn and completes or releases the claim depending on the result.
+func runIdempotent(ctx context.Context, store IdempotencyStore, key string, fn func() error) error {
+	if key == "" {
+		return fn()
+	}
+
+	token, err := newClaimToken()
+	if err != nil {
+		return err
+	}
+
+	status, err := store.Claim(ctx, key, token)
+	if err != nil {
+		return fmt.Errorf("failed to claim event %q: %w", key, err)
+	}
+
+	switch status {
+	case ClaimCompleted:
+		return nil
+	case ClaimInProgress:
+		return fmt.Errorf("event %q: %w", key, ErrEventInProgress)
+	case ClaimAcquired:
+	default:
+		return fmt.Errorf("unknown claim status %d for event %q", status, key)
+	}
+
+	err = fn()
+	if err != nil {
+		// the claim must be released even if the handler context was cancelled.
+		releaseErr := store.Release(context.WithoutCancel(ctx), key, token)
+		if releaseErr != nil {
+			return errors.Join(err, fmt.Errorf("failed to release event %q: %w", key, releaseErr))
+		}
+		return err
+	}
+
+	// Note: the event was handled, so a failure to complete it is not reported back to the processor. Failing
+	// here would requeue the message and guarantee the duplicate we're trying to avoid, whereas the expired claim
+	// only matters if SQS redelivers the message anyway.
+	err = store.Complete(context.WithoutCancel(ctx), key, token)
+	if err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Str("event_id", key).Msg("Failed to complete idempotency claim")
+	}
+
+	return nil
+}
+
+// newClaimToken generates a random token identifying a claim.
+func newClaimToken() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate claim token: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,131 @@
+package sub
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	defaultIdempotencyLease = 5 * time.Minute
+)
+
+// MemoryIdempotencyStore is an in-memory IdempotencyStore that keeps up to a fixed number of keys and evicts the
+// least recently used ones first. Completed keys are forgotten after the configured TTL.
+// It only deduplicates deliveries within a single process, which makes it a good fit for tests and for
+// single replica consumers.
+type MemoryIdempotencyStore struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	lease    time.Duration
+	entries  map[string]*list.Element
+	lru      *list.List
+	now      func() time.Time
+}
+
+type memoryIdempotencyEntry struct {
+	key       string
+	token     string
+	completed bool
+	expiresAt time.Time
+}
+
+var _ IdempotencyStore = (*MemoryIdempotencyStore)(nil)
+
+// NewMemoryIdempotencyStore creates a MemoryIdempotencyStore holding at most capacity keys, each remembered for ttl
+// after it was completed.
+func NewMemoryIdempotencyStore(capacity int, ttl time.Duration) *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{
+		capacity: capacity,
+		ttl:      ttl,
+		lease:    defaultIdempotencyLease,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+		now:      time.Now,
+	}
+}
+
+// WithLeaseDuration sets how long a claim is held before it expires if it is neither completed nor released.
+// It should be longer than the time it takes to handle an event.
+func (m *MemoryIdempotencyStore) WithLeaseDuration(lease time.Duration) *MemoryIdempotencyStore {
+	m.lease = lease
+	return m
+}
+
+// Claim implements IdempotencyStore.
+func (m *MemoryIdempotencyStore) Claim(_ context.Context, key, token string) (ClaimStatus, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	if elem, ok := m.entries[key]; ok {
+		entry := elem.Value.(*memoryIdempotencyEntry)
+		if now.Before(entry.expiresAt) {
+			m.lru.MoveToFront(elem)
+			if entry.completed {
+				return ClaimCompleted, nil
+			}
+			return ClaimInProgress, nil
+		}
+		m.remove(elem)
+	}
+
+	m.entries[key] = m.lru.PushFront(&memoryIdempotencyEntry{
+		key:       key,
+		token:     token,
+		expiresAt: now.Add(m.lease),
+	})
+	for m.capacity > 0 && m.lru.Len() > m.capacity {
+		m.remove(m.lru.Back())
+	}
+
+	return ClaimAcquired, nil
+}
+
+// Complete implements IdempotencyStore.
+func (m *MemoryIdempotencyStore) Complete(_ context.Context, key, token string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	elem, ok := m.entries[key]
+	if !ok {
+		// the claim was evicted while the event was being handled, record it again.
+		elem = m.lru.PushFront(&memoryIdempotencyEntry{key: key, token: token})
+		m.entries[key] = elem
+		for m.capacity > 0 && m.lru.Len() > m.capacity {
+			m.remove(m.lru.Back())
+		}
+	}
+
+	entry := elem.Value.(*memoryIdempotencyEntry)
+	if entry.token != token {
+		return ErrClaimLost
+	}
+	entry.completed = true
+	entry.expiresAt = m.now().Add(m.ttl)
+	m.lru.MoveToFront(elem)
+
+	return nil
+}
+
+// Release implements IdempotencyStore.
+func (m *MemoryIdempotencyStore) Release(_ context.Context, key, token string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if elem, ok := m.entries[key]; ok {
+		entry := elem.Value.(*memoryIdempotencyEntry)
+		if !entry.completed && entry.token == token {
+			m.remove(elem)
+		}
+	}
+
+	return nil
+}
+
+func (m *MemoryIdempotencyStore) remove(elem *list.Element) {
+	m.lru.Remove(elem)
+	delete(m.entries, elem.Value.(*memoryIdempotencyEntry).key)
+}
@@ -0,0 +1,146 @@
+package sub
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	idempotencyStatusProcessing = "processing"
+	idempotencyStatusCompleted  = "completed"
+)
+
+// SQLIdempotencyStore is an IdempotencyStore backed by a SQL table, which lets several consumer replicas share the
+// deduplication state. The table must have a unique constraint on event_id, e.g.:
+//
+//	CREATE TABLE psss_idempotency (
+//		event_id    VARCHAR(255) PRIMARY KEY,
+//		claim_token VARCHAR(64)  NOT NULL,
+//		status      VARCHAR(16)  NOT NULL,
+//		expires_at  TIMESTAMP    NOT NULL
+//	);
+//
+// Concurrent claims are resolved by the primary key: only one INSERT for a given event_id can succeed. Complete
+// and Release only update the row holding the token of the claim.
+// Expired rows are removed lazily when the same event_id is claimed again, so a periodic
+// "DELETE ... WHERE expires_at < now" job is recommended to keep the table small.
+type SQLIdempotencyStore struct {
+	db          *sql.DB
+	table       string
+	ttl         time.Duration
+	lease       time.Duration
+	placeholder func(n int) string
+	now         func() time.Time
+}
+
+var _ IdempotencyStore = (*SQLIdempotencyStore)(nil)
+
+// NewSQLIdempotencyStore creates a SQLIdempotencyStore using the given table, in which completed events are
+// remembered for ttl. The table name is inserted into the queries as is and must not come from untrusted input.
+// The store uses "?" placeholders by default, see WithPlaceholders for other drivers.
+func NewSQLIdempotencyStore(db *sql.DB, table string, ttl time.Duration) *SQLIdempotencyStore {
+	return &SQLIdempotencyStore{
+		db:          db,
+		table:       table,
+		ttl:         ttl,
+		lease:       defaultIdempotencyLease,
+		placeholder: QuestionPlaceholders,
+		now:         time.Now,
+	}
+}
+
+// WithLeaseDuration sets how long a claim is held before it expires if it is neither completed nor released.
+func (s *SQLIdempotencyStore) WithLeaseDuration(lease time.Duration) *SQLIdempotencyStore {
+	s.lease = lease
+	return s
+}
+
+// WithPlaceholders sets the function generating the n-th (1 based) bind parameter of a query.
+func (s *SQLIdempotencyStore) WithPlaceholders(placeholder func(n int) string) *SQLIdempotencyStore {
+	s.placeholder = placeholder
+	return s
+}
+
+// QuestionPlaceholders generates "?" bind parameters, as used by MySQL and SQLite drivers.
+func QuestionPlaceholders(int) string {
+	return "?"
+}
+
+// DollarPlaceholders generates "$n" bind parameters, as used by PostgreSQL drivers.
+func DollarPlaceholders(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+// Claim implements IdempotencyStore.
+func (s *SQLIdempotencyStore) Claim(ctx context.Context, key, token string) (ClaimStatus, error) {
+	now := s.now().UTC()
+
+	_, err := s.db.ExecContext(ctx,
+		fmt.Sprintf("DELETE FROM %s WHERE event_id = %s AND expires_at < %s", s.table, s.placeholder(1), s.placeholder(2)),
+		key, now)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired claim: %w", err)
+	}
+
+	_, insertErr := s.db.ExecContext(ctx,
+		fmt.Sprintf("INSERT INTO %s (event_id, claim_token, status, expires_at) VALUES (%s, %s, %s, %s)",
+			s.table, s.placeholder(1), s.placeholder(2), s.placeholder(3), s.placeholder(4)),
+		key, token, idempotencyStatusProcessing, now.Add(s.lease))
+	if insertErr == nil {
+		return ClaimAcquired, nil
+	}
+
+	// the insert failed, most likely because the event_id already exists. Unique violations aren't reported
+	// consistently across drivers, so look the row up instead of inspecting the error.
+	var status string
+	err = s.db.QueryRowContext(ctx,
+		fmt.Sprintf("SELECT status FROM %s WHERE event_id = %s", s.table, s.placeholder(1)),
+		key).Scan(&status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("failed to insert claim: %w", insertErr)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read claim: %w", err)
+	}
+
+	if status == idempotencyStatusCompleted {
+		return ClaimCompleted, nil
+	}
+	return ClaimInProgress, nil
+}
+
+// Complete implements IdempotencyStore.
+func (s *SQLIdempotencyStore) Complete(ctx context.Context, key, token string) error {
+	result, err := s.db.ExecContext(ctx,
+		fmt.Sprintf("UPDATE %s SET status = %s, expires_at = %s WHERE event_id = %s AND claim_token = %s",
+			s.table, s.placeholder(1), s.placeholder(2), s.placeholder(3), s.placeholder(4)),
+		idempotencyStatusCompleted, s.now().UTC().Add(s.ttl), key, token)
+	if err != nil {
+		return fmt.Errorf("failed to complete claim: %w", err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to complete claim: %w", err)
+	}
+	if n == 0 {
+		return ErrClaimLost
+	}
+	return nil
+}
+
+// Release implements IdempotencyStore.
+func (s *SQLIdempotencyStore) Release(ctx context.Context, key, token string) error {
+	_, err := s.db.ExecContext(ctx,
+		fmt.Sprintf("DELETE FROM %s WHERE event_id = %s AND claim_token = %s AND status = %s",
+			s.table, s.placeholder(1), s.placeholder(2), s.placeholder(3)),
+		key, token, idempotencyStatusProcessing)
+	if err != nil {
+		return fmt.Errorf("failed to release claim: %w", err)
+	}
+	return nil
+}
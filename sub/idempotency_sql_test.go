@@ -0,0 +1,268 @@
+package sub
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeIdempotencyDB is a database/sql driver holding an idempotency table in memory. It only understands the
+// queries of SQLIdempotencyStore, with the bind parameters in the order the store passes them.
+type fakeIdempotencyDB struct {
+	mu      sync.Mutex
+	rows    map[string]fakeIdempotencyRow
+	queries []string
+}
+
+type fakeIdempotencyRow struct {
+	token     string
+	status    string
+	expiresAt time.Time
+}
+
+// placeholderPattern matches the "?" and "$n" bind parameters of a query.
+var placeholderPattern = regexp.MustCompile(`\?|\$\d+`)
+
+// newFakeIdempotencyDB returns a fake database and a *sql.DB connected to it.
+func newFakeIdempotencyDB(t *testing.T) (*fakeIdempotencyDB, *sql.DB) {
+	t.Helper()
+
+	fake := &fakeIdempotencyDB{rows: make(map[string]fakeIdempotencyRow)}
+	db := sql.OpenDB(fake)
+	t.Cleanup(func() { db.Close() })
+	return fake, db
+}
+
+// Connect implements driver.Connector.
+func (f *fakeIdempotencyDB) Connect(context.Context) (driver.Conn, error) {
+	return fakeIdempotencyConn{f}, nil
+}
+
+// Driver implements driver.Connector.
+func (f *fakeIdempotencyDB) Driver() driver.Driver {
+	return nil
+}
+
+// exec runs a query with its bind parameters replaced by "?", and returns the number of affected rows or the
+// selected status.
+func (f *fakeIdempotencyDB) exec(query string, args []driver.NamedValue) (int64, []string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.queries = append(f.queries, query)
+
+	query = placeholderPattern.ReplaceAllString(query, "?")
+	arg := func(i int) string {
+		if s, ok := args[i].Value.(string); ok {
+			return s
+		}
+		return ""
+	}
+	timeArg := func(i int) time.Time {
+		t, _ := args[i].Value.(time.Time)
+		return t
+	}
+
+	switch {
+	case strings.HasPrefix(query, "DELETE FROM psss_idempotency WHERE event_id = ? AND expires_at < ?"):
+		row, ok := f.rows[arg(0)]
+		if ok && row.expiresAt.Before(timeArg(1)) {
+			delete(f.rows, arg(0))
+			return 1, nil, nil
+		}
+		return 0, nil, nil
+	case strings.HasPrefix(query, "INSERT INTO psss_idempotency (event_id, claim_token, status, expires_at) VALUES (?, ?, ?, ?)"):
+		if _, ok := f.rows[arg(0)]; ok {
+			return 0, nil, errors.New("UNIQUE constraint failed: psss_idempotency.event_id")
+		}
+		f.rows[arg(0)] = fakeIdempotencyRow{token: arg(1), status: arg(2), expiresAt: timeArg(3)}
+		return 1, nil, nil
+	case strings.HasPrefix(query, "SELECT status FROM psss_idempotency WHERE event_id = ?"):
+		row, ok := f.rows[arg(0)]
+		if !ok {
+			return 0, nil, nil
+		}
+		return 0, []string{row.status}, nil
+	case strings.HasPrefix(query, "UPDATE psss_idempotency SET status = ?, expires_at = ? WHERE event_id = ? AND claim_token = ?"):
+		row, ok := f.rows[arg(2)]
+		if !ok || row.token != arg(3) {
+			return 0, nil, nil
+		}
+		row.status, row.expiresAt = arg(0), timeArg(1)
+		f.rows[arg(2)] = row
+		return 1, nil, nil
+	case strings.HasPrefix(query, "DELETE FROM psss_idempotency WHERE event_id = ? AND claim_token = ? AND status = ?"):
+		row, ok := f.rows[arg(0)]
+		if !ok || row.token != arg(1) || row.status != arg(2) {
+			return 0, nil, nil
+		}
+		delete(f.rows, arg(0))
+		return 1, nil, nil
+	default:
+		return 0, nil, fmt.Errorf("unexpected query %q", query)
+	}
+}
+
+// fakeIdempotencyConn is a connection to a fakeIdempotencyDB. It only runs queries directly, without preparing them.
+type fakeIdempotencyConn struct {
+	db *fakeIdempotencyDB
+}
+
+func (c fakeIdempotencyConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("prepared statements are not supported")
+}
+
+func (c fakeIdempotencyConn) Close() error {
+	return nil
+}
+
+func (c fakeIdempotencyConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions are not supported")
+}
+
+func (c fakeIdempotencyConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	n, _, err := c.db.exec(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(n), nil
+}
+
+func (c fakeIdempotencyConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	_, statuses, err := c.db.exec(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeStatusRows{statuses: statuses}, nil
+}
+
+// fakeStatusRows are the rows of a "SELECT status" query.
+type fakeStatusRows struct {
+	statuses []string
+}
+
+func (r *fakeStatusRows) Columns() []string {
+	return []string{"status"}
+}
+
+func (r *fakeStatusRows) Close() error {
+	return nil
+}
+
+func (r *fakeStatusRows) Next(dest []driver.Value) error {
+	if len(r.statuses) == 0 {
+		return io.EOF
+	}
+	dest[0] = r.statuses[0]
+	r.statuses = r.statuses[1:]
+	return nil
+}
+
+func TestSQLIdempotencyStore(t *testing.T) {
+	ctx := context.Background()
+	_, db := newFakeIdempotencyDB(t)
+	clock := &fakeClock{now: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	store := NewSQLIdempotencyStore(db, "psss_idempotency", time.Hour).WithLeaseDuration(time.Minute)
+	store.now = clock.Now
+
+	claim := func(token string, want ClaimStatus) {
+		t.Helper()
+		status, err := store.Claim(ctx, "event", token)
+		if err != nil {
+			t.Fatalf("Claim(%s) = %v", token, err)
+		}
+		if status != want {
+			t.Fatalf("Claim(%s) = %d, want %d", token, status, want)
+		}
+	}
+
+	claim("first", ClaimAcquired)
+	claim("second", ClaimInProgress)
+
+	// the first consumer's lease expires and the event is claimed by the second one.
+	clock.Advance(2 * time.Minute)
+	claim("second", ClaimAcquired)
+
+	// the first consumer's stale token can neither release nor complete the claim of the second one.
+	err := store.Release(ctx, "event", "first")
+	if err != nil {
+		t.Fatalf("Release(first) = %v", err)
+	}
+	claim("third", ClaimInProgress)
+
+	err = store.Complete(ctx, "event", "first")
+	if !errors.Is(err, ErrClaimLost) {
+		t.Fatalf("Complete(first) = %v, want ErrClaimLost", err)
+	}
+	claim("third", ClaimInProgress)
+
+	err = store.Complete(ctx, "event", "second")
+	if err != nil {
+		t.Fatalf("Complete(second) = %v", err)
+	}
+	claim("third", ClaimCompleted)
+
+	// completed events can't be released.
+	err = store.Release(ctx, "event", "second")
+	if err != nil {
+		t.Fatalf("Release(second) = %v", err)
+	}
+	claim("third", ClaimCompleted)
+
+	// completed events are remembered for the TTL, not the lease.
+	clock.Advance(30 * time.Minute)
+	claim("third", ClaimCompleted)
+	clock.Advance(time.Hour)
+	claim("third", ClaimAcquired)
+}
+
+func TestSQLIdempotencyStoreRelease(t *testing.T) {
+	ctx := context.Background()
+	_, db := newFakeIdempotencyDB(t)
+	store := NewSQLIdempotencyStore(db, "psss_idempotency", time.Hour)
+
+	status, err := store.Claim(ctx, "event", "first")
+	if err != nil || status != ClaimAcquired {
+		t.Fatalf("Claim(first) = %d, %v, want ClaimAcquired", status, err)
+	}
+	err = store.Release(ctx, "event", "first")
+	if err != nil {
+		t.Fatalf("Release(first) = %v", err)
+	}
+
+	// the released event can be claimed right away.
+	status, err = store.Claim(ctx, "event", "second")
+	if err != nil || status != ClaimAcquired {
+		t.Errorf("Claim(second) after release = %d, %v, want ClaimAcquired", status, err)
+	}
+}
+
+func TestSQLIdempotencyStorePlaceholders(t *testing.T) {
+	ctx := context.Background()
+	fake, db := newFakeIdempotencyDB(t)
+	store := NewSQLIdempotencyStore(db, "psss_idempotency", time.Hour).WithPlaceholders(DollarPlaceholders)
+
+	status, err := store.Claim(ctx, "event", "first")
+	if err != nil || status != ClaimAcquired {
+		t.Fatalf("Claim(first) = %d, %v, want ClaimAcquired", status, err)
+	}
+	err = store.Complete(ctx, "event", "first")
+	if err != nil {
+		t.Fatalf("Complete(first) = %v", err)
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	for _, query := range fake.queries {
+		if strings.Contains(query, "?") || !strings.Contains(query, "$1") {
+			t.Errorf("query %q doesn't use $n placeholders", query)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package sub
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Iknite-Space/psss/models"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestMemoryIdempotencyStoreConcurrentClaims(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryIdempotencyStore(100, time.Hour)
+
+	const consumers = 20
+	statuses := make([]ClaimStatus, consumers)
+	var wg sync.WaitGroup
+	for i := range consumers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			status, err := store.Claim(ctx, "event", string(rune('a'+i)))
+			if err != nil {
+				t.Errorf("Claim() = %v", err)
+			}
+			statuses[i] = status
+		}()
+	}
+	wg.Wait()
+
+	acquired := 0
+	for _, status := range statuses {
+		switch status {
+		case ClaimAcquired:
+			acquired++
+		case ClaimInProgress:
+		default:
+			t.Errorf("Claim() = %d, want ClaimAcquired or ClaimInProgress", status)
+		}
+	}
+	if acquired != 1 {
+		t.Errorf("%d consumers acquired the claim, want 1", acquired)
+	}
+}
+
+func TestMemoryIdempotencyStoreLease(t *testing.T) {
+	ctx := context.Background()
+	clock := &fakeClock{now: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	store := NewMemoryIdempotencyStore(100, time.Hour).WithLeaseDuration(time.Minute)
+	store.now = clock.Now
+
+	claim := func(token string, want ClaimStatus) {
+		t.Helper()
+		status, err := store.Claim(ctx, "event", token)
+		if err != nil {
+			t.Fatalf("Claim(%s) = %v", token, err)
+		}
+		if status != want {
+			t.Fatalf("Claim(%s) = %d, want %d", token, status, want)
+		}
+	}
+
+	claim("first", ClaimAcquired)
+	claim("second", ClaimInProgress)
+
+	// the first consumer's lease expires and the event is claimed by the second one.
+	clock.Advance(2 * time.Minute)
+	claim("second", ClaimAcquired)
+
+	// the first consumer can neither release nor complete the claim of the second one.
+	err := store.Release(ctx, "event", "first")
+	if err != nil {
+		t.Fatalf("Release(first) = %v", err)
+	}
+	claim("third", ClaimInProgress)
+
+	err = store.Complete(ctx, "event", "first")
+	if !errors.Is(err, ErrClaimLost) {
+		t.Fatalf("Complete(first) = %v, want ErrClaimLost", err)
+	}
+	claim("third", ClaimInProgress)
+
+	err = store.Complete(ctx, "event", "second")
+	if err != nil {
+		t.Fatalf("Complete(second) = %v", err)
+	}
+	claim("third", ClaimCompleted)
+
+	// completed events are forgotten after the TTL.
+	clock.Advance(2 * time.Hour)
+	claim("third", ClaimAcquired)
+}
+
+func TestMemoryIdempotencyStoreRelease(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryIdempotencyStore(100, time.Hour)
+
+	status, _ := store.Claim(ctx, "event", "first")
+	if status != ClaimAcquired {
+		t.Fatalf("Claim(first) = %d, want ClaimAcquired", status)
+	}
+	err := store.Release(ctx, "event", "first")
+	if err != nil {
+		t.Fatalf("Release(first) = %v", err)
+	}
+
+	status, _ = store.Claim(ctx, "event", "second")
+	if status != ClaimAcquired {
+		t.Fatalf("Claim(second) after release = %d, want ClaimAcquired", status)
+	}
+	err = store.Complete(ctx, "event", "second")
+	if err != nil {
+		t.Fatalf("Complete(second) = %v", err)
+	}
+
+	// completed events can't be released.
+	err = store.Release(ctx, "event", "second")
+	if err != nil {
+		t.Fatalf("Release(second) = %v", err)
+	}
+	status, _ = store.Claim(ctx, "event", "third")
+	if status != ClaimCompleted {
+		t.Errorf("Claim(third) after completion = %d, want ClaimCompleted", status)
+	}
+}
+
+func TestIdempotentMutationEventHandler(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryIdempotencyStore(100, time.Hour)
+
+	calls := 0
+	fail := true
+	handler := IdempotentMutationEventHandler(store, func(context.Context, models.ProtoMutationEvent[*wrapperspb.StringValue]) error {
+		calls++
+		if fail {
+			return errors.New("handler failed")
+		}
+		return nil
+	})
+
+	event := models.ProtoMutationEvent[*wrapperspb.StringValue]{EventID: "event"}
+
+	// failed events are released and handled again.
+	err := handler(ctx, event)
+	if err == nil {
+		t.Fatal("handler succeeded, want an error")
+	}
+	fail = false
+	err = handler(ctx, event)
+	if err != nil {
+		t.Fatalf("handler = %v", err)
+	}
+
+	// duplicates of completed events are skipped.
+	err = handler(ctx, event)
+	if err != nil {
+		t.Fatalf("handler = %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("handler called %d times, want 2", calls)
+	}
+
+	// duplicates of events in progress fail.
+	_, err = store.Claim(ctx, "other", "consumer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = handler(ctx, models.ProtoMutationEvent[*wrapperspb.StringValue]{EventID: "other"})
+	if !errors.Is(err, ErrEventInProgress) {
+		t.Errorf("handler = %v, want ErrEventInProgress", err)
+	}
+}
@@ -33,14 +33,9 @@ func NewJSONSqsEventProcessor[T any](svc *sqs.Client,
 // by unmarshaling the SQS message body into the appropriate type.
 func jsonEventHandlerToSqsHandlerFn[T any](handler JSONEventHandlerFn[T]) SqsHandlerFn {
 	return func(ctx context.Context, message awstypes.Message) error {
-		if message.Body == nil {
-			return fmt.Errorf("message body is nil")
-		}
-
-		var msgBody T
-		err := json.Unmarshal([]byte(*message.Body), &msgBody)
+		msgBody, err := decodeJSONMessage[T](message)
 		if err != nil {
-			return fmt.Errorf("failed to unmarshal message body: %w", err)
+			return err
 		}
 
 		err = handler(ctx, msgBody)
@@ -51,3 +46,17 @@ func jsonEventHandlerToSqsHandlerFn[T any](handler JSONEventHandlerFn[T]) SqsHan
 		return nil
 	}
 }
+
+// decodeJSONMessage unmarshals the body of an SQS message as JSON into a T.
+func decodeJSONMessage[T any](message awstypes.Message) (T, error) {
+	var msgBody T
+	if message.Body == nil {
+		return msgBody, fmt.Errorf("message body is nil")
+	}
+
+	err := json.Unmarshal([]byte(*message.Body), &msgBody)
+	if err != nil {
+		return msgBody, fmt.Errorf("failed to unmarshal message body: %w", err)
+	}
+	return msgBody, nil
+}
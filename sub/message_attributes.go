@@ -0,0 +1,27 @@
+package sub
+
+import (
+	awstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// sqsMessageAttributes returns the string attributes of an SQS message.
+func sqsMessageAttributes(message awstypes.Message) map[string]string {
+	attributes := make(map[string]string, len(message.MessageAttributes))
+	for name, value := range message.MessageAttributes {
+		if value.StringValue != nil {
+			attributes[name] = *value.StringValue
+		}
+	}
+	return attributes
+}
+
+// snsMessageAttributes returns the string attributes of an SNS notification.
+func snsMessageAttributes(sw SnsWrapper) map[string]string {
+	attributes := make(map[string]string, len(sw.MessageAttributes))
+	for name, value := range sw.MessageAttributes {
+		if value.Type != "Binary" {
+			attributes[name] = value.Value
+		}
+	}
+	return attributes
+}
@@ -0,0 +1,95 @@
+package sub
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	awstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// MessageInfo is the transport metadata of the message being handled. It is available to handlers through
+// MessageInfoFromContext.
+type MessageInfo struct {
+	// MessageID is the ID of the SQS message.
+	MessageID string
+
+	// ReceiptHandle is the receipt handle of the SQS message, e.g. to extend its visibility timeout.
+	ReceiptHandle string
+
+	// ApproximateReceiveCount is the number of times the SQS message has been received, including this time.
+	ApproximateReceiveCount int
+
+	// SentTimestamp is the time at which the message was sent to the SQS queue.
+	SentTimestamp time.Time
+
+	// SnsMessageID is the ID SNS assigned to the message. It is empty for raw deliveries.
+	SnsMessageID string
+
+	// TopicArn is the ARN of the topic the message was published to. It is empty for raw deliveries.
+	TopicArn string
+
+	// Subject is the subject the message was published with, if any.
+	Subject string
+
+	// Timestamp is the time at which SNS received the message. It is zero for raw deliveries.
+	Timestamp time.Time
+
+	// MessageAttributes are the string message attributes of the message. For SNS notifications these are the
+	// attributes the message was published with.
+	MessageAttributes map[string]string
+}
+
+type messageInfoKey struct{}
+
+// WithMessageInfo returns a context carrying info, e.g. to call handlers outside of an SqsEventProcessor.
+func WithMessageInfo(ctx context.Context, info MessageInfo) context.Context {
+	return context.WithValue(ctx, messageInfoKey{}, info)
+}
+
+// MessageInfoFromContext returns the MessageInfo of the message being handled. It reports false if ctx doesn't
+// carry one.
+func MessageInfoFromContext(ctx context.Context) (MessageInfo, bool) {
+	info, ok := ctx.Value(messageInfoKey{}).(MessageInfo)
+	return info, ok
+}
+
+// sqsMessageInfo returns the MessageInfo of a raw SQS message.
+func sqsMessageInfo(message awstypes.Message) MessageInfo {
+	info := MessageInfo{
+		MessageAttributes: sqsMessageAttributes(message),
+	}
+	if message.MessageId != nil {
+		info.MessageID = *message.MessageId
+	}
+	if message.ReceiptHandle != nil {
+		info.ReceiptHandle = *message.ReceiptHandle
+	}
+	if count, err := strconv.Atoi(message.Attributes[string(awstypes.MessageSystemAttributeNameApproximateReceiveCount)]); err == nil {
+		info.ApproximateReceiveCount = count
+	}
+	if millis, err := strconv.ParseInt(message.Attributes[string(awstypes.MessageSystemAttributeNameSentTimestamp)], 10, 64); err == nil {
+		info.SentTimestamp = time.UnixMilli(millis).UTC()
+	}
+	return info
+}
+
+// withSnsNotification returns a context whose MessageInfo is extended with the metadata of the SNS notification sw.
+func withSnsNotification(ctx context.Context, sw SnsWrapper) context.Context {
+	info, _ := MessageInfoFromContext(ctx)
+	info.SnsMessageID = sw.MessageID
+	info.TopicArn = sw.TopicArn
+	info.Subject = sw.Subject
+	info.Timestamp = time.Time{}
+	if timestamp, err := time.Parse(time.RFC3339Nano, sw.Timestamp); err == nil {
+		info.Timestamp = timestamp
+	}
+	info.MessageAttributes = snsMessageAttributes(sw)
+	return WithMessageInfo(ctx, info)
+}
+
+// messageAttributes returns the string message attributes of the message being handled.
+func messageAttributes(ctx context.Context) map[string]string {
+	info, _ := MessageInfoFromContext(ctx)
+	return info.MessageAttributes
+}
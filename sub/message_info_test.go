@@ -0,0 +1,110 @@
+package sub
+
+import (
+	"context"
+	"maps"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+func TestSqsMessageInfo(t *testing.T) {
+	sent := time.Date(2025, 1, 2, 3, 4, 5, 678_000_000, time.UTC)
+	message := awstypes.Message{
+		MessageId:     aws.String("1"),
+		ReceiptHandle: aws.String("handle-1"),
+		Attributes: map[string]string{
+			string(awstypes.MessageSystemAttributeNameApproximateReceiveCount): "3",
+			string(awstypes.MessageSystemAttributeNameSentTimestamp):           "1735787045678",
+		},
+		MessageAttributes: map[string]awstypes.MessageAttributeValue{
+			"content_type": {DataType: aws.String("String"), StringValue: aws.String("application/json")},
+			"checksum":     {DataType: aws.String("Binary"), BinaryValue: []byte{1, 2}},
+		},
+	}
+
+	info := sqsMessageInfo(message)
+	if info.MessageID != "1" || info.ReceiptHandle != "handle-1" {
+		t.Errorf("MessageID = %q, ReceiptHandle = %q, want 1, handle-1", info.MessageID, info.ReceiptHandle)
+	}
+	if info.ApproximateReceiveCount != 3 {
+		t.Errorf("ApproximateReceiveCount = %d, want 3", info.ApproximateReceiveCount)
+	}
+	if !info.SentTimestamp.Equal(sent) {
+		t.Errorf("SentTimestamp = %v, want %v", info.SentTimestamp, sent)
+	}
+	// binary attributes are left out.
+	if want := map[string]string{"content_type": "application/json"}; !maps.Equal(info.MessageAttributes, want) {
+		t.Errorf("MessageAttributes = %v, want %v", info.MessageAttributes, want)
+	}
+	if info.SnsMessageID != "" || info.TopicArn != "" || !info.Timestamp.IsZero() {
+		t.Errorf("SNS fields of a raw message = %+v, want them empty", info)
+	}
+
+	// the system attributes are only there when they were requested.
+	message.Attributes = map[string]string{
+		string(awstypes.MessageSystemAttributeNameApproximateReceiveCount): "many",
+	}
+	info = sqsMessageInfo(message)
+	if info.ApproximateReceiveCount != 0 || !info.SentTimestamp.IsZero() {
+		t.Errorf("ApproximateReceiveCount = %d, SentTimestamp = %v, want them unset", info.ApproximateReceiveCount, info.SentTimestamp)
+	}
+}
+
+func TestMessageInfoOfSnsNotification(t *testing.T) {
+	message := sqsDelivery(t, publishedMessage{
+		body:       `{"event_id":"1"}`,
+		attributes: map[string]string{"content_type": "application/json"},
+	}, false)
+	message.ReceiptHandle = aws.String("handle-1")
+	message.Attributes = map[string]string{
+		string(awstypes.MessageSystemAttributeNameApproximateReceiveCount): "2",
+	}
+	// the attributes of the SQS message itself, which SNS doesn't set when it wraps the message.
+	message.MessageAttributes = map[string]awstypes.MessageAttributeValue{
+		"queue_attribute": {DataType: aws.String("String"), StringValue: aws.String("x")},
+	}
+
+	var info MessageInfo
+	var ok bool
+	handler := StringHandlerToAutoDetectSqsHandler(func(ctx context.Context, _ string) error {
+		info, ok = MessageInfoFromContext(ctx)
+		return nil
+	})
+	err := handler(context.Background(), message)
+	if err != nil {
+		t.Fatalf("handler() = %v", err)
+	}
+	if !ok {
+		t.Fatal("no MessageInfo in the handler's context")
+	}
+
+	// the SQS metadata is kept along with that of the notification.
+	if info.MessageID != "1" || info.ReceiptHandle != "handle-1" || info.ApproximateReceiveCount != 2 {
+		t.Errorf("SQS fields = %+v, want message 1 received twice with handle-1", info)
+	}
+	if info.SnsMessageID != "sns-1" || info.TopicArn != "arn:aws:sns:us-east-1:123456789012:notes" {
+		t.Errorf("SnsMessageID = %q, TopicArn = %q, want those of the notification", info.SnsMessageID, info.TopicArn)
+	}
+	if want := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC); !info.Timestamp.Equal(want) {
+		t.Errorf("Timestamp = %v, want %v", info.Timestamp, want)
+	}
+	// the message attributes are those the message was published with.
+	if want := map[string]string{"content_type": "application/json"}; !maps.Equal(info.MessageAttributes, want) {
+		t.Errorf("MessageAttributes = %v, want %v", info.MessageAttributes, want)
+	}
+}
+
+func TestMessageInfoFromContext(t *testing.T) {
+	_, ok := MessageInfoFromContext(context.Background())
+	if ok {
+		t.Error("MessageInfoFromContext() of a context without MessageInfo = true")
+	}
+
+	info, ok := MessageInfoFromContext(WithMessageInfo(context.Background(), MessageInfo{MessageID: "1"}))
+	if !ok || info.MessageID != "1" {
+		t.Errorf("MessageInfoFromContext() = %+v, %v, want message 1", info, ok)
+	}
+}
@@ -0,0 +1,321 @@
+package sub
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"strings"
+
+	"github.com/Iknite-Space/psss/encryption"
+	"github.com/Iknite-Space/psss/models"
+	"google.golang.org/protobuf/proto"
+)
+
+// payloadFormat is the serialization of the Before and After payloads of a mutation event.
+type payloadFormat uint8
+
+const (
+	payloadFormatProtoJSON payloadFormat = iota
+	payloadFormatProtoBinary
+)
+
+// decodedMutationEvent is a mutation event envelope with its Before and After payloads extracted, but not yet
+// unmarshaled into proto messages. before and after are nil when the event doesn't carry them.
+type decodedMutationEvent struct {
+	envelope models.PublishedProtoMutationEvent
+	before   []byte
+	after    []byte
+	format   payloadFormat
+}
+
+// decodeMutationEvent decodes a mutation event body according to its message attributes. When the content type is
+// unknown, because the message was published by an older publisher or the attributes were not delivered, the
+// encoding is detected from the body: JSON envelopes start with '{', anything else is taken as a base64 protobuf
+// envelope. JSON envelopes without a content type are assumed to carry protojson payloads, so
+// models.ContentTypeProtobufPayloadJSON messages can only be decoded with their content_type attribute.
+func decodeMutationEvent(body string, attributes map[string]string) (decodedMutationEvent, error) {
+	if models.IsBinaryCloudEvent(attributes) {
+		ce, err := models.CloudEventFromBinary(attributes, []byte(body))
+		if err != nil {
+			return decodedMutationEvent{}, fmt.Errorf("reading binary cloudevent: %w", err)
+		}
+		return decodeCloudEvent(ce)
+	}
+
+	contentType := attributes[models.AttributeContentType]
+	if contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err == nil {
+			contentType = mediaType
+		}
+	}
+
+	switch contentType {
+	case "":
+		if strings.HasPrefix(strings.TrimSpace(body), "{") {
+			return decodeUntypedJSONMutationEvent(body)
+		}
+		return decodeProtobufMutationEvent(body)
+	case models.ContentTypeJSON:
+		return decodeJSONMutationEvent(body)
+	case models.ContentTypeProtobufPayloadJSON:
+		return decodeProtobufPayloadJSONMutationEvent(body)
+	case models.ContentTypeProtobuf:
+		return decodeProtobufMutationEvent(body)
+	case models.ContentTypeCloudEventsJSON:
+		return decodeStructuredCloudEvent(body)
+	default:
+		return decodedMutationEvent{}, fmt.Errorf("unsupported content type %q", contentType)
+	}
+}
+
+// DecodePublishedMutationEvent decodes a mutation event body in any of the supported encodings into a JSON
+// envelope, without decrypting or unmarshaling its payloads, e.g. to store it. It returns the envelope along with
+// the content type it must be handled or published with to be decoded again: models.ContentTypeJSON when the
+// payloads are nested protojson, or models.ContentTypeProtobufPayloadJSON when they are base64 strings of the
+// protobuf wire format. Encrypted payloads are base64 strings of their ciphertext.
+func DecodePublishedMutationEvent(body string, attributes map[string]string) (models.PublishedProtoMutationEvent, string, error) {
+	decoded, err := decodeMutationEvent(body, attributes)
+	if err != nil {
+		return models.PublishedProtoMutationEvent{}, "", err
+	}
+
+	envelope := decoded.envelope
+	contentType := models.ContentTypeJSON
+	if decoded.format == payloadFormatProtoBinary {
+		contentType = models.ContentTypeProtobufPayloadJSON
+	}
+
+	if decoded.format == payloadFormatProtoJSON && envelope.Encryption == nil {
+		envelope.Before = json.RawMessage(decoded.before)
+		envelope.After = json.RawMessage(decoded.after)
+		return envelope, contentType, nil
+	}
+
+	envelope.Before, err = marshalBase64Payload(decoded.before)
+	if err != nil {
+		return envelope, "", fmt.Errorf("encoding 'Before': %w", err)
+	}
+	envelope.After, err = marshalBase64Payload(decoded.after)
+	if err != nil {
+		return envelope, "", fmt.Errorf("encoding 'After': %w", err)
+	}
+
+	return envelope, contentType, nil
+}
+
+// marshalBase64Payload returns a payload as a JSON base64 string, or nil if there is no payload.
+func marshalBase64Payload(payload []byte) (json.RawMessage, error) {
+	if payload == nil {
+		return nil, nil
+	}
+	return json.Marshal(payload)
+}
+
+// decodeJSONMutationEvent decodes a models.ContentTypeJSON envelope, in which the payloads are nested protojson.
+func decodeJSONMutationEvent(body string) (decodedMutationEvent, error) {
+	var decoded decodedMutationEvent
+	err := json.Unmarshal([]byte(body), &decoded.envelope)
+	if err != nil {
+		return decoded, fmt.Errorf("unmarshaling json envelope: %w", err)
+	}
+
+	decoded.format = payloadFormatProtoJSON
+	if decoded.envelope.Encryption != nil {
+		decoded.before, decoded.after, err = decodeBase64Payloads(decoded.envelope)
+		return decoded, err
+	}
+
+	decoded.before = nullToNil(decoded.envelope.Before)
+	decoded.after = nullToNil(decoded.envelope.After)
+
+	return decoded, nil
+}
+
+// decodeUntypedJSONMutationEvent decodes a JSON body without a content type, which is either a structured
+// CloudEvent or a mutation event envelope.
+func decodeUntypedJSONMutationEvent(body string) (decodedMutationEvent, error) {
+	var probe struct {
+		SpecVersion string `json:"specversion"`
+	}
+	err := json.Unmarshal([]byte(body), &probe)
+	if err != nil {
+		return decodedMutationEvent{}, fmt.Errorf("unmarshaling json envelope: %w", err)
+	}
+
+	if probe.SpecVersion != "" {
+		return decodeStructuredCloudEvent(body)
+	}
+
+	return decodeLegacyJSONMutationEvent(body)
+}
+
+// decodeLegacyJSONMutationEvent decodes a JSON envelope without a content type, whose payloads are either nested
+// protojson or base64 encoded protojson.
+func decodeLegacyJSONMutationEvent(body string) (decodedMutationEvent, error) {
+	var decoded decodedMutationEvent
+	err := json.Unmarshal([]byte(body), &decoded.envelope)
+	if err != nil {
+		return decoded, fmt.Errorf("unmarshaling json envelope: %w", err)
+	}
+
+	if decoded.envelope.Encryption != nil {
+		decoded.before, decoded.after, err = decodeBase64Payloads(decoded.envelope)
+		return decoded, err
+	}
+
+	decoded.before, err = models.DecodeProtoJSON(decoded.envelope.Before)
+	if err != nil {
+		return decoded, fmt.Errorf("decoding 'Before': %w", err)
+	}
+
+	decoded.after, err = models.DecodeProtoJSON(decoded.envelope.After)
+	if err != nil {
+		return decoded, fmt.Errorf("decoding 'After': %w", err)
+	}
+
+	decoded.format = payloadFormatProtoJSON
+
+	return decoded, nil
+}
+
+// decodeProtobufPayloadJSONMutationEvent decodes a models.ContentTypeProtobufPayloadJSON envelope, in which the
+// payloads are base64 strings of the protobuf wire format.
+func decodeProtobufPayloadJSONMutationEvent(body string) (decodedMutationEvent, error) {
+	var decoded decodedMutationEvent
+	err := json.Unmarshal([]byte(body), &decoded.envelope)
+	if err != nil {
+		return decoded, fmt.Errorf("unmarshaling json envelope: %w", err)
+	}
+
+	decoded.before, decoded.after, err = decodeBase64Payloads(decoded.envelope)
+	decoded.format = payloadFormatProtoBinary
+
+	return decoded, err
+}
+
+// decodeProtobufMutationEvent decodes a models.ContentTypeProtobuf envelope.
+func decodeProtobufMutationEvent(body string) (decodedMutationEvent, error) {
+	var decoded decodedMutationEvent
+
+	b, err := base64.StdEncoding.DecodeString(body)
+	if err != nil {
+		return decoded, fmt.Errorf("decoding base64 protobuf envelope: %w", err)
+	}
+
+	decoded.envelope, decoded.before, decoded.after, err = models.UnmarshalProtoEnvelope(b)
+	if err != nil {
+		return decoded, fmt.Errorf("unmarshaling protobuf envelope: %w", err)
+	}
+
+	decoded.format = payloadFormatProtoBinary
+
+	return decoded, nil
+}
+
+// decodeStructuredCloudEvent decodes a models.ContentTypeCloudEventsJSON body.
+func decodeStructuredCloudEvent(body string) (decodedMutationEvent, error) {
+	var ce models.CloudEvent
+	err := json.Unmarshal([]byte(body), &ce)
+	if err != nil {
+		return decodedMutationEvent{}, fmt.Errorf("unmarshaling cloudevent: %w", err)
+	}
+
+	return decodeCloudEvent(ce)
+}
+
+// decodeCloudEvent converts a CloudEvent into a mutation event. Its data holds nested protojson payloads.
+func decodeCloudEvent(ce models.CloudEvent) (decodedMutationEvent, error) {
+	envelope, err := ce.ToPublishedProtoMutationEvent()
+	if err != nil {
+		return decodedMutationEvent{}, fmt.Errorf("converting cloudevent: %w", err)
+	}
+
+	decoded := decodedMutationEvent{
+		envelope: envelope,
+		format:   payloadFormatProtoJSON,
+	}
+
+	if envelope.Encryption != nil {
+		decoded.before, decoded.after, err = decodeBase64Payloads(envelope)
+		return decoded, err
+	}
+
+	decoded.before = nullToNil(envelope.Before)
+	decoded.after = nullToNil(envelope.After)
+
+	return decoded, nil
+}
+
+// decodeBase64Payloads decodes Before and After of an envelope in which they are base64 strings, either because
+// they are in protobuf wire format or because they are encrypted.
+func decodeBase64Payloads(envelope models.PublishedProtoMutationEvent) ([]byte, []byte, error) {
+	var before, after []byte
+
+	if raw := nullToNil(envelope.Before); raw != nil {
+		err := json.Unmarshal(raw, &before)
+		if err != nil {
+			return nil, nil, fmt.Errorf("decoding 'Before': %w", err)
+		}
+	}
+
+	if raw := nullToNil(envelope.After); raw != nil {
+		err := json.Unmarshal(raw, &after)
+		if err != nil {
+			return nil, nil, fmt.Errorf("decoding 'After': %w", err)
+		}
+	}
+
+	return before, after, nil
+}
+
+// decryptPayloads decrypts the payloads of an encrypted event with the data key recorded in its envelope.
+func (cfg mutationEventConfig) decryptPayloads(ctx context.Context, decoded decodedMutationEvent) (decodedMutationEvent, error) {
+	info := decoded.envelope.Encryption
+	if cfg.keyProvider == nil {
+		return decoded, errors.New("event is encrypted but no key provider is configured")
+	}
+	if info.Algorithm != encryption.AlgorithmAES256GCM {
+		return decoded, fmt.Errorf("unsupported encryption algorithm %q", info.Algorithm)
+	}
+
+	dataKey, err := cfg.keyProvider.DecryptDataKey(ctx, info.KeyID, info.WrappedKey)
+	if err != nil {
+		return decoded, fmt.Errorf("decrypting data key: %w", err)
+	}
+
+	eventID := decoded.envelope.EventID
+	if decoded.before != nil {
+		decoded.before, err = encryption.Decrypt(dataKey, decoded.before, encryption.PayloadAdditionalData(eventID, "before"))
+		if err != nil {
+			return decoded, fmt.Errorf("decrypting 'Before': %w", err)
+		}
+	}
+
+	if decoded.after != nil {
+		decoded.after, err = encryption.Decrypt(dataKey, decoded.after, encryption.PayloadAdditionalData(eventID, "after"))
+		if err != nil {
+			return decoded, fmt.Errorf("decrypting 'After': %w", err)
+		}
+	}
+
+	return decoded, nil
+}
+
+// unmarshalPayload unmarshals a Before or After payload into m.
+func (cfg mutationEventConfig) unmarshalPayload(format payloadFormat, b []byte, m proto.Message) error {
+	if format == payloadFormatProtoBinary {
+		return cfg.protoUnmarshalOptions.Unmarshal(b, m)
+	}
+	return cfg.unmarshalOptions.Unmarshal(b, m)
+}
+
+func nullToNil(raw json.RawMessage) json.RawMessage {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil
+	}
+	return raw
+}
@@ -2,9 +2,10 @@ package sub
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"time"
 
+	"github.com/Iknite-Space/psss/encryption"
 	"github.com/Iknite-Space/psss/models"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
 	"github.com/rs/zerolog"
@@ -14,30 +15,83 @@ import (
 
 type ProtoMutationEventHandlerFn[T proto.Message] func(context.Context, models.ProtoMutationEvent[T]) error
 
-// NewMutationEventSqsProcessor creates an SQS event processor that reads mutation events
-// from an SQS queue, unmarshal them into strongly typed protobuf messages, and processes them
-// using the provided event handler.
-// Note :- if includeSnsWrapper is true, the processor expects SQS messages to be wrapped in SNS JSON format.
-// Otherwise, it expects direct SQS messages containing the SNS "Message" JSON.
-func NewMutationEventSqsProcessor[T proto.Message](svc *sqs.Client, queueURL string, newMessage func() T, handler ProtoMutationEventHandlerFn[T], includeSnsWrapper bool) *SqsEventProcessor {
-	stringHandler := MutationEventHandlerToStringHandler(handler, newMessage)
+// MutationEventOption configures how mutation events are decoded by NewMutationEventSqsProcessor and
+// MutationEventHandlerToStringHandler.
+type MutationEventOption func(*mutationEventConfig)
+
+type mutationEventConfig struct {
+	unmarshalOptions      protojson.UnmarshalOptions
+	protoUnmarshalOptions proto.UnmarshalOptions
+	keyProvider           encryption.KeyProvider
+	coalesceWindow        time.Duration
+	versionGuard          *VersionGuard
+	typeRegistry          *TypeRegistry
+}
 
-	var sqsHandler SqsHandlerFn
+// newMutationEventConfig returns the decoding configuration for opts. By default, the decoder is a tolerant reader:
+// unknown proto fields are discarded so that producers can add fields before consumers are redeployed.
+func newMutationEventConfig(opts []MutationEventOption) mutationEventConfig {
+	cfg := mutationEventConfig{
+		unmarshalOptions: protojson.UnmarshalOptions{DiscardUnknown: true},
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+// validate returns an error wrapping ErrUnsupportedOption if cfg combines options that don't work together:
+// WithCoalescing with a VersionGuard using VersionPolicyHold, which would hold most summarized events until the
+// maximum hold runs out since their sequences skip those of the events they summarize.
+func (cfg mutationEventConfig) validate() error {
+	if cfg.coalesceWindow > 0 && cfg.versionGuard != nil && cfg.versionGuard.policy == VersionPolicyHold {
+		return fmt.Errorf("%w: WithCoalescing can't be combined with a VersionGuard using VersionPolicyHold",
+			ErrUnsupportedOption)
+	}
+	return nil
+}
 
-	if includeSnsWrapper {
-		// handling wrapped sns messages in sns format, see example in 'SnsWrapper' struct.
-		snsWrapperHandler := StringHandlerToSnsWrapperHandler(stringHandler)
-		sqsHandler = jsonEventHandlerToSqsHandlerFn(snsWrapperHandler)
-	} else {
-		// handling direct SQS messages containing the SNS "Message" Json field.
-		sqsHandler = StringHandlerToSqsHandler(StringHandlerFn(stringHandler))
+// WithProtoJSONUnmarshalOptions sets the protojson options used to unmarshal Before and After, e.g. a custom
+// Resolver for google.protobuf.Any fields. Note that setting DiscardUnknown to false makes the consumer reject
+// events from producers using a newer version of the proto schema.
+func WithProtoJSONUnmarshalOptions(opts protojson.UnmarshalOptions) MutationEventOption {
+	return func(cfg *mutationEventConfig) {
+		cfg.unmarshalOptions = opts
 	}
+}
+
+// NewMutationEventSqsProcessor creates an SQS event processor that reads mutation events
+// from an SQS queue, unmarshal them into strongly typed protobuf messages, and processes them
+// using the provided event handler.
+// Note :- the processor detects for each message whether it is wrapped in SNS JSON format or is a direct SQS
+// message containing the SNS "Message" JSON (raw message delivery), so both can be in the queue at the same time.
+// includeSnsWrapper is ignored and only kept for compatibility.
+// Run fails with ErrUnsupportedOption if opts combine options that don't work together.
+func NewMutationEventSqsProcessor[T proto.Message](svc *sqs.Client, queueURL string, newMessage func() T, handler ProtoMutationEventHandlerFn[T], includeSnsWrapper bool, opts ...MutationEventOption) *SqsEventProcessor {
+	stringHandler := MutationEventHandlerToStringHandler(handler, newMessage, opts...)
 
 	return &SqsEventProcessor{
 		svc:       svc,
 		queueURL:  queueURL,
 		logger:    zerolog.Nop(),
-		handlerFn: sqsHandler,
+		handlerFn: StringHandlerToAutoDetectSqsHandler(stringHandler),
+		configErr: newMutationEventConfig(opts).validate(),
+	}
+}
+
+// WithProtoUnmarshalOptions sets the options used to unmarshal Before and After when they are encoded in protobuf
+// wire format. Unknown fields are always tolerated in this format.
+func WithProtoUnmarshalOptions(opts proto.UnmarshalOptions) MutationEventOption {
+	return func(cfg *mutationEventConfig) {
+		cfg.protoUnmarshalOptions = opts
+	}
+}
+
+// WithKeyProvider sets the KeyProvider used to decrypt the payloads of encrypted events. Events whose payloads
+// are encrypted fail to decode without it.
+func WithKeyProvider(keyProvider encryption.KeyProvider) MutationEventOption {
+	return func(cfg *mutationEventConfig) {
+		cfg.keyProvider = keyProvider
 	}
 }
 
@@ -45,45 +99,85 @@ func NewMutationEventSqsProcessor[T proto.Message](svc *sqs.Client, queueURL str
 // into an SNS-compatible handler that processes the message field of an SNS JSON payload.
 // It deserializes the incoming mutation SNS event message into a PublishedProtoMutationEvent,
 // unmarshal the "Before" and "After" protobuf messages, and invokes the provided handler.
-// Returns an error if JSON or protobuf unmarshaling fails.
-func MutationEventHandlerToStringHandler[T proto.Message](handler ProtoMutationEventHandlerFn[T], newMessage func() T) StringHandlerFn {
+// The encoding of each message is taken from its content_type attribute, or detected from the body when the
+// attribute is missing, so producers using different encodings can share a topic. CloudEvents in structured and
+// binary content mode are converted back into mutation events.
+// Returns an error if JSON or protobuf unmarshaling fails. If opts combine options that don't work together, every
+// message fails with an error wrapping ErrUnsupportedOption.
+func MutationEventHandlerToStringHandler[T proto.Message](handler ProtoMutationEventHandlerFn[T], newMessage func() T, opts ...MutationEventOption) StringHandlerFn {
+	cfg := newMutationEventConfig(opts)
+	if err := cfg.validate(); err != nil {
+		return func(context.Context, string) error {
+			return err
+		}
+	}
+	if cfg.versionGuard != nil {
+		handler = guardMutationEvents(cfg.versionGuard, handler)
+	}
+	if cfg.coalesceWindow > 0 {
+		handler = newCoalescer(cfg.coalesceWindow, handler).handle
+	}
+
 	return func(ctx context.Context, s string) error {
-		msg := &models.PublishedProtoMutationEvent{}
-		err := json.Unmarshal([]byte(s), msg)
+		input, err := decodeProtoMutationEvent(ctx, s, newMessage, cfg)
 		if err != nil {
-			return fmt.Errorf("error unmarshaling sns mutation event. why=%w", err)
+			return err
 		}
 
-		//only unmarshal the before and after field if there are  not nil
-		before := newMessage()
-		if msg.Before != nil {
-			if err := protojson.Unmarshal(msg.Before, before); err != nil {
-				return fmt.Errorf("error unmarshaling 'Before' field from sns mutation event. why=%w", err)
-			}
+		return handler(ctx, input)
+	}
+}
+
+// decodeProtoMutationEvent decodes a published mutation event, decrypting and unmarshaling its "Before" and
+// "After" payloads into messages created with newMessage, or with the type registry of cfg if it has one.
+func decodeProtoMutationEvent[T proto.Message](ctx context.Context, s string, newMessage func() T, cfg mutationEventConfig) (models.ProtoMutationEvent[T], error) {
+	decoded, err := decodeMutationEvent(s, messageAttributes(ctx))
+	if err != nil {
+		return models.ProtoMutationEvent[T]{}, fmt.Errorf("error unmarshaling sns mutation event. why=%w", err)
+	}
+	if decoded.envelope.Encryption != nil {
+		decoded, err = cfg.decryptPayloads(ctx, decoded)
+		if err != nil {
+			return models.ProtoMutationEvent[T]{}, fmt.Errorf("error decrypting sns mutation event. why=%w", err)
 		}
+	}
+	msg := decoded.envelope
 
-		after := newMessage()
-		if msg.After != nil {
-			if err := protojson.Unmarshal(msg.After, after); err != nil {
-				return fmt.Errorf("error unmarshaling 'After' field from sns mutation event. why=%w", err)
-			}
+	if cfg.typeRegistry != nil {
+		newMessage, err = registryNewMessage[T](cfg.typeRegistry, msg)
+		if err != nil {
+			return models.ProtoMutationEvent[T]{}, fmt.Errorf("error resolving the type of sns mutation event. why=%w", err)
 		}
+	}
 
-		input := models.ProtoMutationEvent[T]{
-			EventID:       msg.EventID,
-			EventType:     msg.EventType,
-			EventTime:     msg.EventTime,
-			Source:        msg.Source,
-			CorrelationID: msg.CorrelationID,
-			ResourceType:  msg.ResourceType,
-			ResourceID:    msg.ResourceID,
-			UserID:        msg.UserID,
-			Reason:        msg.Reason,
-			Before:        before,
-			After:         after,
-			MetaData:      msg.MetaData,
+	// only unmarshal the before and after field if they are not nil
+	before := newMessage()
+	if decoded.before != nil {
+		if err := cfg.unmarshalPayload(decoded.format, decoded.before, before); err != nil {
+			return models.ProtoMutationEvent[T]{}, fmt.Errorf("error unmarshaling 'Before' field from sns mutation event. why=%w", err)
 		}
+	}
 
-		return handler(ctx, input)
+	after := newMessage()
+	if decoded.after != nil {
+		if err := cfg.unmarshalPayload(decoded.format, decoded.after, after); err != nil {
+			return models.ProtoMutationEvent[T]{}, fmt.Errorf("error unmarshaling 'After' field from sns mutation event. why=%w", err)
+		}
 	}
+
+	return models.ProtoMutationEvent[T]{
+		EventID:       msg.EventID,
+		EventType:     msg.EventType,
+		EventTime:     msg.EventTime,
+		Source:        msg.Source,
+		CorrelationID: msg.CorrelationID,
+		ResourceType:  msg.ResourceType,
+		ResourceID:    msg.ResourceID,
+		Sequence:      msg.Sequence,
+		UserID:        msg.UserID,
+		Reason:        msg.Reason,
+		Before:        before,
+		After:         after,
+		MetaData:      msg.MetaData,
+	}, nil
 }
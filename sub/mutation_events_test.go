@@ -0,0 +1,65 @@
+package sub
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/Iknite-Space/psss/models"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/sourcecontextpb"
+)
+
+func TestUnknownPayloadFields(t *testing.T) {
+	// an event from a producer using a newer version of the schema, with a field the consumer doesn't know about.
+	body, err := json.Marshal(models.PublishedProtoMutationEvent{
+		EventID:      "1",
+		EventType:    models.EventTypeCreated,
+		EventTime:    time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC),
+		ResourceType: "note",
+		ResourceID:   "1",
+		After:        json.RawMessage(`{"fileName":"notes.proto","lineCount":42}`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		opts    []MutationEventOption
+		wantErr bool
+	}{
+		{name: "default"},
+		{name: "discard unknown", opts: []MutationEventOption{WithProtoJSONUnmarshalOptions(protojson.UnmarshalOptions{DiscardUnknown: true})}},
+		{name: "reject unknown", opts: []MutationEventOption{WithProtoJSONUnmarshalOptions(protojson.UnmarshalOptions{})}, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got *sourcecontextpb.SourceContext
+			handler := MutationEventHandlerToStringHandler(
+				func(_ context.Context, event models.ProtoMutationEvent[*sourcecontextpb.SourceContext]) error {
+					got = event.After
+					return nil
+				},
+				func() *sourcecontextpb.SourceContext { return &sourcecontextpb.SourceContext{} },
+				test.opts...,
+			)
+
+			err := handler(context.Background(), string(body))
+			if test.wantErr {
+				if err == nil {
+					t.Error("handler() of an event with an unknown field succeeded")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("handler() = %v", err)
+			}
+			if got.GetFileName() != "notes.proto" {
+				t.Errorf("After = %v, want the known fields decoded", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package sub
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// ProcessorStats is a snapshot of the activity of a processor.
+type ProcessorStats struct {
+	Paused      bool             `json:"paused"`
+	Concurrency ConcurrencyStats `json:"concurrency"`
+
+	// Processed and Failed are the number of messages the handler succeeded and failed to handle.
+	Processed int64 `json:"processed"`
+	Failed    int64 `json:"failed"`
+
+	// LastError is the error the last failed message failed with.
+	LastError     string    `json:"last_error,omitempty"`
+	LastErrorTime time.Time `json:"last_error_time,omitzero"`
+}
+
+// Pause stops the processor from receiving messages until Resume is called. Messages in flight are still handled,
+// and a pending long poll is cancelled. Pausing a paused processor has no effect.
+func (s *SqsEventProcessor) Pause() {
+	if s.gate.pause() {
+		s.logger.Info().Msg("Pausing SQS event processor")
+	}
+}
+
+// Resume makes a paused processor receive messages again. Resuming a processor that isn't paused has no effect.
+func (s *SqsEventProcessor) Resume() {
+	if s.gate.resume() {
+		s.logger.Info().Msg("Resuming SQS event processor")
+	}
+}
+
+// Paused reports whether the processor is paused.
+func (s *SqsEventProcessor) Paused() bool {
+	return s.gate.isPaused()
+}
+
+// SetConcurrency changes the number of messages the processor handles concurrently while it is running. When
+// the concurrency is adaptive, the controller continues from n, within its bounds.
+func (s *SqsEventProcessor) SetConcurrency(n int) {
+	s.concurrencyLimiter().setLimit(n)
+}
+
+// Stats returns a snapshot of the activity of the processor.
+func (s *SqsEventProcessor) Stats() ProcessorStats {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+
+	return ProcessorStats{
+		Paused:        s.Paused(),
+		Concurrency:   s.Concurrency(),
+		Processed:     s.processed,
+		Failed:        s.failed,
+		LastError:     s.lastError,
+		LastErrorTime: s.lastErrorTime,
+	}
+}
+
+// recordResult updates the processor's stats with the outcome of handling a message.
+func (s *SqsEventProcessor) recordResult(err error) {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+
+	if err == nil {
+		s.processed++
+		return
+	}
+	s.failed++
+	s.lastError = err.Error()
+	s.lastErrorTime = time.Now()
+}
+
+// pauseGate blocks the receive loop of a processor while it is paused.
+type pauseGate struct {
+	mu      sync.Mutex
+	paused  bool
+	resumed chan struct{}
+
+	// cancelReceive cancels the pending ReceiveMessage call, if any.
+	cancelReceive context.CancelFunc
+}
+
+// pause pauses the gate and cancels the pending receive. It reports whether the gate was running.
+func (g *pauseGate) pause() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.paused {
+		return false
+	}
+	g.paused = true
+	g.resumed = make(chan struct{})
+	if g.cancelReceive != nil {
+		g.cancelReceive()
+	}
+	return true
+}
+
+// resume resumes the gate. It reports whether the gate was paused.
+func (g *pauseGate) resume() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if !g.paused {
+		return false
+	}
+	g.paused = false
+	close(g.resumed)
+	return true
+}
+
+func (g *pauseGate) isPaused() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.paused
+}
+
+// receiveContext blocks while the gate is paused and returns a context for a ReceiveMessage call, which is
+// cancelled when the gate is paused. It returns false if ctx is cancelled first.
+func (g *pauseGate) receiveContext(ctx context.Context) (context.Context, context.CancelFunc, bool) {
+	for {
+		g.mu.Lock()
+		if !g.paused {
+			receiveCtx, cancel := context.WithCancel(ctx)
+			g.cancelReceive = cancel
+			g.mu.Unlock()
+			return receiveCtx, cancel, true
+		}
+		resumed := g.resumed
+		g.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, nil, false
+		case <-resumed:
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package sub
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	awstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+func TestPausedProcessorStopsReceiving(t *testing.T) {
+	fake := &fakeSqs{queue: []awstypes.Message{newTestMessage(1, "{}")}}
+	var handled atomic.Int32
+	processor := NewSqsEventProcessor(fake.client(), testQueueURL, func(context.Context, awstypes.Message) error {
+		handled.Add(1)
+		return nil
+	})
+
+	// a processor paused before it runs doesn't receive anything.
+	processor.Pause()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- processor.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	if n := fake.receiveCount(); n != 0 {
+		t.Errorf("paused processor called ReceiveMessage %d times", n)
+	}
+	if !processor.Paused() || !processor.Stats().Paused {
+		t.Error("Paused() = false for a paused processor")
+	}
+
+	processor.Resume()
+	waitFor(t, "the message to be handled", func() bool { return handled.Load() == 1 })
+
+	// pausing a running processor stops its receive loop, once the call in progress returns.
+	processor.Pause()
+	time.Sleep(10 * time.Millisecond)
+	receives := fake.receiveCount()
+	time.Sleep(50 * time.Millisecond)
+	if n := fake.receiveCount(); n != receives {
+		t.Errorf("paused processor called ReceiveMessage %d more times", n-receives)
+	}
+
+	processor.Resume()
+	waitFor(t, "the processor to receive again", func() bool { return fake.receiveCount() > receives })
+}
+
+func TestPausedProcessorStops(t *testing.T) {
+	fake := &fakeSqs{}
+	processor := NewSqsEventProcessor(fake.client(), testQueueURL, func(context.Context, awstypes.Message) error {
+		return nil
+	})
+	processor.Pause()
+
+	// cancelling the context of a paused processor stops it.
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := processor.Run(ctx)
+	if err != nil {
+		t.Errorf("Run() = %v", err)
+	}
+}
+
+func TestProcessorStats(t *testing.T) {
+	processor := NewSqsEventProcessor(nil, testQueueURL, nil).WithConcurrency(4)
+
+	processor.recordResult(nil)
+	processor.recordResult(nil)
+	processor.recordResult(errors.New("boom"))
+
+	stats := processor.Stats()
+	if stats.Processed != 2 || stats.Failed != 1 {
+		t.Errorf("Stats() processed %d, failed %d, want 2, 1", stats.Processed, stats.Failed)
+	}
+	if stats.LastError != "boom" || stats.LastErrorTime.IsZero() {
+		t.Errorf("Stats() last error %q at %v, want boom", stats.LastError, stats.LastErrorTime)
+	}
+	if stats.Concurrency.Limit != 4 {
+		t.Errorf("Stats() concurrency limit %d, want 4", stats.Concurrency.Limit)
+	}
+
+	processor.SetConcurrency(2)
+	if limit := processor.Stats().Concurrency.Limit; limit != 2 {
+		t.Errorf("Stats() concurrency limit %d after SetConcurrency(2), want 2", limit)
+	}
+}
@@ -0,0 +1,145 @@
+package sub
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/ssocreds"
+	"github.com/aws/smithy-go"
+)
+
+const (
+	defaultReceiveBackoff    = 500 * time.Millisecond
+	defaultMaxReceiveBackoff = 30 * time.Second
+)
+
+// fatalReceiveErrorCodes are the error codes of ReceiveMessage failures that retrying won't fix, such as a missing
+// queue, missing permissions or expired credentials. UnauthorizedException is returned by SSO when the session the
+// credentials are fetched with has expired.
+var fatalReceiveErrorCodes = map[string]struct{}{
+	"QueueDoesNotExist":                       {},
+	"AWS.SimpleQueueService.NonExistentQueue": {},
+	"AccessDenied":                            {},
+	"AccessDeniedException":                   {},
+	"InvalidAddress":                          {},
+	"InvalidSecurity":                         {},
+	"InvalidClientTokenId":                    {},
+	"UnrecognizedClientException":             {},
+	"SignatureDoesNotMatch":                   {},
+	"KmsAccessDenied":                         {},
+	"MissingAuthenticationToken":              {},
+	"ExpiredToken":                            {},
+	"ExpiredTokenException":                   {},
+	"UnauthorizedException":                   {},
+}
+
+// fatalCredentialsErrorMessages are the messages of the credentials errors the SDK returns without a dedicated
+// type. The default credentials chain ends with the EC2 instance role, so failing to find one means that no
+// credentials are configured.
+var fatalCredentialsErrorMessages = []string{
+	"no EC2 IMDS role found",
+}
+
+// ReceiveErrorHookFn is called by SqsEventProcessor.Run each time ReceiveMessage fails, with the number of
+// consecutive failures so far (starting at 1) and whether the error is fatal, in which case Run returns it.
+type ReceiveErrorHookFn func(err error, consecutiveFailures int, fatal bool)
+
+// IsFatalReceiveError reports whether err is a ReceiveMessage error that retrying won't fix, such as a missing
+// queue, access being denied, or credentials that can't be used: no credentials configured, empty static
+// credentials, or an expired SSO session. Any other error, e.g. throttling, a network failure or a
+// temporary failure to fetch credentials, is considered transient.
+func IsFatalReceiveError(err error) bool {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		_, ok := fatalReceiveErrorCodes[apiErr.ErrorCode()]
+		return ok
+	}
+
+	return isFatalCredentialsError(err)
+}
+
+// isFatalCredentialsError reports whether err is a failure to get credentials that retrying won't fix.
+func isFatalCredentialsError(err error) bool {
+	var invalidToken *ssocreds.InvalidTokenError
+	var emptyCredentials *credentials.StaticCredentialsEmptyError
+	if errors.As(err, &invalidToken) || errors.As(err, &emptyCredentials) {
+		return true
+	}
+
+	var operationErr *smithy.OperationError
+	if !errors.As(err, &operationErr) {
+		return false
+	}
+	for _, message := range fatalCredentialsErrorMessages {
+		if strings.Contains(operationErr.Err.Error(), message) {
+			return true
+		}
+	}
+	return false
+}
+
+// WithReceiveBackoff sets the delay before retrying after the first failed ReceiveMessage call. The delay doubles
+// with each consecutive failure, up to maxDelay. Defaults to 500ms and 30s.
+func (s *SqsEventProcessor) WithReceiveBackoff(initial, maxDelay time.Duration) *SqsEventProcessor {
+	s.receiveBackoff = initial
+	s.maxReceiveBackoff = maxDelay
+	return s
+}
+
+// WithMaxReceiveFailures sets the number of consecutive transient ReceiveMessage failures after which Run gives up
+// and returns the last error. Zero, the default, retries forever.
+func (s *SqsEventProcessor) WithMaxReceiveFailures(n int) *SqsEventProcessor {
+	s.maxReceiveFailures = n
+	return s
+}
+
+// WithReceiveErrorHook sets a function called on every failed ReceiveMessage call, e.g. to export metrics or to
+// alert on long outages.
+func (s *SqsEventProcessor) WithReceiveErrorHook(hook ReceiveErrorHookFn) *SqsEventProcessor {
+	s.receiveErrorHook = hook
+	return s
+}
+
+// receiveBackoffDelay returns the delay before the next ReceiveMessage call after the given number of consecutive
+// failures.
+func (s *SqsEventProcessor) receiveBackoffDelay(consecutiveFailures int) time.Duration {
+	initial := s.receiveBackoff
+	if initial <= 0 {
+		initial = defaultReceiveBackoff
+	}
+	maxDelay := s.maxReceiveBackoff
+	if maxDelay <= 0 {
+		maxDelay = defaultMaxReceiveBackoff
+	}
+	return backoffDelay(initial, maxDelay, consecutiveFailures)
+}
+
+// backoffDelay returns the delay before the next attempt after the given number of consecutive failures. The delay
+// is jittered between half and all of the capped exponential delay, so that replicas failing at the same time don't
+// retry in lockstep.
+func backoffDelay(initial, maxDelay time.Duration, consecutiveFailures int) time.Duration {
+	delay := initial
+	for i := 1; i < consecutiveFailures && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, maxDelay)
+
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// sleep waits for d, returning false if ctx is cancelled first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,73 @@
+package sub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/ec2rolecreds"
+	"github.com/aws/aws-sdk-go-v2/credentials/ssocreds"
+	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/smithy-go"
+)
+
+func TestIsFatalReceiveError(t *testing.T) {
+	// operationError wraps err as the SDK does for the errors of an API call.
+	operationError := func(err error) error {
+		return &smithy.OperationError{ServiceID: "SQS", OperationName: "ReceiveMessage", Err: err}
+	}
+	apiError := func(code string) error {
+		return operationError(&smithy.GenericAPIError{Code: code, Message: code})
+	}
+	// credentialsError wraps err as the SDK does when the credentials provider fails.
+	credentialsError := func(err error) error {
+		return operationError(fmt.Errorf("get identity: get credentials: failed to refresh cached credentials, %w", err))
+	}
+
+	// without credentials the default chain falls back to the EC2 instance role, which isn't available here.
+	provider := ec2rolecreds.New(func(o *ec2rolecreds.Options) {
+		o.Client = imds.New(imds.Options{Endpoint: "http://127.0.0.1:1", Retryer: aws.NopRetryer{}})
+	})
+	client := sqs.New(sqs.Options{Region: "us-east-1", Credentials: aws.NewCredentialsCache(provider), Retryer: aws.NopRetryer{}})
+	_, noCredentialsErr := client.ReceiveMessage(context.Background(), &sqs.ReceiveMessageInput{
+		QueueUrl: aws.String("https://sqs.us-east-1.amazonaws.com/123456789012/notes"),
+	})
+	if noCredentialsErr == nil {
+		t.Fatal("ReceiveMessage without credentials succeeded")
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "missing queue", err: apiError("AWS.SimpleQueueService.NonExistentQueue"), want: true},
+		{name: "access denied", err: apiError("AccessDenied"), want: true},
+		{name: "invalid access key", err: apiError("InvalidClientTokenId"), want: true},
+		{name: "expired token", err: apiError("ExpiredToken"), want: true},
+		{name: "throttling", err: apiError("ThrottlingException"), want: false},
+		{name: "internal error", err: apiError("InternalError"), want: false},
+		{name: "no credentials", err: noCredentialsErr, want: true},
+		{name: "expired sso token", err: credentialsError(&ssocreds.InvalidTokenError{Err: errors.New("token expired")}), want: true},
+		{name: "expired sso session", err: credentialsError(&smithy.GenericAPIError{Code: "UnauthorizedException"}), want: true},
+		{name: "empty static credentials", err: credentialsError(&credentials.StaticCredentialsEmptyError{}), want: true},
+		{name: "unreachable credentials endpoint", err: credentialsError(&net.OpError{Op: "dial", Err: errors.New("connection refused")}), want: false},
+		{name: "network failure", err: operationError(&net.OpError{Op: "dial", Err: errors.New("connection refused")}), want: false},
+		{name: "cancelled", err: operationError(context.Canceled), want: false},
+		{name: "outside of an api call", err: errors.New("no EC2 IMDS role found"), want: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := IsFatalReceiveError(test.err)
+			if got != test.want {
+				t.Errorf("IsFatalReceiveError(%v) = %v, want %v", test.err, got, test.want)
+			}
+		})
+	}
+}
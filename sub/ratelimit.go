@@ -0,0 +1,93 @@
+package sub
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// WithRateLimit limits the processor to perSecond handler invocations per second on average, with bursts of up to
+// burst invocations. When the budget is exhausted the processor stops receiving messages until it is refilled, so
+// that messages stay in the queue instead of expiring back into it while waiting to be handled.
+// A perSecond of zero or less disables rate limiting.
+func (s *SqsEventProcessor) WithRateLimit(perSecond float64, burst int) *SqsEventProcessor {
+	s.rateLimiter = nil
+	if perSecond > 0 {
+		s.rateLimiter = newTokenBucket(perSecond, max(burst, 1), time.Now)
+	}
+	return s
+}
+
+// tokenBucket is a token bucket rate limiter. It holds up to burst tokens and is refilled at rate tokens per
+// second. Tokens can be taken beyond the available ones, in which case the bucket goes into debt and callers wait
+// until it is paid back.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+func newTokenBucket(rate float64, burst int, now func() time.Time) *tokenBucket {
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   now(),
+		now:    now,
+	}
+}
+
+// refill adds the tokens accumulated since the last refill. It must be called with b.mu held.
+func (b *tokenBucket) refill() {
+	now := b.now()
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = min(b.burst, b.tokens+elapsed.Seconds()*b.rate)
+	}
+	b.last = now
+}
+
+// available returns the number of whole tokens currently in the bucket.
+func (b *tokenBucket) available() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill()
+	return max(int(b.tokens), 0)
+}
+
+// delay returns how long to wait until at least one token is available.
+func (b *tokenBucket) delay() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill()
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// take removes n tokens from the bucket.
+func (b *tokenBucket) take(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill()
+	b.tokens -= float64(n)
+}
+
+// wait blocks until at least one token is available, returning false if ctx is cancelled first.
+func (b *tokenBucket) wait(ctx context.Context) bool {
+	for {
+		d := b.delay()
+		if d <= 0 {
+			return true
+		}
+		if !sleep(ctx, d) {
+			return false
+		}
+	}
+}
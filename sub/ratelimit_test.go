@@ -0,0 +1,42 @@
+package sub
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	bucket := newTokenBucket(10, 5, clock.Now)
+
+	assertBucket := func(wantAvailable int, wantDelay time.Duration) {
+		t.Helper()
+		if got := bucket.available(); got != wantAvailable {
+			t.Errorf("available() = %d, want %d", got, wantAvailable)
+		}
+		if got := bucket.delay(); got != wantDelay {
+			t.Errorf("delay() = %v, want %v", got, wantDelay)
+		}
+	}
+
+	// the bucket starts full and allows a burst of 5.
+	assertBucket(5, 0)
+	bucket.take(5)
+	assertBucket(0, 100*time.Millisecond)
+
+	// it is refilled at 10 tokens per second.
+	clock.Advance(250 * time.Millisecond)
+	assertBucket(2, 0)
+
+	// taking more tokens than available puts it in debt, which is paid back before the next token.
+	bucket.take(4)
+	assertBucket(0, 250*time.Millisecond)
+	clock.Advance(200 * time.Millisecond)
+	assertBucket(0, 50*time.Millisecond)
+	clock.Advance(50 * time.Millisecond)
+	assertBucket(1, 0)
+
+	// it never holds more than the burst.
+	clock.Advance(time.Hour)
+	assertBucket(5, 0)
+}
@@ -0,0 +1,41 @@
+package sub
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Iknite-Space/psss/signing"
+	awstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// WithSignatureVerifier makes the processor verify the signature of every message before handling it. Tampered
+// messages fail permanently and are deleted. Unsigned messages and messages signed with an unknown key are released
+// to be retried, since the key may not be rolled out to the consumers yet, and end up in the dead letter queue if it
+// never is.
+func (s *SqsEventProcessor) WithSignatureVerifier(verifier *signing.Verifier) *SqsEventProcessor {
+	s.verifier = verifier
+	return s
+}
+
+// verifyMessage verifies the signature of a raw delivery or of the message wrapped in an SNS notification.
+func verifyMessage(verifier *signing.Verifier, message awstypes.Message) error {
+	if message.Body == nil {
+		return errors.New("body is nil")
+	}
+
+	if sw, ok := parseSnsNotification(*message.Body); ok {
+		return verifier.Verify(sw.Message, snsMessageAttributes(sw))
+	}
+
+	return verifier.Verify(*message.Body, sqsMessageAttributes(message))
+}
+
+// signatureError wraps an error returned by signing.Verifier.Verify. Only invalid signatures fail permanently: the
+// other errors may be fixed by rolling out the signing key to the consumer, so the message is retried.
+func signatureError(err error) error {
+	err = fmt.Errorf("failed to verify message signature: %w", err)
+	if errors.Is(err, signing.ErrInvalidSignature) {
+		return Permanent(err)
+	}
+	return err
+}
@@ -0,0 +1,59 @@
+package sub
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Iknite-Space/psss/models"
+	"github.com/Iknite-Space/psss/signing"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+func TestPrepareMessageVerifiesSignature(t *testing.T) {
+	const body = `{"event_id":"1"}`
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	processor := NewSqsEventProcessor(nil, "", func(context.Context, awstypes.Message) error { return nil }).
+		WithSignatureVerifier(signing.NewVerifier(map[string][]byte{"key-1": secret}))
+
+	message := func(body string, signer *signing.Signer) awstypes.Message {
+		attributes := map[string]string{models.AttributeContentType: models.ContentTypeJSON}
+		if signer != nil {
+			signer.Sign(body, attributes)
+		}
+		message := awstypes.Message{Body: aws.String(body), MessageAttributes: make(map[string]awstypes.MessageAttributeValue)}
+		for name, value := range attributes {
+			message.MessageAttributes[name] = awstypes.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(value)}
+		}
+		return message
+	}
+
+	tests := []struct {
+		name          string
+		message       awstypes.Message
+		wantErr       bool
+		wantPermanent bool
+	}{
+		{name: "signed", message: message(body, signing.NewSigner("key-1", secret))},
+		{name: "tampered", message: func() awstypes.Message {
+			m := message(body, signing.NewSigner("key-1", secret))
+			m.Body = aws.String(`{"event_id":"2"}`)
+			return m
+		}(), wantErr: true, wantPermanent: true},
+		// the key may not be rolled out to the consumer yet, so the message is retried.
+		{name: "unknown key", message: message(body, signing.NewSigner("key-2", secret)), wantErr: true},
+		{name: "unsigned", message: message(body, nil), wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := processor.prepareMessage(test.message)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("prepareMessage() = %v, want an error: %v", err, test.wantErr)
+			}
+			if IsPermanent(err) != test.wantPermanent {
+				t.Errorf("IsPermanent(%v) = %v, want %v", err, IsPermanent(err), test.wantPermanent)
+			}
+		})
+	}
+}
@@ -0,0 +1,411 @@
+package sub
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1" //nolint:gosec // SNS SignatureVersion 1 signatures use SHA1.
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Iknite-Space/psss/models"
+	"github.com/Iknite-Space/psss/signing"
+	"github.com/rs/zerolog"
+)
+
+const (
+	snsTypeSubscriptionConfirmation = "SubscriptionConfirmation"
+	snsTypeUnsubscribeConfirmation  = "UnsubscribeConfirmation"
+
+	// maxSnsHTTPBodySize bounds the size of accepted requests, SNS messages are at most 256KB plus the envelope.
+	maxSnsHTTPBodySize = 1 << 20
+)
+
+// snsHostPattern matches the hosts SNS serves signing certificates and subscription URLs from.
+var snsHostPattern = regexp.MustCompile(`^sns\.[a-z0-9-]+\.amazonaws\.com(\.cn)?$`)
+
+// CertificateFetcher fetches the certificate used to verify the signature of SNS messages.
+type CertificateFetcher interface {
+	FetchCertificate(ctx context.Context, certURL string) (*x509.Certificate, error)
+}
+
+// HTTPCertificateFetcher fetches SNS signing certificates over HTTPS, only from SNS hosts, and caches them by URL.
+type HTTPCertificateFetcher struct {
+	client *http.Client
+	cache  sync.Map
+}
+
+var _ CertificateFetcher = (*HTTPCertificateFetcher)(nil)
+
+// NewHTTPCertificateFetcher creates an HTTPCertificateFetcher using the given HTTP client.
+func NewHTTPCertificateFetcher(client *http.Client) *HTTPCertificateFetcher {
+	return &HTTPCertificateFetcher{
+		client: client,
+	}
+}
+
+// FetchCertificate implements CertificateFetcher.
+func (f *HTTPCertificateFetcher) FetchCertificate(ctx context.Context, certURL string) (*x509.Certificate, error) {
+	if cert, ok := f.cache.Load(certURL); ok {
+		return cert.(*x509.Certificate), nil
+	}
+
+	err := validateSnsURL(certURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signing certificate url: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, certURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate request: %w", err)
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch certificate: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch certificate: unexpected status %d", resp.StatusCode)
+	}
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxSnsHTTPBodySize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate: %w", err)
+	}
+
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("certificate is not PEM encoded")
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %w", err)
+	}
+
+	f.cache.Store(certURL, cert)
+	return cert, nil
+}
+
+// snsHTTPMessage is a message delivered by SNS to an HTTP/HTTPS subscription.
+type snsHTTPMessage struct {
+	Type              string                         `json:"Type"`
+	MessageID         string                         `json:"MessageId"`
+	Token             string                         `json:"Token"`
+	TopicArn          string                         `json:"TopicArn"`
+	Subject           *string                        `json:"Subject"`
+	Message           string                         `json:"Message"`
+	Timestamp         string                         `json:"Timestamp"`
+	SignatureVersion  string                         `json:"SignatureVersion"`
+	Signature         string                         `json:"Signature"`
+	SigningCertURL    string                         `json:"SigningCertURL"`
+	SubscribeURL      string                         `json:"SubscribeURL"`
+	MessageAttributes map[string]SnsMessageAttribute `json:"MessageAttributes"`
+}
+
+// SnsHTTPHandler is an http.Handler receiving the messages SNS delivers to HTTP/HTTPS subscriptions. It verifies
+// the SNS signature of every message, confirms the subscriptions of the topics allowed with WithTopicArns
+// automatically, and passes the Message of each notification to a StringHandlerFn, e.g. one built with
+// MutationEventHandlerToStringHandler.
+//
+// The response status drives SNS retries: messages that are handled, and confirmation messages, are answered with
+// 200; messages that can't be trusted or parsed, subscription confirmations that aren't confirmed automatically,
+// and permanent handler failures, with a 4xx status so that SNS doesn't retry them; other handler failures with
+// 500 so that SNS retries them according to the subscription's delivery policy.
+type SnsHTTPHandler struct {
+	handler            StringHandlerFn
+	certificateFetcher CertificateFetcher
+	httpClient         *http.Client
+	topicArns          map[string]bool
+	verifier           *signing.Verifier
+	logger             zerolog.Logger
+}
+
+var _ http.Handler = (*SnsHTTPHandler)(nil)
+
+// NewSnsHTTPHandler creates an SnsHTTPHandler passing notifications to handler.
+func NewSnsHTTPHandler(handler StringHandlerFn) *SnsHTTPHandler {
+	return &SnsHTTPHandler{
+		handler:            handler,
+		certificateFetcher: NewHTTPCertificateFetcher(http.DefaultClient),
+		httpClient:         http.DefaultClient,
+		logger:             zerolog.Nop(),
+	}
+}
+
+// WithLogger sets the logger for the SnsHTTPHandler.
+func (h *SnsHTTPHandler) WithLogger(logger zerolog.Logger) *SnsHTTPHandler {
+	h.logger = logger
+	return h
+}
+
+// WithCertificateFetcher sets how SNS signing certificates are fetched, e.g. to use a local certificate in tests.
+func (h *SnsHTTPHandler) WithCertificateFetcher(fetcher CertificateFetcher) *SnsHTTPHandler {
+	h.certificateFetcher = fetcher
+	return h
+}
+
+// WithHTTPClient sets the HTTP client used to confirm subscriptions.
+func (h *SnsHTTPHandler) WithHTTPClient(client *http.Client) *SnsHTTPHandler {
+	h.httpClient = client
+	return h
+}
+
+// WithTopicArns restricts the handler to messages from the given topics. Subscriptions are only confirmed
+// automatically for these topics: without an allow-list, anyone could subscribe the endpoint to their own topic,
+// so subscription confirmations are rejected and subscriptions must be confirmed out of band, e.g. with the
+// ConfirmSubscription API. Notifications from any topic are accepted by default.
+func (h *SnsHTTPHandler) WithTopicArns(topicArns ...string) *SnsHTTPHandler {
+	h.topicArns = make(map[string]bool, len(topicArns))
+	for _, topicArn := range topicArns {
+		h.topicArns[topicArn] = true
+	}
+	return h
+}
+
+// WithSignatureVerifier makes the handler also verify the psss signature of notifications, as done by
+// SqsEventProcessor.WithSignatureVerifier.
+func (h *SnsHTTPHandler) WithSignatureVerifier(verifier *signing.Verifier) *SnsHTTPHandler {
+	h.verifier = verifier
+	return h
+}
+
+// ServeHTTP implements http.Handler.
+func (h *SnsHTTPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	b, err := io.ReadAll(io.LimitReader(r.Body, maxSnsHTTPBodySize))
+	if err != nil {
+		http.Error(w, "failed to read body", http.StatusBadRequest)
+		return
+	}
+
+	var msg snsHTTPMessage
+	err = json.Unmarshal(b, &msg)
+	if err != nil {
+		h.logger.Debug().Err(err).Msg("Received invalid SNS message")
+		http.Error(w, "invalid SNS message", http.StatusBadRequest)
+		return
+	}
+
+	logger := h.logger.With().Str("message_id", msg.MessageID).Str("topic_arn", msg.TopicArn).
+		Str("type", msg.Type).Logger()
+
+	if h.topicArns != nil && !h.topicArns[msg.TopicArn] {
+		logger.Warn().Msg("Rejecting SNS message from unexpected topic")
+		http.Error(w, "unexpected topic", http.StatusForbidden)
+		return
+	}
+
+	err = h.verify(r.Context(), msg)
+	if err != nil {
+		logger.Warn().Err(err).Msg("Rejecting SNS message with invalid signature")
+		http.Error(w, "invalid signature", http.StatusForbidden)
+		return
+	}
+
+	switch msg.Type {
+	case snsTypeSubscriptionConfirmation:
+		if h.topicArns == nil {
+			logger.Warn().Str("subscribe_url", msg.SubscribeURL).
+				Msg("Not confirming SNS subscription since no topics are allowed, see WithTopicArns")
+			http.Error(w, "subscriptions are not confirmed automatically", http.StatusForbidden)
+			return
+		}
+		err = h.confirmSubscription(r.Context(), msg)
+		if err != nil {
+			logger.Error().Err(err).Msg("Failed to confirm SNS subscription")
+			http.Error(w, "failed to confirm subscription", http.StatusInternalServerError)
+			return
+		}
+		logger.Info().Msg("Confirmed SNS subscription")
+	case snsTypeUnsubscribeConfirmation:
+		logger.Info().Msg("SNS subscription was removed")
+	case snsTypeNotification:
+		err = h.handleNotification(logger.WithContext(r.Context()), msg)
+		if IsPermanent(err) {
+			logger.Error().Err(err).Msg("SNS notification failed permanently")
+			http.Error(w, "notification failed permanently", http.StatusUnprocessableEntity)
+			return
+		}
+		if err != nil {
+			// Note: This is a debug message because "true" errors should be logged by the handling function.
+			logger.Debug().Err(err).Msg("Error processing SNS notification")
+			http.Error(w, "failed to process notification", http.StatusInternalServerError)
+			return
+		}
+	default:
+		logger.Warn().Msg("Received SNS message of unknown type")
+		http.Error(w, "unknown message type", http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleNotification decompresses the notification's message if needed, verifies its psss signature and passes it
+// to the handler.
+func (h *SnsHTTPHandler) handleNotification(ctx context.Context, msg snsHTTPMessage) error {
+	attributes := snsMessageAttributes(SnsWrapper{MessageAttributes: msg.MessageAttributes})
+	body := msg.Message
+
+	if algorithm, ok := attributes[models.AttributeContentEncoding]; ok {
+		var err error
+		body, err = decompressBody(algorithm, body)
+		if err != nil {
+			return Permanent(err)
+		}
+		delete(attributes, models.AttributeContentEncoding)
+	}
+
+	if h.verifier != nil {
+		err := h.verifier.Verify(body, attributes)
+		if err != nil {
+			return signatureError(err)
+		}
+	}
+
+	info := MessageInfo{
+		SnsMessageID:      msg.MessageID,
+		TopicArn:          msg.TopicArn,
+		MessageAttributes: attributes,
+	}
+	if msg.Subject != nil {
+		info.Subject = *msg.Subject
+	}
+	if timestamp, err := time.Parse(time.RFC3339Nano, msg.Timestamp); err == nil {
+		info.Timestamp = timestamp
+	}
+
+	return h.handler(WithMessageInfo(ctx, info), body)
+}
+
+// confirmSubscription confirms a subscription by visiting its SubscribeURL.
+func (h *SnsHTTPHandler) confirmSubscription(ctx context.Context, msg snsHTTPMessage) error {
+	err := validateSnsURL(msg.SubscribeURL)
+	if err != nil {
+		return fmt.Errorf("invalid subscribe url: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, msg.SubscribeURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create confirmation request: %w", err)
+	}
+
+	resp, err := h.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to confirm subscription: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to confirm subscription: unexpected status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// verify checks the SNS signature of the message, see
+// https://docs.aws.amazon.com/sns/latest/dg/sns-verify-signature-of-message.html.
+func (h *SnsHTTPHandler) verify(ctx context.Context, msg snsHTTPMessage) error {
+	var hash crypto.Hash
+	switch msg.SignatureVersion {
+	case "1":
+		hash = crypto.SHA1
+	case "2":
+		hash = crypto.SHA256
+	default:
+		return fmt.Errorf("unsupported signature version %q", msg.SignatureVersion)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(msg.Signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding: %w", err)
+	}
+
+	cert, err := h.certificateFetcher.FetchCertificate(ctx, msg.SigningCertURL)
+	if err != nil {
+		return fmt.Errorf("failed to fetch signing certificate: %w", err)
+	}
+
+	publicKey, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return errors.New("signing certificate doesn't hold an RSA key")
+	}
+
+	var digest []byte
+	if hash == crypto.SHA1 {
+		sum := sha1.Sum([]byte(snsStringToSign(msg))) //nolint:gosec // mandated by SignatureVersion 1.
+		digest = sum[:]
+	} else {
+		sum := sha256.Sum256([]byte(snsStringToSign(msg)))
+		digest = sum[:]
+	}
+
+	return rsa.VerifyPKCS1v15(publicKey, hash, digest, signature)
+}
+
+// snsStringToSign builds the canonical string SNS signs for the message type.
+func snsStringToSign(msg snsHTTPMessage) string {
+	var b strings.Builder
+	add := func(key, value string) {
+		b.WriteString(key)
+		b.WriteByte('\n')
+		b.WriteString(value)
+		b.WriteByte('\n')
+	}
+
+	add("Message", msg.Message)
+	add("MessageId", msg.MessageID)
+	if msg.Type == snsTypeNotification {
+		if msg.Subject != nil {
+			add("Subject", *msg.Subject)
+		}
+		add("Timestamp", msg.Timestamp)
+		add("TopicArn", msg.TopicArn)
+		add("Type", msg.Type)
+		return b.String()
+	}
+
+	add("SubscribeURL", msg.SubscribeURL)
+	add("Timestamp", msg.Timestamp)
+	add("Token", msg.Token)
+	add("TopicArn", msg.TopicArn)
+	add("Type", msg.Type)
+	return b.String()
+}
+
+// validateSnsURL checks that rawURL is an HTTPS URL served by SNS.
+func validateSnsURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+
+	if u.Scheme != "https" {
+		return fmt.Errorf("scheme %q is not https", u.Scheme)
+	}
+
+	if !snsHostPattern.MatchString(u.Hostname()) {
+		return fmt.Errorf("host %q is not an SNS host", u.Hostname())
+	}
+
+	return nil
+}
@@ -0,0 +1,339 @@
+package sub
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1" //nolint:gosec // SNS SignatureVersion 1 signatures use SHA1.
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testTopicArn = "arn:aws:sns:us-east-1:123456789012:notes"
+	testCertURL  = "https://sns.us-east-1.amazonaws.com/SimpleNotificationService-test.pem"
+)
+
+// snsTestSigner signs SNS messages with a self-signed certificate, and serves it as a CertificateFetcher.
+type snsTestSigner struct {
+	key  *rsa.PrivateKey
+	cert *x509.Certificate
+}
+
+func newSnsTestSigner(t *testing.T) *snsTestSigner {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sns.amazonaws.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &snsTestSigner{key: key, cert: cert}
+}
+
+// FetchCertificate implements CertificateFetcher.
+func (s *snsTestSigner) FetchCertificate(_ context.Context, certURL string) (*x509.Certificate, error) {
+	if certURL != testCertURL {
+		return nil, errors.New("unknown certificate")
+	}
+	return s.cert, nil
+}
+
+// sign signs msg with the given signature version.
+func (s *snsTestSigner) sign(t *testing.T, msg *snsHTTPMessage, version string) {
+	t.Helper()
+
+	msg.SignatureVersion = version
+	msg.SigningCertURL = testCertURL
+
+	var hash crypto.Hash
+	var digest []byte
+	if version == "1" {
+		hash = crypto.SHA1
+		sum := sha1.Sum([]byte(snsStringToSign(*msg))) //nolint:gosec // mandated by SignatureVersion 1.
+		digest = sum[:]
+	} else {
+		hash = crypto.SHA256
+		sum := sha256.Sum256([]byte(snsStringToSign(*msg)))
+		digest = sum[:]
+	}
+
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.key, hash, digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg.Signature = base64.StdEncoding.EncodeToString(signature)
+}
+
+func newTestNotification() snsHTTPMessage {
+	return snsHTTPMessage{
+		Type:      snsTypeNotification,
+		MessageID: "22b80b92-fdea-4c2c-8f9d-bdfb0c7bf324",
+		TopicArn:  testTopicArn,
+		Message:   `{"event_id":"1"}`,
+		Timestamp: "2025-01-01T00:00:00.000Z",
+	}
+}
+
+func newTestSubscriptionConfirmation() snsHTTPMessage {
+	return snsHTTPMessage{
+		Type:         snsTypeSubscriptionConfirmation,
+		MessageID:    "165545c9-2a5c-472c-8df2-7ff2be2b3b1b",
+		Token:        "2336412f37f",
+		TopicArn:     testTopicArn,
+		Message:      "You have chosen to subscribe to the topic.",
+		SubscribeURL: "https://sns.us-east-1.amazonaws.com/?Action=ConfirmSubscription&Token=2336412f37f",
+		Timestamp:    "2025-01-01T00:00:00.000Z",
+	}
+}
+
+func TestSnsStringToSign(t *testing.T) {
+	notification := newTestNotification()
+	want := "Message\n{\"event_id\":\"1\"}\n" +
+		"MessageId\n22b80b92-fdea-4c2c-8f9d-bdfb0c7bf324\n" +
+		"Timestamp\n2025-01-01T00:00:00.000Z\n" +
+		"TopicArn\n" + testTopicArn + "\n" +
+		"Type\nNotification\n"
+	if got := snsStringToSign(notification); got != want {
+		t.Errorf("notification string to sign = %q, want %q", got, want)
+	}
+
+	subject := "note updated"
+	notification.Subject = &subject
+	want = "Message\n{\"event_id\":\"1\"}\n" +
+		"MessageId\n22b80b92-fdea-4c2c-8f9d-bdfb0c7bf324\n" +
+		"Subject\nnote updated\n" +
+		"Timestamp\n2025-01-01T00:00:00.000Z\n" +
+		"TopicArn\n" + testTopicArn + "\n" +
+		"Type\nNotification\n"
+	if got := snsStringToSign(notification); got != want {
+		t.Errorf("notification with subject string to sign = %q, want %q", got, want)
+	}
+
+	confirmation := newTestSubscriptionConfirmation()
+	want = "Message\nYou have chosen to subscribe to the topic.\n" +
+		"MessageId\n165545c9-2a5c-472c-8df2-7ff2be2b3b1b\n" +
+		"SubscribeURL\nhttps://sns.us-east-1.amazonaws.com/?Action=ConfirmSubscription&Token=2336412f37f\n" +
+		"Timestamp\n2025-01-01T00:00:00.000Z\n" +
+		"Token\n2336412f37f\n" +
+		"TopicArn\n" + testTopicArn + "\n" +
+		"Type\nSubscriptionConfirmation\n"
+	if got := snsStringToSign(confirmation); got != want {
+		t.Errorf("confirmation string to sign = %q, want %q", got, want)
+	}
+}
+
+func TestSnsHTTPHandlerVerify(t *testing.T) {
+	ctx := context.Background()
+	signer := newSnsTestSigner(t)
+	handler := NewSnsHTTPHandler(nil).WithCertificateFetcher(signer)
+
+	for _, version := range []string{"1", "2"} {
+		msg := newTestNotification()
+		signer.sign(t, &msg, version)
+		err := handler.verify(ctx, msg)
+		if err != nil {
+			t.Errorf("verify(SignatureVersion %s) = %v", version, err)
+		}
+
+		tampered := msg
+		tampered.Message = `{"event_id":"2"}`
+		err = handler.verify(ctx, tampered)
+		if err == nil {
+			t.Errorf("verify(SignatureVersion %s) of a tampered message succeeded", version)
+		}
+	}
+
+	tests := map[string]func(msg *snsHTTPMessage){
+		"unsupported version": func(msg *snsHTTPMessage) { msg.SignatureVersion = "3" },
+		"invalid encoding":    func(msg *snsHTTPMessage) { msg.Signature = "not base64!" },
+		"unknown certificate": func(msg *snsHTTPMessage) { msg.SigningCertURL = "https://sns.us-east-1.amazonaws.com/other.pem" },
+		"other key": func(msg *snsHTTPMessage) {
+			other := newSnsTestSigner(t)
+			other.sign(t, msg, "2")
+		},
+	}
+	for name, tamper := range tests {
+		t.Run(name, func(t *testing.T) {
+			msg := newTestNotification()
+			signer.sign(t, &msg, "2")
+			tamper(&msg)
+			err := handler.verify(ctx, msg)
+			if err == nil {
+				t.Error("verify() succeeded")
+			}
+		})
+	}
+}
+
+func TestHTTPCertificateFetcherRejectsNonSnsURLs(t *testing.T) {
+	fetched := false
+	fetcher := NewHTTPCertificateFetcher(&http.Client{Transport: roundTripFunc(func(*http.Request) (*http.Response, error) {
+		fetched = true
+		return nil, errors.New("unexpected request")
+	})})
+
+	for _, certURL := range []string{
+		"http://sns.us-east-1.amazonaws.com/cert.pem",
+		"https://sns.us-east-1.amazonaws.com.example.com/cert.pem",
+		"https://example.com/sns.us-east-1.amazonaws.com/cert.pem",
+		"https://s3.amazonaws.com/cert.pem",
+	} {
+		_, err := fetcher.FetchCertificate(context.Background(), certURL)
+		if err == nil {
+			t.Errorf("FetchCertificate(%s) succeeded", certURL)
+		}
+	}
+	if fetched {
+		t.Error("a certificate was fetched from a non SNS URL")
+	}
+}
+
+// roundTripFunc is an http.RoundTripper calling a function.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestSnsHTTPHandler(t *testing.T) {
+	signer := newSnsTestSigner(t)
+
+	var confirmed []string
+	confirmClient := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		confirmed = append(confirmed, r.URL.String())
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: r}, nil
+	})}
+
+	var handled []string
+	var handlerErr error
+	newHandler := func() *SnsHTTPHandler {
+		return NewSnsHTTPHandler(func(ctx context.Context, body string) error {
+			info, _ := MessageInfoFromContext(ctx)
+			if info.TopicArn != testTopicArn {
+				t.Errorf("MessageInfo.TopicArn = %q, want %q", info.TopicArn, testTopicArn)
+			}
+			handled = append(handled, body)
+			return handlerErr
+		}).WithCertificateFetcher(signer).WithHTTPClient(confirmClient)
+	}
+
+	signed := func(msg snsHTTPMessage) string {
+		signer.sign(t, &msg, "2")
+		b, err := json.Marshal(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+	otherTopic := newTestNotification()
+	otherTopic.TopicArn = "arn:aws:sns:us-east-1:123456789012:other"
+	badSignature := newTestNotification()
+	signer.sign(t, &badSignature, "2")
+	badSignature.Message = "tampered"
+	badSignatureBody, _ := json.Marshal(badSignature)
+	unsubscribe := newTestSubscriptionConfirmation()
+	unsubscribe.Type = snsTypeUnsubscribeConfirmation
+	unknown := newTestNotification()
+	unknown.Type = "Unknown"
+
+	tests := []struct {
+		name          string
+		handler       *SnsHTTPHandler
+		method        string
+		body          string
+		handlerErr    error
+		wantStatus    int
+		wantHandled   bool
+		wantConfirmed bool
+	}{
+		{name: "notification", handler: newHandler(), body: signed(newTestNotification()), wantStatus: http.StatusOK, wantHandled: true},
+		{name: "allowed topic", handler: newHandler().WithTopicArns(testTopicArn), body: signed(newTestNotification()), wantStatus: http.StatusOK, wantHandled: true},
+		{name: "unexpected topic", handler: newHandler().WithTopicArns(testTopicArn), body: signed(otherTopic), wantStatus: http.StatusForbidden},
+		{name: "bad signature", handler: newHandler(), body: string(badSignatureBody), wantStatus: http.StatusForbidden},
+		{name: "invalid json", handler: newHandler(), body: "{", wantStatus: http.StatusBadRequest},
+		{name: "get", handler: newHandler(), method: http.MethodGet, wantStatus: http.StatusMethodNotAllowed},
+		{name: "unknown type", handler: newHandler(), body: signed(unknown), wantStatus: http.StatusBadRequest},
+		{name: "handler failure", handler: newHandler(), body: signed(newTestNotification()), handlerErr: errors.New("failed"), wantStatus: http.StatusInternalServerError, wantHandled: true},
+		{name: "permanent failure", handler: newHandler(), body: signed(newTestNotification()), handlerErr: Permanent(errors.New("failed")), wantStatus: http.StatusUnprocessableEntity, wantHandled: true},
+		{name: "confirmation", handler: newHandler().WithTopicArns(testTopicArn), body: signed(newTestSubscriptionConfirmation()), wantStatus: http.StatusOK, wantConfirmed: true},
+		{name: "confirmation without allowed topics", handler: newHandler(), body: signed(newTestSubscriptionConfirmation()), wantStatus: http.StatusForbidden},
+		{name: "unsubscribe confirmation", handler: newHandler(), body: signed(unsubscribe), wantStatus: http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handled, confirmed, handlerErr = nil, nil, test.handlerErr
+
+			method := test.method
+			if method == "" {
+				method = http.MethodPost
+			}
+			w := httptest.NewRecorder()
+			test.handler.ServeHTTP(w, httptest.NewRequest(method, "/sns", strings.NewReader(test.body)))
+
+			if w.Code != test.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, test.wantStatus)
+			}
+			if got := len(handled) > 0; got != test.wantHandled {
+				t.Errorf("handled = %v, want %v", got, test.wantHandled)
+			}
+			if got := len(confirmed) > 0; got != test.wantConfirmed {
+				t.Errorf("confirmed = %v, want %v", got, test.wantConfirmed)
+			}
+			if test.wantConfirmed && confirmed[0] != newTestSubscriptionConfirmation().SubscribeURL {
+				t.Errorf("confirmed %s, want %s", confirmed[0], newTestSubscriptionConfirmation().SubscribeURL)
+			}
+		})
+	}
+}
+
+func TestSnsHTTPHandlerRejectsNonSnsSubscribeURL(t *testing.T) {
+	signer := newSnsTestSigner(t)
+	handler := NewSnsHTTPHandler(nil).WithCertificateFetcher(signer).WithTopicArns(testTopicArn).
+		WithHTTPClient(&http.Client{Transport: roundTripFunc(func(*http.Request) (*http.Response, error) {
+			t.Error("unexpected confirmation request")
+			return nil, errors.New("unexpected request")
+		})})
+
+	msg := newTestSubscriptionConfirmation()
+	msg.SubscribeURL = "https://example.com/confirm"
+	signer.sign(t, &msg, "1")
+	b, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/sns", bytes.NewReader(b)))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+}
@@ -2,22 +2,51 @@ package sub
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"strings"
+
 	awstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
 )
 
 type StringHandlerFn func(ctx context.Context, msg string) error
 
+// snsTypeNotification is the Type of the SNS notifications wrapping published messages.
+const snsTypeNotification = "Notification"
 
 type SnsWrapper struct {
+	// Type of the SNS message, "Notification" for published messages.
+	Type string `json:"Type,omitempty"`
+
+	// MessageID is the ID SNS assigned to the message.
+	MessageID string `json:"MessageId,omitempty"`
+
+	// TopicArn is the ARN of the topic the message was published to.
+	TopicArn string `json:"TopicArn,omitempty"`
+
+	// Subject is the subject the message was published with, if any.
+	Subject string `json:"Subject,omitempty"`
+
+	// Timestamp is the time at which SNS received the message, in RFC 3339 format.
+	Timestamp string `json:"Timestamp,omitempty"`
+
 	Message string `json:"Message"`
+
+	// MessageAttributes are the attributes the message was published with.
+	MessageAttributes map[string]SnsMessageAttribute `json:"MessageAttributes,omitempty"`
+}
+
+// SnsMessageAttribute is a message attribute of an SNS notification.
+type SnsMessageAttribute struct {
+	Type  string `json:"Type"`
+	Value string `json:"Value"`
 }
 
 // StringHandlerToSnsWrapperHandler wraps an SNS message payload into a handler that extracts
 // the 'Message' field and passes it to the provided SNS message handler.
 func StringHandlerToSnsWrapperHandler(handler StringHandlerFn) func(context.Context, SnsWrapper) error {
 	return func(ctx context.Context, sw SnsWrapper) error {
-		return handler(ctx, sw.Message)
+		return handler(withSnsNotification(ctx, sw), sw.Message)
 	}
 }
 
@@ -27,6 +56,55 @@ func StringHandlerToSqsHandler(handler StringHandlerFn) SqsHandlerFn {
 		if message.Body == nil {
 			return errors.New("body is nil.")
 		}
-		return handler(ctx, *message.Body)
+		return handler(WithMessageInfo(ctx, sqsMessageInfo(message)), *message.Body)
+	}
+}
+
+// StringHandlerToAutoDetectSqsHandler wraps a string handler fxn and returns an sqs handler fxn that detects for
+// each message whether it is an SNS notification, in which case the notification's 'Message' field is passed to
+// the handler, or a raw delivery, in which case the body itself is. This lets a queue hold both shapes at once,
+// e.g. while raw message delivery is being switched on or off for its subscription.
+func StringHandlerToAutoDetectSqsHandler(handler StringHandlerFn) SqsHandlerFn {
+	return func(ctx context.Context, message awstypes.Message) error {
+		ctx, body, err := unwrapSqsMessage(ctx, message)
+		if err != nil {
+			return err
+		}
+		return handler(ctx, body)
+	}
+}
+
+// unwrapSqsMessage returns the body of message, unwrapped from its SNS notification if it has one, and a context
+// carrying its MessageInfo.
+func unwrapSqsMessage(ctx context.Context, message awstypes.Message) (context.Context, string, error) {
+	if message.Body == nil {
+		return ctx, "", errors.New("body is nil.")
 	}
+
+	ctx = WithMessageInfo(ctx, sqsMessageInfo(message))
+	if sw, ok := parseSnsNotification(*message.Body); ok {
+		return withSnsNotification(ctx, sw), sw.Message, nil
+	}
+	return ctx, *message.Body, nil
+}
+
+// parseSnsNotification parses body as an SNS notification. It reports false if body is not a JSON object with
+// the "Type": "Notification", "TopicArn" and "Message" fields of an SNS notification envelope.
+func parseSnsNotification(body string) (SnsWrapper, bool) {
+	if !strings.HasPrefix(strings.TrimSpace(body), "{") {
+		return SnsWrapper{}, false
+	}
+
+	var probe struct {
+		SnsWrapper
+		Message *string `json:"Message"`
+	}
+	err := json.Unmarshal([]byte(body), &probe)
+	if err != nil || probe.Type != snsTypeNotification || probe.TopicArn == "" || probe.Message == nil {
+		return SnsWrapper{}, false
+	}
+
+	sw := probe.SnsWrapper
+	sw.Message = *probe.Message
+	return sw, true
 }
@@ -0,0 +1,85 @@
+package sub
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+func TestParseSnsNotification(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		want        bool
+		wantMessage string
+	}{
+		{
+			name:        "notification",
+			body:        `{"Type":"Notification","MessageId":"sns-1","TopicArn":"arn:aws:sns:us-east-1:123456789012:notes","Message":"{\"event_id\":\"1\"}"}`,
+			want:        true,
+			wantMessage: `{"event_id":"1"}`,
+		},
+		{
+			name: "notification with an empty message",
+			body: `{"Type":"Notification","TopicArn":"arn:aws:sns:us-east-1:123456789012:notes","Message":""}`,
+			want: true,
+		},
+		{
+			name:        "leading whitespace",
+			body:        "\n  " + `{"Type":"Notification","TopicArn":"arn:aws:sns:us-east-1:123456789012:notes","Message":"hello"}`,
+			want:        true,
+			wantMessage: "hello",
+		},
+		// raw deliveries of JSON events, even those with fields named like the notification's.
+		{name: "raw event", body: `{"event_id":"1","resource_type":"note"}`},
+		{name: "raw event with a message field", body: `{"Type":"Notification","Message":"hello"}`},
+		{name: "subscription confirmation", body: `{"Type":"SubscriptionConfirmation","TopicArn":"arn:aws:sns:us-east-1:123456789012:notes","Message":"confirm"}`},
+		{name: "missing message", body: `{"Type":"Notification","TopicArn":"arn:aws:sns:us-east-1:123456789012:notes"}`},
+		{name: "not json", body: "CgExEgRub3Rl"},
+		{name: "invalid json", body: `{"Type":`},
+		{name: "empty", body: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sw, ok := parseSnsNotification(test.body)
+			if ok != test.want {
+				t.Fatalf("parseSnsNotification() = %v, want %v", ok, test.want)
+			}
+			if sw.Message != test.wantMessage {
+				t.Errorf("parseSnsNotification() message = %q, want %q", sw.Message, test.wantMessage)
+			}
+		})
+	}
+}
+
+func TestStringHandlerToAutoDetectSqsHandler(t *testing.T) {
+	const event = `{"event_id":"1","resource_type":"note"}`
+	message := publishedMessage{body: event, attributes: map[string]string{"content_type": "application/json"}}
+
+	for name, raw := range map[string]bool{"raw": true, "sns wrapped": false} {
+		t.Run(name, func(t *testing.T) {
+			var got string
+			handler := StringHandlerToAutoDetectSqsHandler(func(_ context.Context, body string) error {
+				got = body
+				return nil
+			})
+
+			err := handler(context.Background(), sqsDelivery(t, message, raw))
+			if err != nil {
+				t.Fatalf("handler() = %v", err)
+			}
+			if got != event {
+				t.Errorf("handler received %q, want %q", got, event)
+			}
+		})
+	}
+
+	handler := StringHandlerToAutoDetectSqsHandler(func(context.Context, string) error { return nil })
+	err := handler(context.Background(), awstypes.Message{MessageId: aws.String("1")})
+	if err == nil {
+		t.Error("handler() of a message without a body succeeded")
+	}
+}
@@ -0,0 +1,301 @@
+package sub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	defaultRestartBackoff    = time.Second
+	defaultMaxRestartBackoff = time.Minute
+)
+
+// ErrDuplicateProcessor is returned by Supervisor.Add when a processor with the same name is already registered.
+var ErrDuplicateProcessor = errors.New("duplicate processor name")
+
+// Runner is a processor that can be run by a Supervisor, such as SqsEventProcessor. Run must block until ctx is
+// cancelled, in which case it returns nil, or until the processor fails.
+type Runner interface {
+	Run(ctx context.Context) error
+}
+
+// ProcessorState is the state of a processor run by a Supervisor.
+type ProcessorState string
+
+const (
+	// ProcessorStatePending is the state of a processor that was added but not started yet.
+	ProcessorStatePending ProcessorState = "pending"
+	// ProcessorStateRunning is the state of a processor whose Run method is running.
+	ProcessorStateRunning ProcessorState = "running"
+	// ProcessorStateBackingOff is the state of a processor that failed and is waiting to be restarted.
+	ProcessorStateBackingOff ProcessorState = "backing_off"
+	// ProcessorStateStopped is the state of a processor that won't be restarted, because the supervisor was shut
+	// down, the processor returned without an error or it failed too many times in a row.
+	ProcessorStateStopped ProcessorState = "stopped"
+)
+
+// ProcessorStatus is a snapshot of the state of a processor run by a Supervisor.
+type ProcessorStatus struct {
+	Name  string         `json:"name"`
+	State ProcessorState `json:"state"`
+
+	// Paused reports whether the processor is paused, see SqsEventProcessor.Pause. It is only set for processors
+	// that can be paused.
+	Paused bool `json:"paused,omitempty"`
+
+	// Restarts is the number of times the processor was restarted after a failure.
+	Restarts int `json:"restarts"`
+
+	// LastError is the last error the processor failed with, if any.
+	LastError string `json:"last_error,omitempty"`
+
+	// LastErrorTime is the time at which the processor last failed.
+	LastErrorTime time.Time `json:"last_error_time,omitzero"`
+
+	// RunningSince is the time at which the processor was last (re)started, if it is running.
+	RunningSince time.Time `json:"running_since,omitzero"`
+
+	// LastReceive is the time of the processor's last successful receive. It is only set for processors that
+	// report it, such as SqsEventProcessor.
+	LastReceive time.Time `json:"last_receive,omitzero"`
+
+	// HandlingSince is the time at which the processor started handling its current message, if any. It is only
+	// set for processors that report it, such as SqsEventProcessor.
+	HandlingSince time.Time `json:"handling_since,omitzero"`
+}
+
+// lastReceiver is implemented by processors that track the time of their last successful receive.
+type lastReceiver interface {
+	LastReceive() time.Time
+}
+
+// pausable is implemented by processors that can be paused.
+type pausable interface {
+	Paused() bool
+}
+
+// handlingReporter is implemented by processors that track since when they are handling their current message.
+type handlingReporter interface {
+	HandlingSince() time.Time
+}
+
+// Supervisor runs several named processors concurrently and restarts those that fail, with a capped exponential
+// backoff between restarts. Shutting down the supervisor, by cancelling the context passed to Run, shuts down all
+// of its processors.
+type Supervisor struct {
+	logger            zerolog.Logger
+	restartBackoff    time.Duration
+	maxRestartBackoff time.Duration
+	maxRestarts       int
+
+	mu         sync.Mutex
+	started    bool
+	processors []*supervisedProcessor
+}
+
+type supervisedProcessor struct {
+	name   string
+	runner Runner
+
+	// guarded by Supervisor.mu
+	state         ProcessorState
+	runningSince  time.Time
+	restarts      int
+	lastError     error
+	lastErrorTime time.Time
+}
+
+// NewSupervisor creates a Supervisor without processors.
+func NewSupervisor() *Supervisor {
+	return &Supervisor{
+		logger:            zerolog.Nop(),
+		restartBackoff:    defaultRestartBackoff,
+		maxRestartBackoff: defaultMaxRestartBackoff,
+	}
+}
+
+// WithLogger sets the logger for the Supervisor.
+func (s *Supervisor) WithLogger(logger zerolog.Logger) *Supervisor {
+	s.logger = logger
+	return s
+}
+
+// WithRestartBackoff sets the delay before restarting a processor after its first consecutive failure. The delay
+// doubles with each consecutive failure, up to maxDelay. Defaults to 1s and 1m.
+func (s *Supervisor) WithRestartBackoff(initial, maxDelay time.Duration) *Supervisor {
+	s.restartBackoff = initial
+	s.maxRestartBackoff = maxDelay
+	return s
+}
+
+// WithMaxRestarts sets the number of consecutive failures of a processor after which the supervisor gives up,
+// shuts down the other processors and returns the processor's error from Run. A processor that ran for longer than
+// the maximum restart backoff before failing starts counting again. Zero, the default, restarts forever.
+func (s *Supervisor) WithMaxRestarts(n int) *Supervisor {
+	s.maxRestarts = n
+	return s
+}
+
+// Add registers a processor under name. Processors must be added before Run is called.
+func (s *Supervisor) Add(name string, runner Runner) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.started {
+		return errors.New("cannot add a processor to a running supervisor")
+	}
+	for _, p := range s.processors {
+		if p.name == name {
+			return fmt.Errorf("%w: %s", ErrDuplicateProcessor, name)
+		}
+	}
+
+	s.processors = append(s.processors, &supervisedProcessor{
+		name:   name,
+		runner: runner,
+		state:  ProcessorStatePending,
+	})
+	return nil
+}
+
+// Run runs all processors until ctx is cancelled, restarting the ones that fail. It returns nil once all
+// processors have stopped after ctx was cancelled, or the error of a processor that failed more than the maximum
+// number of restarts, see WithMaxRestarts.
+func (s *Supervisor) Run(ctx context.Context) error {
+	s.mu.Lock()
+	if s.started {
+		s.mu.Unlock()
+		return errors.New("supervisor is already running")
+	}
+	s.started = true
+	processors := s.processors
+	s.mu.Unlock()
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	var wg sync.WaitGroup
+	for _, p := range processors {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := s.supervise(ctx, p)
+			if err != nil {
+				cancel(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	err := context.Cause(ctx)
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return nil
+	}
+	return err
+}
+
+// Status returns the status of all processors, in the order they were added.
+func (s *Supervisor) Status() []ProcessorStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	statuses := make([]ProcessorStatus, 0, len(s.processors))
+	for _, p := range s.processors {
+		status := ProcessorStatus{
+			Name:          p.name,
+			State:         p.state,
+			RunningSince:  p.runningSince,
+			Restarts:      p.restarts,
+			LastErrorTime: p.lastErrorTime,
+		}
+		if p.lastError != nil {
+			status.LastError = p.lastError.Error()
+		}
+		if lr, ok := p.runner.(lastReceiver); ok {
+			status.LastReceive = lr.LastReceive()
+		}
+		if hr, ok := p.runner.(handlingReporter); ok {
+			status.HandlingSince = hr.HandlingSince()
+		}
+		if pr, ok := p.runner.(pausable); ok {
+			status.Paused = pr.Paused()
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// supervise runs p until ctx is cancelled, restarting it when it fails. It returns an error if p failed more than
+// the maximum number of restarts.
+func (s *Supervisor) supervise(ctx context.Context, p *supervisedProcessor) error {
+	logger := s.logger.With().Str("processor", p.name).Logger()
+	defer s.setState(p, ProcessorStateStopped)
+
+	consecutiveFailures := 0
+	for {
+		s.setState(p, ProcessorStateRunning)
+		started := time.Now()
+		err := runRecovered(ctx, p.runner)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err == nil {
+			logger.Warn().Msg("Processor stopped without an error, not restarting it")
+			return nil
+		}
+
+		if time.Since(started) > s.maxRestartBackoff {
+			consecutiveFailures = 0
+		}
+		consecutiveFailures++
+
+		s.mu.Lock()
+		p.lastError = err
+		p.lastErrorTime = time.Now()
+		s.mu.Unlock()
+
+		if s.maxRestarts > 0 && consecutiveFailures > s.maxRestarts {
+			logger.Error().Err(err).Int("consecutive_failures", consecutiveFailures).Msg("Processor failed too many times, giving up")
+			return fmt.Errorf("processor %s failed %d consecutive times: %w", p.name, consecutiveFailures, err)
+		}
+
+		delay := backoffDelay(s.restartBackoff, s.maxRestartBackoff, consecutiveFailures)
+		logger.Error().Err(err).Dur("restart_in", delay).Msg("Processor failed, restarting it")
+		s.setState(p, ProcessorStateBackingOff)
+		if !sleep(ctx, delay) {
+			return nil
+		}
+
+		s.mu.Lock()
+		p.restarts++
+		s.mu.Unlock()
+	}
+}
+
+func (s *Supervisor) setState(p *supervisedProcessor, state ProcessorState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p.state = state
+	p.runningSince = time.Time{}
+	if state == ProcessorStateRunning {
+		p.runningSince = time.Now()
+	}
+}
+
+// runRecovered runs runner, turning a panic into an error so that it is restarted like any other failure. It only
+// covers the goroutine of Run: the processors recover the panics of their handlers themselves, see
+// ErrHandlerPanicked.
+func runRecovered(ctx context.Context, runner Runner) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("processor panicked: %v", r)
+		}
+	}()
+	return runner.Run(ctx)
+}
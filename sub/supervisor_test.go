@@ -0,0 +1,311 @@
+package sub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// runnerFunc adapts a function to the Runner interface.
+type runnerFunc func(ctx context.Context) error
+
+func (f runnerFunc) Run(ctx context.Context) error {
+	return f(ctx)
+}
+
+// blockingRunner returns a runner blocking until its context is cancelled.
+func blockingRunner() Runner {
+	return runnerFunc(func(ctx context.Context) error {
+		<-ctx.Done()
+		return nil
+	})
+}
+
+// waitFor waits for cond to hold, failing the test if it doesn't within a few seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// runSupervisor runs supervisor in the background and returns a function cancelling it and returning the error of
+// its Run method.
+func runSupervisor(t *testing.T, supervisor *Supervisor) func() error {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- supervisor.Run(ctx)
+	}()
+
+	t.Cleanup(cancel)
+	return func() error {
+		cancel()
+		select {
+		case err := <-done:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatal("supervisor didn't stop")
+			return nil
+		}
+	}
+}
+
+func statusOf(supervisor *Supervisor, name string) ProcessorStatus {
+	for _, status := range supervisor.Status() {
+		if status.Name == name {
+			return status
+		}
+	}
+	return ProcessorStatus{}
+}
+
+func TestSupervisorRestartBackoff(t *testing.T) {
+	const failures = 4
+	initial, maxDelay := 20*time.Millisecond, 40*time.Millisecond
+
+	var mu sync.Mutex
+	var starts []time.Time
+	runner := runnerFunc(func(ctx context.Context) error {
+		mu.Lock()
+		starts = append(starts, time.Now())
+		n := len(starts)
+		mu.Unlock()
+
+		if n <= failures {
+			return fmt.Errorf("failure %d", n)
+		}
+		<-ctx.Done()
+		return nil
+	})
+
+	supervisor := NewSupervisor().WithRestartBackoff(initial, maxDelay)
+	err := supervisor.Add("notes", runner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := runSupervisor(t, supervisor)
+
+	waitFor(t, "the restarts", func() bool {
+		return statusOf(supervisor, "notes").State == ProcessorStateRunning && statusOf(supervisor, "notes").Restarts == failures
+	})
+
+	status := statusOf(supervisor, "notes")
+	if status.LastError != fmt.Sprintf("failure %d", failures) || status.LastErrorTime.IsZero() {
+		t.Errorf("LastError = %q at %v, want failure %d", status.LastError, status.LastErrorTime, failures)
+	}
+	if status.RunningSince.IsZero() {
+		t.Error("RunningSince is zero for a running processor")
+	}
+
+	// the delay doubles with each consecutive failure up to maxDelay, with up to half of it as jitter.
+	mu.Lock()
+	defer mu.Unlock()
+	for i := 1; i < len(starts); i++ {
+		delay := min(initial<<(i-1), maxDelay)
+		if gap := starts[i].Sub(starts[i-1]); gap < delay/2 {
+			t.Errorf("restart %d after %v, want at least %v", i, gap, delay/2)
+		}
+	}
+
+	err = stop()
+	if err != nil {
+		t.Errorf("Run() = %v", err)
+	}
+}
+
+func TestSupervisorStates(t *testing.T) {
+	failed := make(chan struct{})
+	var once sync.Once
+	runner := runnerFunc(func(ctx context.Context) error {
+		once.Do(func() { close(failed) })
+		return errors.New("boom")
+	})
+	returning := runnerFunc(func(context.Context) error { return nil })
+
+	supervisor := NewSupervisor().WithRestartBackoff(time.Hour, time.Hour)
+	for name, runner := range map[string]Runner{"failing": runner, "returning": returning, "blocking": blockingRunner()} {
+		err := supervisor.Add(name, runner)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, status := range supervisor.Status() {
+		if status.State != ProcessorStatePending {
+			t.Errorf("%s is %s before Run, want pending", status.Name, status.State)
+		}
+	}
+
+	stop := runSupervisor(t, supervisor)
+	<-failed
+	waitFor(t, "the failing processor to back off", func() bool {
+		return statusOf(supervisor, "failing").State == ProcessorStateBackingOff
+	})
+	// a processor returning without an error isn't restarted.
+	waitFor(t, "the returning processor to stop", func() bool {
+		return statusOf(supervisor, "returning").State == ProcessorStateStopped
+	})
+	if state := statusOf(supervisor, "blocking").State; state != ProcessorStateRunning {
+		t.Errorf("blocking processor is %s, want running", state)
+	}
+
+	err := stop()
+	if err != nil {
+		t.Errorf("Run() = %v", err)
+	}
+	for _, status := range supervisor.Status() {
+		if status.State != ProcessorStateStopped {
+			t.Errorf("%s is %s after the shutdown, want stopped", status.Name, status.State)
+		}
+	}
+}
+
+func TestSupervisorShutdown(t *testing.T) {
+	const n = 3
+
+	var stopped sync.WaitGroup
+	stopped.Add(n)
+	supervisor := NewSupervisor()
+	for i := range n {
+		err := supervisor.Add(fmt.Sprintf("processor-%d", i), runnerFunc(func(ctx context.Context) error {
+			<-ctx.Done()
+			stopped.Done()
+			return nil
+		}))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stop := runSupervisor(t, supervisor)
+	waitFor(t, "the processors to start", func() bool {
+		for _, status := range supervisor.Status() {
+			if status.State != ProcessorStateRunning {
+				return false
+			}
+		}
+		return true
+	})
+
+	// Run only returns once every processor saw the cancellation.
+	err := stop()
+	if err != nil {
+		t.Errorf("Run() = %v", err)
+	}
+	stopped.Wait()
+}
+
+func TestSupervisorMaxRestarts(t *testing.T) {
+	errBroken := errors.New("broken")
+	var cancelled bool
+	supervisor := NewSupervisor().WithRestartBackoff(time.Millisecond, time.Millisecond).WithMaxRestarts(2)
+	err := supervisor.Add("broken", runnerFunc(func(context.Context) error { return errBroken }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = supervisor.Add("healthy", runnerFunc(func(ctx context.Context) error {
+		<-ctx.Done()
+		cancelled = true
+		return nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the supervisor gives up on the broken processor and shuts down the healthy one.
+	err = supervisor.Run(context.Background())
+	if !errors.Is(err, errBroken) {
+		t.Errorf("Run() = %v, want %v", err, errBroken)
+	}
+	if !cancelled {
+		t.Error("the healthy processor wasn't shut down")
+	}
+	if status := statusOf(supervisor, "broken"); status.Restarts != 2 {
+		t.Errorf("broken processor restarted %d times, want 2", status.Restarts)
+	}
+}
+
+func TestSupervisorRecoversPanic(t *testing.T) {
+	var calls int
+	var mu sync.Mutex
+	runner := runnerFunc(func(ctx context.Context) error {
+		mu.Lock()
+		calls++
+		first := calls == 1
+		mu.Unlock()
+
+		if first {
+			panic("processor bug")
+		}
+		<-ctx.Done()
+		return nil
+	})
+
+	supervisor := NewSupervisor().WithRestartBackoff(time.Millisecond, time.Millisecond)
+	err := supervisor.Add("notes", runner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := runSupervisor(t, supervisor)
+
+	waitFor(t, "the restart", func() bool {
+		status := statusOf(supervisor, "notes")
+		return status.State == ProcessorStateRunning && status.Restarts == 1
+	})
+	if status := statusOf(supervisor, "notes"); !strings.Contains(status.LastError, "processor bug") {
+		t.Errorf("LastError = %q, want the panic", status.LastError)
+	}
+
+	err = stop()
+	if err != nil {
+		t.Errorf("Run() = %v", err)
+	}
+}
+
+func TestSupervisorAdd(t *testing.T) {
+	supervisor := NewSupervisor()
+	err := supervisor.Add("notes", blockingRunner())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = supervisor.Add("notes", blockingRunner())
+	if !errors.Is(err, ErrDuplicateProcessor) {
+		t.Errorf("Add() of a duplicate name = %v, want ErrDuplicateProcessor", err)
+	}
+
+	stop := runSupervisor(t, supervisor)
+	waitFor(t, "the processor to start", func() bool {
+		return statusOf(supervisor, "notes").State == ProcessorStateRunning
+	})
+	err = supervisor.Add("folders", blockingRunner())
+	if err == nil {
+		t.Error("Add() to a running supervisor succeeded")
+	}
+	err = stop()
+	if err != nil {
+		t.Errorf("Run() = %v", err)
+	}
+}
+
+func TestProcessorStatusJSON(t *testing.T) {
+	b, err := json.Marshal(ProcessorStatus{Name: "notes", State: ProcessorStateBackingOff, LastError: "boom"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"name":"notes","state":"backing_off","restarts":0,"last_error":"boom"}`
+	if string(b) != want {
+		t.Errorf("json.Marshal() = %s, want %s", b, want)
+	}
+}
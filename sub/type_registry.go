@@ -0,0 +1,139 @@
+package sub
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/Iknite-Space/psss/models"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/rs/zerolog"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// ErrUnknownProtoType is returned when the proto message type of an event can't be determined by a TypeRegistry.
+var ErrUnknownProtoType = errors.New("unknown proto type")
+
+// MessageTypeResolver finds proto message types by full name. It is implemented by protoregistry.GlobalTypes and
+// *protoregistry.Types.
+type MessageTypeResolver interface {
+	FindMessageByName(name protoreflect.FullName) (protoreflect.MessageType, error)
+}
+
+// TypeRegistry maps resource types to the proto message type of their Before and After payloads, to decode events
+// of any resource type without compile-time generics, see WithTypeRegistry.
+//
+// The type of an event is the one named by its ProtoType, when its publisher records it (see
+// pub.SNSPublisher.WithProtoType), or else the one registered for its ResourceType.
+type TypeRegistry struct {
+	resolver MessageTypeResolver
+
+	mu    sync.RWMutex
+	types map[string]protoreflect.MessageType
+}
+
+// NewTypeRegistry creates an empty TypeRegistry resolving type names with protoregistry.GlobalTypes, which holds the
+// types of every generated proto package linked into the binary.
+func NewTypeRegistry() *TypeRegistry {
+	return &TypeRegistry{
+		resolver: protoregistry.GlobalTypes,
+		types:    make(map[string]protoreflect.MessageType),
+	}
+}
+
+// WithResolver sets the resolver used to find the types named by the events and by RegisterName, e.g. a
+// *protoregistry.Types built from descriptors loaded at runtime.
+func (r *TypeRegistry) WithResolver(resolver MessageTypeResolver) *TypeRegistry {
+	r.resolver = resolver
+	return r
+}
+
+// Register registers the type of message as the type of the events of resourceType, replacing any type registered
+// before. message is only used for its type and may be a typed nil pointer, e.g. (*notesv1.Note)(nil).
+func (r *TypeRegistry) Register(resourceType string, message proto.Message) *TypeRegistry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.types[resourceType] = message.ProtoReflect().Type()
+	return r
+}
+
+// RegisterName registers the type with the full name name, found with the resolver, as the type of the events of
+// resourceType.
+func (r *TypeRegistry) RegisterName(resourceType string, name protoreflect.FullName) error {
+	mt, err := r.resolver.FindMessageByName(name)
+	if err != nil {
+		return fmt.Errorf("failed to find proto type %s: %w", name, err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.types[resourceType] = mt
+	return nil
+}
+
+// MessageType returns the proto message type of the events of resourceType whose envelope names protoType, which
+// may be empty.
+func (r *TypeRegistry) MessageType(resourceType, protoType string) (protoreflect.MessageType, error) {
+	if protoType != "" {
+		mt, err := r.resolver.FindMessageByName(protoreflect.FullName(protoType))
+		if err != nil {
+			return nil, fmt.Errorf("%w %s: %w", ErrUnknownProtoType, protoType, err)
+		}
+		return mt, nil
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	mt, ok := r.types[resourceType]
+	if !ok {
+		return nil, fmt.Errorf("%w: no type registered for resource type %q", ErrUnknownProtoType, resourceType)
+	}
+	return mt, nil
+}
+
+// WithTypeRegistry creates the Before and After messages of each event with the type registry returns for it, instead
+// of calling newMessage, which can then be nil. This is mostly useful with proto.Message as the type parameter of
+// the handler, e.g. for audit or replication services handling every resource type:
+//
+//	handler := sub.MutationEventHandlerToStringHandler[proto.Message](auditEvent, nil, sub.WithTypeRegistry(registry))
+//
+// With a concrete type parameter, events of another type fail to decode.
+func WithTypeRegistry(registry *TypeRegistry) MutationEventOption {
+	return func(cfg *mutationEventConfig) {
+		cfg.typeRegistry = registry
+	}
+}
+
+// NewDynamicMutationEventSqsProcessor creates an SQS event processor decoding mutation events of any resource type
+// with the types of registry, see WithTypeRegistry.
+func NewDynamicMutationEventSqsProcessor(svc *sqs.Client, queueURL string, registry *TypeRegistry, handler ProtoMutationEventHandlerFn[proto.Message], opts ...MutationEventOption) *SqsEventProcessor {
+	opts = append(opts, WithTypeRegistry(registry))
+	stringHandler := MutationEventHandlerToStringHandler(handler, nil, opts...)
+
+	return &SqsEventProcessor{
+		svc:       svc,
+		queueURL:  queueURL,
+		logger:    zerolog.Nop(),
+		handlerFn: StringHandlerToAutoDetectSqsHandler(stringHandler),
+		configErr: newMutationEventConfig(opts).validate(),
+	}
+}
+
+// registryNewMessage returns a function creating messages of the type registry returns for envelope.
+func registryNewMessage[T proto.Message](registry *TypeRegistry, envelope models.PublishedProtoMutationEvent) (func() T, error) {
+	mt, err := registry.MessageType(envelope.ResourceType, envelope.ProtoType)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := mt.Zero().Interface().(T); !ok {
+		var want T
+		return nil, fmt.Errorf("proto type %s of resource type %q is not %T", mt.Descriptor().FullName(), envelope.ResourceType, want)
+	}
+
+	return func() T {
+		return mt.New().Interface().(T)
+	}, nil
+}
@@ -0,0 +1,197 @@
+package sub
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Iknite-Space/psss/models"
+	"github.com/Iknite-Space/psss/pub"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/smithy-go/middleware"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestTypeRegistry(t *testing.T) {
+	registry := NewTypeRegistry().
+		Register("note", (*wrapperspb.Int32Value)(nil)).
+		Register("note", (*wrapperspb.StringValue)(nil))
+	err := registry.RegisterName("count", "google.protobuf.Int64Value")
+	if err != nil {
+		t.Fatalf("RegisterName() = %v", err)
+	}
+	err = registry.RegisterName("folder", "notes.v1.Folder")
+	if err == nil {
+		t.Error("RegisterName() of an unknown type succeeded")
+	}
+
+	tests := []struct {
+		name         string
+		resourceType string
+		protoType    string
+		want         protoreflect.FullName
+		wantErr      error
+	}{
+		{name: "registered", resourceType: "note", want: "google.protobuf.StringValue"},
+		{name: "registered by name", resourceType: "count", want: "google.protobuf.Int64Value"},
+		{name: "unknown resource type", resourceType: "folder", wantErr: ErrUnknownProtoType},
+		{name: "proto type", resourceType: "folder", protoType: "google.protobuf.BoolValue", want: "google.protobuf.BoolValue"},
+		{name: "proto type over resource type", resourceType: "note", protoType: "google.protobuf.BytesValue", want: "google.protobuf.BytesValue"},
+		{name: "unknown proto type", resourceType: "note", protoType: "notes.v1.Note", wantErr: ErrUnknownProtoType},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mt, err := registry.MessageType(test.resourceType, test.protoType)
+			if test.wantErr != nil {
+				if !errors.Is(err, test.wantErr) {
+					t.Errorf("MessageType() = %v, want %v", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("MessageType() = %v", err)
+			}
+			if got := mt.Descriptor().FullName(); got != test.want {
+				t.Errorf("MessageType() = %s, want %s", got, test.want)
+			}
+		})
+	}
+
+	// types are only found with the resolver.
+	empty := NewTypeRegistry().WithResolver(new(protoregistry.Types))
+	err = empty.RegisterName("note", "google.protobuf.StringValue")
+	if err == nil {
+		t.Error("RegisterName() of a type unknown to the resolver succeeded")
+	}
+	_, err = empty.MessageType("note", "google.protobuf.StringValue")
+	if !errors.Is(err, ErrUnknownProtoType) {
+		t.Errorf("MessageType() of a type unknown to the resolver = %v, want ErrUnknownProtoType", err)
+	}
+}
+
+func TestRegistryNewMessage(t *testing.T) {
+	registry := NewTypeRegistry().
+		Register("note", (*wrapperspb.StringValue)(nil)).
+		Register("count", (*wrapperspb.Int64Value)(nil))
+
+	newMessage, err := registryNewMessage[*wrapperspb.StringValue](registry, models.PublishedProtoMutationEvent{ResourceType: "note"})
+	if err != nil {
+		t.Fatalf("registryNewMessage() = %v", err)
+	}
+	if m := newMessage(); m == nil {
+		t.Error("registryNewMessage() created a nil message")
+	}
+
+	// the type of the events of another resource type doesn't match the type parameter.
+	_, err = registryNewMessage[*wrapperspb.StringValue](registry, models.PublishedProtoMutationEvent{ResourceType: "count"})
+	if err == nil || errors.Is(err, ErrUnknownProtoType) {
+		t.Errorf("registryNewMessage() of another type = %v, want a type mismatch", err)
+	}
+	_, err = registryNewMessage[*wrapperspb.StringValue](registry, models.PublishedProtoMutationEvent{
+		ResourceType: "note",
+		ProtoType:    "google.protobuf.Int64Value",
+	})
+	if err == nil || errors.Is(err, ErrUnknownProtoType) {
+		t.Errorf("registryNewMessage() of another proto type = %v, want a type mismatch", err)
+	}
+
+	_, err = registryNewMessage[*wrapperspb.StringValue](registry, models.PublishedProtoMutationEvent{ResourceType: "folder"})
+	if !errors.Is(err, ErrUnknownProtoType) {
+		t.Errorf("registryNewMessage() of an unknown resource type = %v, want ErrUnknownProtoType", err)
+	}
+}
+
+// publishedMessage is a message published to SNS.
+type publishedMessage struct {
+	body       string
+	attributes map[string]string
+}
+
+// newRecordingSnsClient returns an SNS client recording the messages it publishes instead of sending them.
+func newRecordingSnsClient(published *[]publishedMessage) *sns.Client {
+	record := middleware.InitializeMiddlewareFunc("record", func(ctx context.Context, in middleware.InitializeInput, _ middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+		input := in.Parameters.(*sns.PublishInput)
+		message := publishedMessage{body: aws.ToString(input.Message), attributes: make(map[string]string)}
+		for name, value := range input.MessageAttributes {
+			message.attributes[name] = aws.ToString(value.StringValue)
+		}
+		*published = append(*published, message)
+		return middleware.InitializeOutput{Result: &sns.PublishOutput{MessageId: aws.String("1")}}, middleware.Metadata{}, nil
+	})
+
+	return sns.New(sns.Options{
+		Region: "us-east-1",
+		APIOptions: []func(*middleware.Stack) error{
+			func(stack *middleware.Stack) error {
+				return stack.Initialize.Add(record, middleware.Before)
+			},
+		},
+	})
+}
+
+func TestTypeRegistryDecodesPublishedProtoType(t *testing.T) {
+	ctx := context.Background()
+
+	// the resource type is registered with another type, which the proto type recorded by the publisher overrides.
+	registry := NewTypeRegistry().Register("note", (*wrapperspb.Int64Value)(nil))
+
+	event := models.ProtoMutationEvent[*wrapperspb.StringValue]{
+		EventType:    models.EventTypeUpdated,
+		ResourceType: "note",
+		ResourceID:   "1",
+		Before:       wrapperspb.String("before"),
+		After:        wrapperspb.String("after"),
+	}
+
+	publishers := map[string]func(client *sns.Client) *pub.SNSPublisher[*wrapperspb.StringValue]{
+		"json": func(client *sns.Client) *pub.SNSPublisher[*wrapperspb.StringValue] {
+			return pub.NewPubService[*wrapperspb.StringValue](client, "arn:aws:sns:us-east-1:123456789012:notes")
+		},
+		"protobuf payload": func(client *sns.Client) *pub.SNSPublisher[*wrapperspb.StringValue] {
+			return pub.NewPubService[*wrapperspb.StringValue](client, "arn:aws:sns:us-east-1:123456789012:notes").
+				WithEncoding(pub.EncodingProtobufPayload)
+		},
+		"protobuf": func(client *sns.Client) *pub.SNSPublisher[*wrapperspb.StringValue] {
+			return pub.NewPubService[*wrapperspb.StringValue](client, "arn:aws:sns:us-east-1:123456789012:notes").
+				WithEncoding(pub.EncodingProtobuf)
+		},
+		"cloudevents structured": func(client *sns.Client) *pub.SNSPublisher[*wrapperspb.StringValue] {
+			return pub.NewPubService[*wrapperspb.StringValue](client, "arn:aws:sns:us-east-1:123456789012:notes").
+				WithEnvelope(pub.EnvelopeCloudEventsStructured)
+		},
+		"cloudevents binary": func(client *sns.Client) *pub.SNSPublisher[*wrapperspb.StringValue] {
+			return pub.NewPubService[*wrapperspb.StringValue](client, "arn:aws:sns:us-east-1:123456789012:notes").
+				WithEnvelope(pub.EnvelopeCloudEventsBinary)
+		},
+	}
+
+	for name, newPublisher := range publishers {
+		t.Run(name, func(t *testing.T) {
+			var published []publishedMessage
+			err := newPublisher(newRecordingSnsClient(&published)).WithProtoType().Publish(ctx, event)
+			if err != nil {
+				t.Fatalf("Publish() = %v", err)
+			}
+
+			var got models.ProtoMutationEvent[proto.Message]
+			handler := MutationEventHandlerToStringHandler(func(_ context.Context, e models.ProtoMutationEvent[proto.Message]) error {
+				got = e
+				return nil
+			}, nil, WithTypeRegistry(registry))
+
+			message := published[0]
+			err = handler(WithMessageInfo(ctx, MessageInfo{MessageAttributes: message.attributes}), message.body)
+			if err != nil {
+				t.Fatalf("handler() = %v", err)
+			}
+			if !proto.Equal(got.Before, event.Before) || !proto.Equal(got.After, event.After) {
+				t.Errorf("decoded Before = %v, After = %v, want %v, %v", got.Before, got.After, event.Before, event.After)
+			}
+		})
+	}
+}
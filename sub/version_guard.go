@@ -0,0 +1,309 @@
+package sub
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Iknite-Space/psss/models"
+	"github.com/rs/zerolog"
+	"google.golang.org/protobuf/proto"
+)
+
+const defaultMaxHold = 30 * time.Second
+
+// VersionStore records the sequence of the last event handled for each resource, see
+// models.ProtoMutationEvent.Sequence. Implementations shared by several consumers, e.g. backed by a database,
+// must make AdvanceSequence a conditional write.
+type VersionStore interface {
+	// LastSequence returns the sequence of the last event handled for the resource, or 0 if none was recorded.
+	LastSequence(ctx context.Context, resourceType, resourceID string) (uint64, error)
+
+	// AdvanceSequence records sequence as the sequence of the last event handled for the resource, unless a
+	// greater or equal sequence is already recorded, in which case it reports false.
+	AdvanceSequence(ctx context.Context, resourceType, resourceID string, sequence uint64) (bool, error)
+}
+
+// MemoryVersionStore is a VersionStore keeping sequences in memory, for tests and consumers running as a single
+// instance that can afford to forget the sequences when they restart. It never forgets a resource.
+type MemoryVersionStore struct {
+	mu        sync.Mutex
+	sequences map[coalesceKey]uint64
+}
+
+var _ VersionStore = (*MemoryVersionStore)(nil)
+
+// NewMemoryVersionStore creates an empty MemoryVersionStore.
+func NewMemoryVersionStore() *MemoryVersionStore {
+	return &MemoryVersionStore{sequences: make(map[coalesceKey]uint64)}
+}
+
+// LastSequence implements VersionStore.
+func (s *MemoryVersionStore) LastSequence(_ context.Context, resourceType, resourceID string) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sequences[coalesceKey{resourceType: resourceType, resourceID: resourceID}], nil
+}
+
+// AdvanceSequence implements VersionStore.
+func (s *MemoryVersionStore) AdvanceSequence(_ context.Context, resourceType, resourceID string, sequence uint64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := coalesceKey{resourceType: resourceType, resourceID: resourceID}
+	if s.sequences[key] >= sequence {
+		return false, nil
+	}
+	s.sequences[key] = sequence
+	return true, nil
+}
+
+// VersionPolicy is what a VersionGuard does with events that are not the next event of their resource.
+type VersionPolicy uint8
+
+const (
+	// VersionPolicyDrop acknowledges stale and duplicate events without handling them. Events following a gap are
+	// handled.
+	VersionPolicyDrop VersionPolicy = iota
+
+	// VersionPolicyFlag handles every event. VersionCheckFromContext tells the handler whether the event is stale,
+	// a duplicate or follows a gap.
+	VersionPolicyFlag
+
+	// VersionPolicyHold drops stale and duplicate events, like VersionPolicyDrop, and holds events following a gap
+	// until the missing events are handled or the maximum hold time expires, see VersionGuard.WithMaxHold.
+	VersionPolicyHold
+)
+
+// VersionStatus is how the sequence of an event compares to the last sequence handled for its resource.
+type VersionStatus uint8
+
+const (
+	// VersionCurrent is the next event of the resource, or the first one the guard sees.
+	VersionCurrent VersionStatus = iota
+
+	// VersionGap follows events of the resource that were not handled yet.
+	VersionGap
+
+	// VersionDuplicate has the sequence of the last event handled for the resource.
+	VersionDuplicate
+
+	// VersionStale is older than the last event handled for the resource.
+	VersionStale
+)
+
+// String returns the name of the status, e.g. "stale".
+func (s VersionStatus) String() string {
+	switch s {
+	case VersionCurrent:
+		return "current"
+	case VersionGap:
+		return "gap"
+	case VersionDuplicate:
+		return "duplicate"
+	case VersionStale:
+		return "stale"
+	default:
+		return fmt.Sprintf("VersionStatus(%d)", uint8(s))
+	}
+}
+
+// VersionCheck is the result of checking the sequence of an event.
+type VersionCheck struct {
+	Status VersionStatus
+
+	// LastSequence is the sequence of the last event handled for the resource when the event was checked, 0 if
+	// none was.
+	LastSequence uint64
+}
+
+type versionCheckKey struct{}
+
+// VersionCheckFromContext returns the VersionCheck of the event being handled. It reports false if the handler
+// wasn't wrapped by a VersionGuard or the event has no sequence.
+func VersionCheckFromContext(ctx context.Context) (VersionCheck, bool) {
+	check, ok := ctx.Value(versionCheckKey{}).(VersionCheck)
+	return check, ok
+}
+
+// VersionGuard checks the sequence of mutation events against the last sequence handled for their resource, to
+// detect the stale, duplicate and missing events caused by SQS reordering and redelivering messages. The events
+// of a resource are handled one at a time. Events without a sequence are handled without checks.
+type VersionGuard struct {
+	store   VersionStore
+	policy  VersionPolicy
+	maxHold time.Duration
+	logger  zerolog.Logger
+
+	mu        sync.Mutex
+	resources map[coalesceKey]*guardedResource
+}
+
+// guardedResource serializes the events of a resource and wakes held events when its sequence advances.
+type guardedResource struct {
+	lock    chan struct{}
+	changed chan struct{}
+	refs    int
+}
+
+// NewVersionGuard creates a VersionGuard recording sequences in store and applying policy.
+func NewVersionGuard(store VersionStore, policy VersionPolicy) *VersionGuard {
+	return &VersionGuard{
+		store:     store,
+		policy:    policy,
+		maxHold:   defaultMaxHold,
+		logger:    zerolog.Nop(),
+		resources: make(map[coalesceKey]*guardedResource),
+	}
+}
+
+// WithMaxHold sets how long VersionPolicyHold holds an event waiting for the missing events, after which it is
+// handled anyway. Held messages are in flight, so maxHold must be well below their visibility timeout and the
+// processor must handle several messages concurrently, see SqsEventProcessor.WithConcurrency. Defaults to 30s.
+func (g *VersionGuard) WithMaxHold(maxHold time.Duration) *VersionGuard {
+	g.maxHold = maxHold
+	return g
+}
+
+// WithLogger sets the logger for the VersionGuard.
+func (g *VersionGuard) WithLogger(logger zerolog.Logger) *VersionGuard {
+	g.logger = logger
+	return g
+}
+
+// WithVersionGuard checks the events with guard before they are handled. With WithCoalescing, the guard checks the
+// summarized events, whose sequences skip those of the events they summarize, so it can't be combined with
+// VersionPolicyHold. Batch processors don't support this option.
+func WithVersionGuard(guard *VersionGuard) MutationEventOption {
+	return func(cfg *mutationEventConfig) {
+		cfg.versionGuard = guard
+	}
+}
+
+// guardMutationEvents wraps handler so that the events it receives are checked by guard.
+func guardMutationEvents[T proto.Message](guard *VersionGuard, handler ProtoMutationEventHandlerFn[T]) ProtoMutationEventHandlerFn[T] {
+	return func(ctx context.Context, event models.ProtoMutationEvent[T]) error {
+		if event.Sequence == 0 {
+			return handler(ctx, event)
+		}
+		return guard.handle(ctx, event.ResourceType, event.ResourceID, event.Sequence, func(ctx context.Context) error {
+			return handler(ctx, event)
+		})
+	}
+}
+
+// handle checks sequence and, according to the policy, calls handle with a context carrying the VersionCheck. The
+// sequence of the resource is advanced when handle succeeds.
+func (g *VersionGuard) handle(ctx context.Context, resourceType, resourceID string, sequence uint64, handle func(context.Context) error) error {
+	key := coalesceKey{resourceType: resourceType, resourceID: resourceID}
+	resource := g.acquire(key)
+	defer g.release(key)
+
+	deadline := time.Now().Add(g.maxHold)
+	var check VersionCheck
+	for {
+		select {
+		case resource.lock <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		last, err := g.store.LastSequence(ctx, resourceType, resourceID)
+		if err != nil {
+			<-resource.lock
+			return fmt.Errorf("failed to read the last sequence of the resource: %w", err)
+		}
+		check = VersionCheck{Status: versionStatus(sequence, last), LastSequence: last}
+
+		remaining := time.Until(deadline)
+		if check.Status != VersionGap || g.policy != VersionPolicyHold || remaining <= 0 {
+			break
+		}
+
+		// wait for the sequence to advance, or the hold to expire, and check again.
+		changed := resource.changed
+		<-resource.lock
+		timer := time.NewTimer(remaining)
+		select {
+		case <-changed:
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+		timer.Stop()
+	}
+	defer func() { <-resource.lock }()
+
+	logger := g.logger.With().Str("resource_type", resourceType).Str("resource_id", resourceID).
+		Uint64("sequence", sequence).Uint64("last_sequence", check.LastSequence).Logger()
+
+	stale := check.Status == VersionStale || check.Status == VersionDuplicate
+	if stale && g.policy != VersionPolicyFlag {
+		logger.Info().Stringer("status", check.Status).Msg("Dropping out of order event")
+		return nil
+	}
+	if check.Status == VersionGap {
+		logger.Warn().Msg("Handling event following missing events")
+	}
+
+	err := handle(context.WithValue(ctx, versionCheckKey{}, check))
+	if err != nil || stale {
+		return err
+	}
+
+	advanced, err := g.store.AdvanceSequence(ctx, resourceType, resourceID, sequence)
+	if err != nil {
+		return fmt.Errorf("failed to record the sequence of the resource: %w", err)
+	}
+	if !advanced {
+		logger.Debug().Msg("Sequence of the resource was advanced concurrently")
+	}
+
+	close(resource.changed)
+	resource.changed = make(chan struct{})
+	return nil
+}
+
+// versionStatus compares the sequence of an event to the last sequence handled for its resource. Any sequence is
+// current for a resource without a recorded sequence, since the guard may be started after its first events.
+func versionStatus(sequence, last uint64) VersionStatus {
+	switch {
+	case last == 0 || sequence == last+1:
+		return VersionCurrent
+	case sequence > last:
+		return VersionGap
+	case sequence == last:
+		return VersionDuplicate
+	default:
+		return VersionStale
+	}
+}
+
+func (g *VersionGuard) acquire(key coalesceKey) *guardedResource {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	resource, ok := g.resources[key]
+	if !ok {
+		resource = &guardedResource{
+			lock:    make(chan struct{}, 1),
+			changed: make(chan struct{}),
+		}
+		g.resources[key] = resource
+	}
+	resource.refs++
+	return resource
+}
+
+func (g *VersionGuard) release(key coalesceKey) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	resource := g.resources[key]
+	resource.refs--
+	if resource.refs == 0 {
+		delete(g.resources, key)
+	}
+}
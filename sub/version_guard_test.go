@@ -0,0 +1,152 @@
+package sub
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Iknite-Space/psss/models"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestVersionGuard(t *testing.T) {
+	ctx := context.Background()
+
+	// handle passes the sequences to the guard in order and returns those that were handled, with their status.
+	handle := func(guard *VersionGuard, sequences ...uint64) []VersionCheck {
+		t.Helper()
+
+		var checks []VersionCheck
+		for _, sequence := range sequences {
+			err := guard.handle(ctx, "notes", "1", sequence, func(ctx context.Context) error {
+				check, ok := VersionCheckFromContext(ctx)
+				if !ok {
+					t.Fatalf("no VersionCheck in the context of event %d", sequence)
+				}
+				checks = append(checks, check)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("handle(%d) = %v", sequence, err)
+			}
+		}
+		return checks
+	}
+
+	t.Run("drop", func(t *testing.T) {
+		guard := NewVersionGuard(NewMemoryVersionStore(), VersionPolicyDrop)
+		checks := handle(guard, 3, 4, 4, 2, 6)
+
+		want := []VersionCheck{
+			{Status: VersionCurrent, LastSequence: 0},
+			{Status: VersionCurrent, LastSequence: 3},
+			{Status: VersionGap, LastSequence: 4},
+		}
+		assertChecks(t, checks, want)
+	})
+
+	t.Run("flag", func(t *testing.T) {
+		store := NewMemoryVersionStore()
+		guard := NewVersionGuard(store, VersionPolicyFlag)
+		checks := handle(guard, 1, 2, 2, 1)
+
+		want := []VersionCheck{
+			{Status: VersionCurrent, LastSequence: 0},
+			{Status: VersionCurrent, LastSequence: 1},
+			{Status: VersionDuplicate, LastSequence: 2},
+			{Status: VersionStale, LastSequence: 2},
+		}
+		assertChecks(t, checks, want)
+
+		last, _ := store.LastSequence(ctx, "notes", "1")
+		if last != 2 {
+			t.Errorf("last sequence = %d, want 2", last)
+		}
+	})
+
+	t.Run("hold", func(t *testing.T) {
+		guard := NewVersionGuard(NewMemoryVersionStore(), VersionPolicyHold).WithMaxHold(time.Minute)
+		handle(guard, 1)
+
+		// 3 is held until 2 is handled.
+		var mu sync.Mutex
+		var order []uint64
+		var wg sync.WaitGroup
+		for _, sequence := range []uint64{3, 2} {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := guard.handle(ctx, "notes", "1", sequence, func(context.Context) error {
+					mu.Lock()
+					defer mu.Unlock()
+					order = append(order, sequence)
+					return nil
+				})
+				if err != nil {
+					t.Errorf("handle(%d) = %v", sequence, err)
+				}
+			}()
+			time.Sleep(20 * time.Millisecond)
+		}
+		wg.Wait()
+
+		if len(order) != 2 || order[0] != 2 || order[1] != 3 {
+			t.Errorf("handled %v, want [2 3]", order)
+		}
+	})
+
+	t.Run("hold expires", func(t *testing.T) {
+		guard := NewVersionGuard(NewMemoryVersionStore(), VersionPolicyHold).WithMaxHold(20 * time.Millisecond)
+		checks := handle(guard, 1, 3, 2)
+
+		want := []VersionCheck{
+			{Status: VersionCurrent, LastSequence: 0},
+			{Status: VersionGap, LastSequence: 1},
+		}
+		assertChecks(t, checks, want)
+	})
+}
+
+func assertChecks(t *testing.T, got, want []VersionCheck) {
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Fatalf("handled %d events %v, want %d %v", len(got), got, len(want), want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("event %d: check = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestVersionGuardHoldWithCoalescing(t *testing.T) {
+	ctx := context.Background()
+	handler := func(context.Context, models.ProtoMutationEvent[*wrapperspb.StringValue]) error { return nil }
+	newMessage := func() *wrapperspb.StringValue { return &wrapperspb.StringValue{} }
+
+	for _, policy := range []VersionPolicy{VersionPolicyDrop, VersionPolicyFlag, VersionPolicyHold} {
+		opts := []MutationEventOption{
+			WithCoalescing(time.Second),
+			WithVersionGuard(NewVersionGuard(NewMemoryVersionStore(), policy)),
+		}
+		wantUnsupported := policy == VersionPolicyHold
+
+		fake := &fakeSqs{}
+		processor := NewMutationEventSqsProcessor(fake.client(), testQueueURL, newMessage, handler, false, opts...)
+		runCtx, cancel := context.WithCancel(ctx)
+		cancel()
+		err := processor.Run(runCtx)
+		if errors.Is(err, ErrUnsupportedOption) != wantUnsupported {
+			t.Errorf("Run() with policy %d = %v, want ErrUnsupportedOption: %v", policy, err, wantUnsupported)
+		}
+
+		// the body isn't an event, so that the handler fails right away unless the options are rejected first.
+		err = MutationEventHandlerToStringHandler(handler, newMessage, opts...)(ctx, `not an event`)
+		if errors.Is(err, ErrUnsupportedOption) != wantUnsupported {
+			t.Errorf("handler with policy %d = %v, want ErrUnsupportedOption: %v", policy, err, wantUnsupported)
+		}
+	}
+}